@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Access roles of calendar list entries that do not let the tracker write events. A free/busy
+// reader does not see event titles either, so payments cannot be found in such a calendar.
+const (
+	accessFreeBusyReader = "freeBusyReader"
+	accessReader         = "reader"
+)
+
+// loadCalendarAccess looks up the access role of the calendars in the user's calendar list, by ID.
+// Calendars missing from the list, and those of services that report no roles, are assumed to be
+// writable until a write is refused.
+func loadCalendarAccess(ctx context.Context, srv CalendarProvider) map[string]string {
+	access := make(map[string]string)
+	list, err := srv.ListCalendars(ctx)
+	if err != nil {
+		slog.Warn("Unable to check calendar access, assuming full access", "error", err)
+		return access
+	}
+	for _, entry := range list {
+		if entry.AccessRole == "" {
+			continue
+		}
+		access[entry.Id] = entry.AccessRole
+		if entry.Primary {
+			account, _ := splitAccount(entry.Id)
+			access[joinAccount(account, "primary")] = entry.AccessRole
+		}
+	}
+	return access
+}
+
+// canWriteEvents reports whether the tracker may write events to a calendar.
+func canWriteEvents(config Config, calendarID string) bool {
+	role := config.CalendarAccess[calendarID]
+	return role != accessReader && role != accessFreeBusyReader
+}
+
+// applyCalendarAccess leaves free/busy calendars out of the payment calendars and reports what the
+// tracker cannot do with each calendar it has limited access to.
+func applyCalendarAccess(config *Config) {
+	var readable []string
+	for _, calendarID := range config.PaymentCalendars {
+		switch config.CalendarAccess[calendarID] {
+		case accessFreeBusyReader:
+			slog.Warn("Calendar only shares free/busy times, its payments are left out of the totals", "calendar", calendarID)
+			continue
+		case accessReader:
+			if config.MarkPaid {
+				slog.Warn("Calendar is read only, its payments are counted but not marked as paid", "calendar", calendarID)
+			}
+		}
+		readable = append(readable, calendarID)
+	}
+	config.PaymentCalendars = readable
+	if !canWriteEvents(*config, config.TargetCalendar) {
+		slog.Warn("Calendar is read only, the \"Total Remaining\" events are only logged", "calendar", config.TargetCalendar)
+	}
+}
+
+// isAccessDenied reports whether a calendar refused a write because the tracker may only read it, as
+// opposed to a rate limit, which Google reports with the same status.
+func isAccessDenied(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == http.StatusForbidden && !isQuotaError(apiErr.Message+apiErr.Body)
+}
+
+// denyWrites records that a calendar refused a write, so no more are attempted in this run.
+func denyWrites(config Config, calendarID string) {
+	if config.CalendarAccess != nil && canWriteEvents(config, calendarID) {
+		config.CalendarAccess[calendarID] = accessReader
+	}
+}
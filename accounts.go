@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// accountNamePattern matches the names GOOGLE_ACCOUNTS gives the extra Google accounts.
+var accountNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseAccounts reads the extra Google accounts in the format "partner=/secrets/partner-token.json",
+// mapping each account's name to the file its token is kept in.
+func parseAccounts(value string) map[string]string {
+	accounts := make(map[string]string)
+	for _, entry := range parseCalendarList(value) {
+		name, tokenFile, found := strings.Cut(entry, "=")
+		name, tokenFile = strings.TrimSpace(name), strings.TrimSpace(tokenFile)
+		if !found || !accountNamePattern.MatchString(name) || tokenFile == "" {
+			slog.Warn("Ignoring invalid GOOGLE_ACCOUNTS entry, expected name=token file", "entry", entry)
+			continue
+		}
+		accounts[name] = tokenFile
+	}
+	return accounts
+}
+
+// splitAccount splits a calendar of another account, written "partner:Bills" or "partner:primary",
+// into the account's name and the calendar within it. Other calendars belong to the main account,
+// whose name is empty. Google calendar IDs never contain a colon.
+func splitAccount(calendarID string) (account, id string) {
+	if name, rest, found := strings.Cut(calendarID, ":"); found && accountNamePattern.MatchString(name) && !isICSFile(calendarID) {
+		return name, rest
+	}
+	return "", calendarID
+}
+
+// joinAccount names a calendar of an account the way splitAccount reads it.
+func joinAccount(account, calendarID string) string {
+	if account == "" {
+		return calendarID
+	}
+	return account + ":" + calendarID
+}
+
+// accountTokenFile returns the file the token of an account is kept in, the main account's for "".
+func accountTokenFile(config Config, account string) string {
+	if account == "" {
+		return getTokenFilePath()
+	}
+	return config.Accounts[account]
+}
+
+// accountNames returns the names of the Google accounts, the main account's "" first and the rest in
+// alphabetical order.
+func accountNames(config Config) []string {
+	names := []string{""}
+	for account := range config.Accounts {
+		names = append(names, account)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// accountRouter sends calendar operations to the Google account each calendar belongs to, so the
+// calendars of several accounts are totalled together and each write goes to the right account.
+type accountRouter struct {
+	accounts map[string]CalendarProvider // By account name, "" for the main account
+}
+
+// route returns the provider of the account holding a calendar and the calendar's ID within it. A
+// prefix that names no configured account is taken to be part of a main account calendar ID.
+func (r *accountRouter) route(calendarID string) (CalendarProvider, string) {
+	account, id := splitAccount(calendarID)
+	if p, ok := r.accounts[account]; ok {
+		return p, id
+	}
+	return r.accounts[""], calendarID
+}
+
+func (r *accountRouter) ListEvents(ctx context.Context, calendarID string, query EventQuery) ([]*calendar.Event, error) {
+	p, id := r.route(calendarID)
+	return p.ListEvents(ctx, id, query)
+}
+
+func (r *accountRouter) GetEvent(ctx context.Context, calendarID, eventID string) (*calendar.Event, error) {
+	p, id := r.route(calendarID)
+	return p.GetEvent(ctx, id, eventID)
+}
+
+func (r *accountRouter) InsertEvent(ctx context.Context, calendarID string, event *calendar.Event) (*calendar.Event, error) {
+	p, id := r.route(calendarID)
+	return p.InsertEvent(ctx, id, event)
+}
+
+func (r *accountRouter) PatchEvent(ctx context.Context, calendarID, eventID, etag string, event *calendar.Event) error {
+	p, id := r.route(calendarID)
+	return p.PatchEvent(ctx, id, eventID, etag, event)
+}
+
+func (r *accountRouter) UpdateEvent(ctx context.Context, calendarID, eventID, etag string, event *calendar.Event) error {
+	p, id := r.route(calendarID)
+	return p.UpdateEvent(ctx, id, eventID, etag, event)
+}
+
+func (r *accountRouter) DeleteEvent(ctx context.Context, calendarID, eventID, etag string) error {
+	p, id := r.route(calendarID)
+	return p.DeleteEvent(ctx, id, eventID, etag)
+}
+
+// ListCalendars lists the calendars of every account. Those of the extra accounts have their ID and
+// names prefixed with the account's name, so "partner:Bills" resolves to the partner's calendar.
+func (r *accountRouter) ListCalendars(ctx context.Context) ([]*calendar.CalendarListEntry, error) {
+	var entries []*calendar.CalendarListEntry
+	for account, p := range r.accounts {
+		list, err := p.ListCalendars(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range list {
+			if account != "" {
+				copied := *entry
+				copied.Id = joinAccount(account, entry.Id)
+				copied.Summary = joinAccount(account, entry.Summary)
+				if entry.SummaryOverride != "" {
+					copied.SummaryOverride = joinAccount(account, entry.SummaryOverride)
+				}
+				entry = &copied
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// CreateCalendar creates a calendar in the main account.
+func (r *accountRouter) CreateCalendar(ctx context.Context, name, timeZone string) (string, error) {
+	return r.accounts[""].CreateCalendar(ctx, name, timeZone)
+}
+
+func (r *accountRouter) DeleteCalendar(ctx context.Context, calendarID string) error {
+	p, id := r.route(calendarID)
+	return p.DeleteCalendar(ctx, id)
+}
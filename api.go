@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// apiMaxRange is the longest date range /api/v1/payments answers for, so a single request cannot page
+// through years of events at the expense of the Calendar API quota.
+const apiMaxRange = 366 * 24 * time.Hour
+
+// apiPeriod is a pay period as /api/v1/periods/current reports it. Amounts are in the configured
+// currency, apart from Foreign, which is by currency symbol.
+type apiPeriod struct {
+	Start      string             `json:"start"`
+	End        string             `json:"end"`
+	Name       string             `json:"name,omitempty"`
+	Remaining  float64            `json:"remaining"` // The amount the "Total Remaining" event shows
+	Formatted  string             `json:"formatted"` // Remaining as the event shows it
+	Payments   int                `json:"payments"`  // Number of upcoming payments in the total
+	Categories map[string]float64 `json:"categories"`
+	Foreign    map[string]float64 `json:"foreign,omitempty"`
+	Income     *float64           `json:"income,omitempty"` // Only when INCOME_SOURCE is set
+	Paid       *float64           `json:"paid,omitempty"`   // Only when INCOME_SOURCE is set
+	Currency   string             `json:"currency"`
+	UpdatedAt  time.Time          `json:"updatedAt"`
+}
+
+// apiPayment is a payment event as /api/v1/payments reports it. Currency is the symbol of the
+// amount, which need not be the configured currency; payments without an amount are left out.
+type apiPayment struct {
+	ID        string  `json:"id"`
+	Date      string  `json:"date"`
+	Summary   string  `json:"summary"`
+	Amount    float64 `json:"amount"`
+	Formatted string  `json:"formatted"` // Amount as its currency writes it
+	Currency  string  `json:"currency"`
+	Category  string  `json:"category,omitempty"`
+	Paid      bool    `json:"paid"`
+}
+
+// apiServer serves the JSON API with the run loop's connection. The current period is cached like the
+// dashboard, while payments are fetched for each request's own range.
+type apiServer struct {
+	conn        *connection
+	token       string        // Bearer token every request must carry, empty for none
+	recalculate chan struct{} // Asks the run loop for a sync
+	threshold   float64       // TRIGGER_TOTAL_BELOW, the default threshold of the total-below trigger
+	currency    Currency      // Formats the threshold in total-below triggers
+	windows     []MaintenanceWindow
+	mu          sync.Mutex
+	period      apiPeriod
+	builtAt     time.Time
+}
+
+// startAPIServer serves the JSON API on API_ADDR in the background. Recalculations are handed to the
+// run loop through recalculate.
+func startAPIServer(config Config, conn *connection, recalculate chan struct{}) {
+	addr := config.APIAddr
+	api := &apiServer{conn: conn, token: config.APIToken, threshold: config.TriggerBelow, currency: config.Currency, windows: config.MaintenanceWindows, recalculate: recalculate}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/periods/current", api.authorized(http.MethodGet, api.currentPeriod))
+	mux.HandleFunc("/api/v1/payments", api.authorized(http.MethodGet, api.payments))
+	mux.HandleFunc("/api/v1/recalculate", api.authorized(http.MethodPost, api.requestRecalculation))
+	mux.HandleFunc("/api/v1/triggers/new-payments", api.authorized(http.MethodGet, api.newPaymentTriggers))
+	mux.HandleFunc("/api/v1/triggers/total-below", api.authorized(http.MethodGet, api.totalBelowTriggers))
+	maintenanceStatus := api.authorized(http.MethodGet, api.maintenanceStatus)
+	setMaintenance := api.authorized(http.MethodPost, api.setMaintenance)
+	mux.HandleFunc("/api/v1/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			setMaintenance(w, r)
+			return
+		}
+		maintenanceStatus(w, r)
+	})
+
+	go func() {
+		slog.Info("Serving the API", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("API server stopped", "error", err)
+		}
+	}()
+}
+
+// authorized wraps an endpoint so it only answers requests with the given method and, when API_TOKEN
+// is set, the token as a bearer token.
+func (api *apiServer) authorized(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if api.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+api.token)) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeAPIError(w, http.StatusMethodNotAllowed, r.Method+" is not supported, use "+method)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// currentPeriod answers GET /api/v1/periods/current with the current pay period's total, recomputing
+// it once it is older than dashboardTTL.
+func (api *apiServer) currentPeriod(w http.ResponseWriter, r *http.Request) {
+	period, err := api.cachedPeriod(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, period)
+}
+
+// cachedPeriod returns the current pay period, recomputing it once it is older than dashboardTTL.
+func (api *apiServer) cachedPeriod(ctx context.Context) (apiPeriod, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if time.Since(api.builtAt) > dashboardTTL {
+		period, err := buildAPIPeriod(ctx, api.conn, time.Now())
+		if err != nil {
+			return apiPeriod{}, err
+		}
+		api.period = period
+		api.builtAt = time.Now()
+	}
+	return api.period, nil
+}
+
+// payments answers GET /api/v1/payments with the payment events between the from and to dates,
+// inclusive, given as YYYY-MM-DD. Either defaults to the bound of the current pay period.
+func (api *apiServer) payments(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), getRunTimeout())
+	defer cancel()
+	srv, config, err := api.conn.current()
+	if err != nil {
+		writeAPIError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	loc, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	from, to := config.Periods.Period(time.Now().In(loc))
+	if value := r.URL.Query().Get("from"); value != "" {
+		if from, err = time.ParseInLocation("2006-01-02", value, loc); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid from date %q, expected YYYY-MM-DD", value))
+			return
+		}
+	}
+	if value := r.URL.Query().Get("to"); value != "" {
+		date, err := time.ParseInLocation("2006-01-02", value, loc)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid to date %q, expected YYYY-MM-DD", value))
+			return
+		}
+		to = date.AddDate(0, 0, 1).Add(-time.Second) // The end of the day
+	}
+	if !to.After(from) {
+		writeAPIError(w, http.StatusBadRequest, "to must not be before from")
+		return
+	}
+	if to.Sub(from) > apiMaxRange {
+		writeAPIError(w, http.StatusBadRequest, "the range must not be longer than a year")
+		return
+	}
+
+	events, err := listPaymentEvents(ctx, srv, from, to, config)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	payments := []apiPayment{}
+	for _, item := range events {
+		if payment, ok := newAPIPayment(item, config, loc); ok {
+			payments = append(payments, payment)
+		}
+	}
+	sort.SliceStable(payments, func(i, j int) bool { return payments[i].Date < payments[j].Date })
+	writeJSON(w, http.StatusOK, payments)
+}
+
+// newAPIPayment converts a payment event for the API, returning false when it has no amount.
+func newAPIPayment(item *calendar.Event, config Config, loc *time.Location) (apiPayment, bool) {
+	payment := apiPayment{
+		ID:       item.Id,
+		Date:     eventStartDate(item, loc).Format("2006-01-02"),
+		Summary:  item.Summary,
+		Category: paymentCategory(item),
+		Paid:     isPaid(item, config),
+	}
+	currency := config.Currency
+	amount, ok := parseAmountFromSummary(item.Summary, currency)
+	if foreign, foreignAmount, isForeign := config.Currency.ParseForeign(item.Summary); isForeign {
+		currency, amount, ok = foreign, foreignAmount, true
+	}
+	if !ok {
+		return apiPayment{}, false
+	}
+	payment.Amount, payment.Formatted, payment.Currency = roundAmount(amount), currency.Format(amount), currency.Symbol
+	return payment, true
+}
+
+// requestRecalculation answers POST /api/v1/recalculate by asking the run loop for a sync. The sync
+// runs in the background; a request made while one is already pending is folded into it.
+func (api *apiServer) requestRecalculation(w http.ResponseWriter, r *http.Request) {
+	select {
+	case api.recalculate <- struct{}{}:
+	default: // A run is already pending
+	}
+	api.mu.Lock()
+	api.builtAt = time.Time{} // Compute the current period afresh on the next request
+	api.mu.Unlock()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+}
+
+// apiMaintenance is the maintenance state as /api/v1/maintenance reports and sets it.
+type apiMaintenance struct {
+	Paused bool `json:"paused"` // Calendar writes are paused, by the flag or a maintenance window
+	Flag   bool `json:"flag"`   // The maintenance flag is set, as "maintenance on" sets it
+}
+
+// maintenanceStatus answers GET /api/v1/maintenance with whether calendar writes are paused.
+func (api *apiServer) maintenanceStatus(w http.ResponseWriter, r *http.Request) {
+	_, err := os.Stat(getMaintenanceFlagPath())
+	writeJSON(w, http.StatusOK, apiMaintenance{Paused: writesPaused(Config{MaintenanceWindows: api.windows}), Flag: err == nil})
+}
+
+// setMaintenance answers POST /api/v1/maintenance, which takes {"flag": true} to pause calendar
+// writes like "maintenance on" and {"flag": false} to resume them. Resuming asks the run loop for a
+// sync, so the changes held back are written straight away.
+func (api *apiServer) setMaintenance(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Flag *bool `json:"flag"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Flag == nil {
+		writeAPIError(w, http.StatusBadRequest, `expected {"flag": true} or {"flag": false}`)
+		return
+	}
+	if err := setMaintenanceFlag(getMaintenanceFlagPath(), *body.Flag); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	slog.Info("Maintenance flag set through the API", "flag", *body.Flag)
+	if !*body.Flag {
+		select {
+		case api.recalculate <- struct{}{}:
+		default: // A run is already pending
+		}
+	}
+	api.maintenanceStatus(w, r)
+}
+
+// buildAPIPeriod computes the current pay period's total as of now. Nothing is written.
+func buildAPIPeriod(ctx context.Context, conn *connection, now time.Time) (apiPeriod, error) {
+	ctx, cancel := context.WithTimeout(ctx, getRunTimeout())
+	defer cancel()
+	srv, config, err := conn.current()
+	if err != nil {
+		return apiPeriod{}, err
+	}
+	loc, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		return apiPeriod{}, err
+	}
+	now = now.In(loc)
+	startDate, endDate := config.Periods.Period(now)
+	total, err := currentPeriodTotal(ctx, srv, startDate, endDate, config, now)
+	if err != nil {
+		return apiPeriod{}, err
+	}
+
+	period := apiPeriod{
+		Start:      startDate.Format("2006-01-02"),
+		End:        endDate.Format("2006-01-02"),
+		Name:       periodName(config.Periods, startDate),
+		Remaining:  roundAmount(total.Remaining(config)),
+		Formatted:  formatRemaining(total, config),
+		Payments:   len(total.Payments),
+		Categories: make(map[string]float64),
+		Foreign:    make(map[string]float64),
+		Currency:   config.Currency.Symbol,
+		UpdatedAt:  now,
+	}
+	for category, amount := range total.Categories {
+		period.Categories[category] = roundAmount(amount)
+	}
+	for symbol, amount := range total.Foreign {
+		period.Foreign[symbol] = roundAmount(amount)
+	}
+	if config.IncomeSource != "" {
+		income, paid := roundAmount(total.Income), roundAmount(total.Paid)
+		period.Income, period.Paid = &income, &paid
+	}
+	return period, nil
+}
+
+// writeJSON writes v as the JSON body of a response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error writing API response", "error", err)
+	}
+}
+
+// writeAPIError writes an error response as {"error": message}.
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
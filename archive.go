@@ -0,0 +1,112 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// ArchivedPeriod holds the raw payment calendar events fetched for a pay period, the amounts
+// matched in them by event ID and the total that was computed.
+type ArchivedPeriod struct {
+	Start   string             `json:"start"`
+	End     string             `json:"end"`
+	Total   float64            `json:"total"`
+	Matched map[string]float64 `json:"matched"`
+	Events  []*calendar.Event  `json:"events"`
+}
+
+// Archive is a gzipped snapshot of the Calendar API events behind one run, kept so past
+// calculations can be reproduced exactly.
+type Archive struct {
+	CreatedAt time.Time        `json:"createdAt"`
+	Periods   []ArchivedPeriod `json:"periods"`
+}
+
+// archiveRawEvents writes the events behind the desired events to dir as
+// <time>-<hash>.json.gz. A run whose events and totals match the latest archive writes nothing,
+// so the archive only grows when the calendar changes.
+func archiveRawEvents(dir string, desired []desiredEvent, config Config, now time.Time) error {
+	periods := make([]ArchivedPeriod, 0, len(desired))
+	for _, d := range desired {
+		matched := make(map[string]float64)
+		for _, item := range d.Period.Payments {
+			matched[item.Id], _ = countedAmount(item, config)
+		}
+		periods = append(periods, ArchivedPeriod{
+			Start:   d.Period.Start.Format("2006-01-02"),
+			End:     d.Period.End.Format("2006-01-02"),
+			Total:   d.Period.Amount,
+			Matched: matched,
+			Events:  d.Period.Events,
+		})
+	}
+
+	content, err := json.Marshal(periods)
+	if err != nil {
+		return fmt.Errorf("unable to encode events: %v", err)
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:8])
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("unable to create archive directory: %v", err)
+	}
+	files, err := listArchives(dir)
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 && strings.HasSuffix(files[len(files)-1], "-"+hash+".json.gz") {
+		return nil
+	}
+
+	path := filepath.Join(dir, now.UTC().Format("20060102T150405Z")+"-"+hash+".json.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create archive file: %v", err)
+	}
+	defer f.Close()
+	zw := gzip.NewWriter(f)
+	if err := json.NewEncoder(zw).Encode(Archive{CreatedAt: now, Periods: periods}); err != nil {
+		return fmt.Errorf("unable to write archive file: %v", err)
+	}
+	return zw.Close()
+}
+
+// listArchives returns the archive files in dir, oldest first.
+func listArchives(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json.gz"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list archive directory: %v", err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// loadArchive reads a single archive file.
+func loadArchive(path string) (*Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open archive file: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read archive file %s: %v", path, err)
+	}
+	defer zr.Close()
+	archive := &Archive{}
+	if err := json.NewDecoder(zr).Decode(archive); err != nil {
+		return nil, fmt.Errorf("unable to read archive file %s: %v", path, err)
+	}
+	return archive, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"path/filepath"
+)
+
+// runBacktestCommand handles "backtest": it re-runs the current amount parser over the raw events in
+// the archive and reports every payment and payment total that would now come out differently.
+func runBacktestCommand(args []string) error {
+	config := getConfig()
+	fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+	dir := fs.String("archive", config.ArchivePath, "directory of archived runs (default ARCHIVE_PATH)")
+	latest := fs.Bool("latest", false, "only check the most recent archived run")
+	fs.Parse(args)
+
+	if *dir == "" {
+		return fmt.Errorf("no archive to test against, set ARCHIVE_PATH or pass -archive")
+	}
+	files, err := listArchives(*dir)
+	if err != nil {
+		return err
+	}
+	if *latest && len(files) > 0 {
+		files = files[len(files)-1:]
+	}
+
+	var periods, differing int
+	for _, path := range files {
+		archive, err := loadArchive(path)
+		if err != nil {
+			return err
+		}
+		for _, period := range archive.Periods {
+			periods++
+			if diffs := backtestPeriod(period, config); len(diffs) > 0 {
+				differing++
+				fmt.Printf("%s: period %s to %s\n", filepath.Base(path), period.Start, period.End)
+				for _, diff := range diffs {
+					fmt.Printf("  %s\n", diff)
+				}
+			}
+		}
+	}
+
+	fmt.Printf("Checked %d periods in %d archived runs, %d differ\n", periods, len(files), differing)
+	if differing > 0 {
+		return fmt.Errorf("backtest found differences in %d periods", differing)
+	}
+	return nil
+}
+
+// backtestPeriod parses an archived period's events again and describes how the matched payments
+// and their total differ from what was recorded.
+func backtestPeriod(period ArchivedPeriod, config Config) []string {
+	var diffs []string
+	var recordedTotal, total float64
+	for _, amount := range period.Matched {
+		recordedTotal += amount
+	}
+	for _, item := range period.Events {
+		amount, ok := countedAmount(item, config)
+		recorded, wasMatched := period.Matched[item.Id]
+		if ok {
+			total += amount
+		}
+		switch {
+		case ok && !wasMatched:
+			diffs = append(diffs, fmt.Sprintf("+ %q now matches %s", item.Summary, config.Currency.Format(amount)))
+		case !ok && wasMatched:
+			diffs = append(diffs, fmt.Sprintf("- %q no longer matches, was %s", item.Summary, config.Currency.Format(recorded)))
+		case ok && math.Abs(amount-recorded) >= 0.005:
+			diffs = append(diffs, fmt.Sprintf("~ %q now %s, was %s", item.Summary, config.Currency.Format(amount), config.Currency.Format(recorded)))
+		}
+	}
+	if math.Abs(total-recordedTotal) >= 0.005 {
+		diffs = append(diffs, fmt.Sprintf("payments total now %s, was %s", config.Currency.Format(total), config.Currency.Format(recordedTotal)))
+	}
+	return diffs
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// periodBandEvents returns a band for the pay period of each "Total Remaining" event.
+func periodBandEvents(desired []desiredEvent, config Config) []desiredEvent {
+	var bands []desiredEvent
+	for _, d := range desired {
+		if d.Period.Start.IsZero() {
+			continue
+		}
+		bands = append(bands, desiredEvent{Event: newPeriodBandEvent(d.Period, config)})
+	}
+	return bands
+}
+
+// newPeriodBandEvent builds an all-day event spanning a pay period, e.g. "Pay period 25 Jun – 24 Jul"
+// or "Pay period P3: 25 Mar – 28 Apr" for named periods, in a dim colour and marked free so it shows which period each bill belongs to without blocking time.
+func newPeriodBandEvent(period PeriodTotal, config Config) *calendar.Event {
+	zone := calendarTimeZone(config, config.TargetCalendar)
+	start, end := eventDate(config.EventLanguage, period.Start, false), eventDate(config.EventLanguage, period.End, false)
+	summary := fmt.Sprintf(eventText(config.EventLanguage, "Pay period %s – %s"), start, end)
+	if name := periodName(config.Periods, period.Start); name != "" {
+		summary = fmt.Sprintf(eventText(config.EventLanguage, "Pay period %s: %s – %s"), name, start, end)
+	}
+	return &calendar.Event{
+		Summary: summary,
+		Start: &calendar.EventDateTime{
+			Date:     period.Start.Format("2006-01-02"),
+			TimeZone: zone,
+		},
+		End: &calendar.EventDateTime{
+			Date:     period.End.AddDate(0, 0, 1).Format("2006-01-02"),
+			TimeZone: zone,
+		},
+		ColorId:      config.PeriodBandColor,
+		Transparency: "transparent",
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{
+				managedEventProperty: periodBandEventType,
+			},
+		},
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Cadences of the remaining breakdown events, set with BREAKDOWN.
+const (
+	breakdownWeekly = "weekly" // "Remaining this week" on each Monday
+	breakdownDaily  = "daily"  // "Due today" on each day with payments due
+)
+
+// breakdownEvents splits the payments still to come in the current pay period into weeks or days,
+// returning an event for each. A week running into the next pay period includes its payments too.
+func breakdownEvents(desired []desiredEvent, config Config) []desiredEvent {
+	var current *PeriodTotal
+	var payments []*calendar.Event
+	for i, d := range desired {
+		if d.Period.Start.IsZero() || !isManagedEvent(d.Event, totalRemainingEventType) {
+			continue
+		}
+		if current == nil {
+			current = &desired[i].Period
+		}
+		payments = append(payments, d.Period.Payments...)
+	}
+	if current == nil {
+		return nil
+	}
+	loc := current.Start.Location()
+
+	// Group the payments by the first day of their week or by their day
+	buckets := make(map[string][]*calendar.Event)
+	bucketStart := func(t time.Time) time.Time {
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		if config.Breakdown == breakdownWeekly {
+			day = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		}
+		return day
+	}
+	for _, item := range payments {
+		key := bucketStart(eventStartDate(item, loc)).Format("2006-01-02")
+		buckets[key] = append(buckets[key], item)
+	}
+	if config.Breakdown == breakdownWeekly {
+		// Every week of the period still to come gets an event, even one without payments
+		from := time.Now().In(loc)
+		if from.Before(current.Start) {
+			from = current.Start
+		}
+		for day := bucketStart(from); !day.After(current.End); day = day.AddDate(0, 0, 7) {
+			if _, ok := buckets[day.Format("2006-01-02")]; !ok {
+				buckets[day.Format("2006-01-02")] = nil
+			}
+		}
+	}
+
+	var events []desiredEvent
+	for key, items := range buckets {
+		day, _ := time.ParseInLocation("2006-01-02", key, loc)
+		if day.After(current.End) {
+			continue
+		}
+		event, amount := newBreakdownEvent(day, items, config)
+		events = append(events, desiredEvent{Event: event, Amount: amount})
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Event.Start.Date < events[j].Event.Start.Date })
+	return events
+}
+
+// newBreakdownEvent builds the all-day event for a week or day of the breakdown, listing the
+// payments due in it.
+func newBreakdownEvent(day time.Time, payments []*calendar.Event, config Config) (*calendar.Event, float64) {
+	var total float64
+	var lines []string
+	for _, item := range payments {
+		amount, _ := parseAmountFromSummary(item.Summary, config.Currency)
+		total += amount
+		lines = append(lines, fmt.Sprintf("%s %s", eventDate(config.EventLanguage, eventStartDate(item, day.Location()), true), item.Summary))
+	}
+	summary := fmt.Sprintf(eventText(config.EventLanguage, "Due today %s"), config.Currency.Format(total))
+	if config.Breakdown == breakdownWeekly {
+		summary = fmt.Sprintf(eventText(config.EventLanguage, "Remaining this week %s"), config.Currency.Format(total))
+	}
+
+	zone := calendarTimeZone(config, config.TargetCalendar)
+	return &calendar.Event{
+		Summary:     summary,
+		Description: strings.Join(lines, "\n"),
+		Start: &calendar.EventDateTime{
+			Date:     day.Format("2006-01-02"),
+			TimeZone: zone,
+		},
+		End: &calendar.EventDateTime{
+			Date:     day.AddDate(0, 0, 1).Format("2006-01-02"),
+			TimeZone: zone,
+		},
+		ColorId:      config.EventColor,
+		Transparency: "transparent",
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{
+				managedEventProperty: breakdownEventType,
+				amountProperty:       strconv.FormatFloat(total, 'f', 2, 64),
+			},
+		},
+	}, total
+}
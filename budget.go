@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// parseCategoryBudgets reads per-category budgets in the format "groceries:300,#fuel:120".
+func parseCategoryBudgets(value string) map[string]float64 {
+	budgets := make(map[string]float64)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		category, budgetStr, found := strings.Cut(entry, ":")
+		category = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(category), "#"))
+		budget, err := strconv.ParseFloat(strings.TrimSpace(budgetStr), 64)
+		if !found || category == "" || err != nil || budget < 0 {
+			slog.Warn("Ignoring invalid CATEGORY_BUDGETS entry, expected category:budget", "entry", entry)
+			continue
+		}
+		budgets[category] = budget
+	}
+	return budgets
+}
+
+// overBudget lists the budgets a pay period exceeds, one per line, e.g. "#groceries £350.00 of £300.00".
+// The period's bills include the payments already made in it; category totals only cover the ones
+// still to come.
+func overBudget(period PeriodTotal, config Config) (lines []string, over float64) {
+	if bills := period.Amount + period.Paid; config.BudgetLimit > 0 && bills > config.BudgetLimit {
+		lines = append(lines, fmt.Sprintf(eventText(config.EventLanguage, "Bills %s of %s"), config.Currency.Format(bills), config.Currency.Format(config.BudgetLimit)))
+		over = bills - config.BudgetLimit
+	}
+	var categoriesOver float64
+	categories := make([]string, 0, len(config.CategoryBudgets))
+	for category := range config.CategoryBudgets {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		budget, spent := config.CategoryBudgets[category], period.Categories[category]
+		if spent > budget {
+			lines = append(lines, fmt.Sprintf(eventText(config.EventLanguage, "#%s %s of %s"), category, config.Currency.Format(spent), config.Currency.Format(budget)))
+			categoriesOver += spent - budget
+		}
+	}
+	// Category overspending is part of the total's, so it is only reported when the total is within budget
+	if over == 0 {
+		over = categoriesOver
+	}
+	return lines, over
+}
+
+// budgetAlertEvents returns a warning for each pay period over BUDGET_LIMIT or one of its
+// CATEGORY_BUDGETS, on the day of the period's "Total Remaining" event.
+func budgetAlertEvents(desired []desiredEvent, config Config) []desiredEvent {
+	var alerts []desiredEvent
+	for _, d := range desired {
+		if d.Period.Start.IsZero() || !isManagedEvent(d.Event, totalRemainingEventType) {
+			continue
+		}
+		lines, over := overBudget(d.Period, config)
+		if len(lines) == 0 {
+			continue
+		}
+		alerts = append(alerts, desiredEvent{Event: newBudgetAlertEvent(d.Event.Start.Date, lines, over, config), Amount: over})
+	}
+	return alerts
+}
+
+// newBudgetAlertEvent builds the all-day warning event for a pay period over budget, in its own
+// colour so it stands out from the "Total Remaining" event next to it.
+func newBudgetAlertEvent(date string, lines []string, over float64, config Config) *calendar.Event {
+	zone := calendarTimeZone(config, config.TargetCalendar)
+	day, _ := time.Parse("2006-01-02", date)
+	return &calendar.Event{
+		Summary:     fmt.Sprintf(eventText(config.EventLanguage, "Over budget by %s"), config.Currency.Format(over)),
+		Description: strings.Join(lines, "\n"),
+		Start: &calendar.EventDateTime{
+			Date:     date,
+			TimeZone: zone,
+		},
+		End: &calendar.EventDateTime{
+			Date:     day.AddDate(0, 0, 1).Format("2006-01-02"),
+			TimeZone: zone,
+		},
+		ColorId:      config.BudgetAlertColor,
+		Transparency: "transparent",
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{
+				managedEventProperty: budgetAlertEventType,
+				amountProperty:       strconv.FormatFloat(over, 'f', 2, 64),
+			},
+		},
+	}
+}
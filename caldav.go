@@ -0,0 +1,1037 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// The tracker's private properties are kept as JSON in a property of its own on each event.
+const caldavPrivateProperty = "X-PAYMENTTRACKER-PRIVATE"
+
+// caldavAuth is an http.RoundTripper adding basic authentication to CalDAV requests.
+type caldavAuth struct {
+	username, password string
+}
+
+func (a caldavAuth) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(a.username, a.password)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// caldavClient returns the HTTP client for the CalDAV server, signing in with CALDAV_USERNAME and
+// CALDAV_PASSWORD, which for Nextcloud and Fastmail should be an app password.
+func caldavClient() (*http.Client, error) {
+	username := os.Getenv("CALDAV_USERNAME")
+	if username == "" {
+		return nil, fmt.Errorf("CALDAV_USERNAME must be set for PROVIDER=caldav")
+	}
+	return &http.Client{Transport: caldavAuth{username: username, password: os.Getenv("CALDAV_PASSWORD")}}, nil
+}
+
+// caldavHome returns CALDAV_URL, the calendar home holding the user's calendars, such as
+// https://cloud.example.com/remote.php/dav/calendars/alice/ on Nextcloud.
+func caldavHome() (*url.URL, error) {
+	home, err := url.Parse(os.Getenv("CALDAV_URL"))
+	if err != nil || home.Scheme == "" || home.Host == "" {
+		return nil, fmt.Errorf("CALDAV_URL must be set to the calendar home URL for PROVIDER=caldav")
+	}
+	if !strings.HasSuffix(home.Path, "/") {
+		home.Path += "/"
+	}
+	return home, nil
+}
+
+// caldavProvider is a CalDAV server such as Nextcloud, Fastmail or Radicale. Calendar IDs are the
+// names of the calendar collections under CALDAV_URL, "primary" standing for the first calendar the
+// server lists, and event IDs are the names of the event resources, with the start of the
+// occurrence appended for instances of recurring events as Google does. CalDAV has no event
+// colours, so colour IDs are neither read nor written.
+type caldavProvider struct {
+	client   *http.Client
+	home     *url.URL
+	username string
+
+	mu      sync.Mutex
+	primary string // Calendar "primary" stands for, looked up on first use
+}
+
+func newCaldavProvider(client *http.Client) (*caldavProvider, error) {
+	home, err := caldavHome()
+	if err != nil {
+		return nil, err
+	}
+	return &caldavProvider{client: client, home: home, username: os.Getenv("CALDAV_USERNAME")}, nil
+}
+
+// davMultistatus is the reply to PROPFIND and REPORT requests.
+type davMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Prop struct {
+				ETag         string `xml:"DAV: getetag"`
+				DisplayName  string `xml:"DAV: displayname"`
+				CalendarData string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+				ResourceType struct {
+					Calendar *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar"`
+				} `xml:"DAV: resourcetype"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// icalTime formats a time the way CalDAV queries and UTC iCalendar values expect it.
+func icalTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+func (c *caldavProvider) ListEvents(ctx context.Context, calendarID string, query EventQuery) ([]*calendar.Event, error) {
+	zone := query.TimeZone
+	if zone == "" {
+		zone = "UTC"
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load time zone '%s': %v", zone, err)
+	}
+	collection, err := c.calendarURL(ctx, calendarID)
+	if err != nil {
+		return nil, err
+	}
+
+	// With a time range the server expands recurring events into their instances, which needs
+	// both bounds
+	var expand, filter string
+	if !query.TimeMin.IsZero() || !query.TimeMax.IsZero() {
+		timeMin, timeMax := query.TimeMin, query.TimeMax
+		if timeMin.IsZero() {
+			timeMin = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+		}
+		if timeMax.IsZero() {
+			timeMax = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+		}
+		expand = fmt.Sprintf(`<C:expand start="%s" end="%s"/>`, icalTime(timeMin), icalTime(timeMax))
+		filter = fmt.Sprintf(`<C:time-range start="%s" end="%s"/>`, icalTime(timeMin), icalTime(timeMax))
+	}
+	if query.ICalUID != "" {
+		filter += `<C:prop-filter name="UID"><C:text-match collation="i;octet">` + xmlText(query.ICalUID) + `</C:text-match></C:prop-filter>`
+	}
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/><C:calendar-data>` + expand + `</C:calendar-data></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT">` + filter + `</C:comp-filter></C:comp-filter></C:filter>
+</C:calendar-query>`
+
+	var reply davMultistatus
+	if err := c.multistatus(ctx, "REPORT", collection, "1", body, &reply); err != nil {
+		return nil, err
+	}
+	var items []*calendar.Event
+	for _, response := range reply.Responses {
+		for _, propstat := range response.Propstat {
+			if propstat.Prop.CalendarData == "" {
+				continue
+			}
+			cal, err := parseICal(propstat.Prop.CalendarData)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read event %s: %v", response.Href, err)
+			}
+			for _, vevent := range cal.components("VEVENT") {
+				// CalDAV has no free text or property search, so those are matched here
+				if item := veventToEvent(resourceName(response.Href), propstat.Prop.ETag, vevent, loc, c.username); matchesQuery(item, query) {
+					items = append(items, item)
+				}
+			}
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return eventStartDate(items[i], loc).Before(eventStartDate(items[j], loc))
+	})
+	return items, nil
+}
+
+// matchesQuery reports whether an event has the status, text and private property a query asks for.
+func matchesQuery(item *calendar.Event, query EventQuery) bool {
+	propertyName, propertyValue, _ := strings.Cut(query.Property, "=")
+	switch {
+	case item.Status == "cancelled" && !query.ShowDeleted:
+		return false
+	case query.Text != "" && !mentionsAny(item, []string{query.Text}):
+		return false
+	case query.Property != "" && (item.ExtendedProperties == nil || item.ExtendedProperties.Private[propertyName] != propertyValue):
+		return false
+	}
+	return true
+}
+
+func (c *caldavProvider) GetEvent(ctx context.Context, calendarID, eventID string) (*calendar.Event, error) {
+	cal, etag, err := c.getResource(ctx, calendarID, eventID)
+	if err != nil {
+		return nil, err
+	}
+	return resourceEvent(cal, eventID, etag, c.username)
+}
+
+func (c *caldavProvider) InsertEvent(ctx context.Context, calendarID string, event *calendar.Event) (*calendar.Event, error) {
+	name := event.Id
+	if name == "" {
+		name = randomName()
+	}
+	cal := newVCalendar(newVEvent(name, event))
+	target, err := c.resourceURL(ctx, calendarID, name)
+	if err != nil {
+		return nil, err
+	}
+	// Only create the resource, so a repeated insert reports a conflict as Google does
+	resp, err := c.request(ctx, http.MethodPut, target, http.Header{"If-None-Match": {"*"}, "Content-Type": {"text/calendar; charset=utf-8"}}, cal.encode())
+	if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusPreconditionFailed {
+		apiErr.Code = http.StatusConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	created := *event
+	created.Id = name
+	created.ICalUID = name
+	created.Etag = resp.Header.Get("ETag")
+	return &created, nil
+}
+
+func (c *caldavProvider) PatchEvent(ctx context.Context, calendarID, eventID, etag string, event *calendar.Event) error {
+	return c.modify(ctx, calendarID, eventID, etag, func(cal, vevent *icalComponent) {
+		applyEvent(vevent, event, false)
+	})
+}
+
+// UpdateEvent replaces the fields the tracker sets and keeps the rest of the event, such as alarms
+// added in another client.
+func (c *caldavProvider) UpdateEvent(ctx context.Context, calendarID, eventID, etag string, event *calendar.Event) error {
+	return c.modify(ctx, calendarID, eventID, etag, func(cal, vevent *icalComponent) {
+		applyEvent(vevent, event, true)
+	})
+}
+
+func (c *caldavProvider) DeleteEvent(ctx context.Context, calendarID, eventID, etag string) error {
+	name, instance := splitInstanceID(eventID)
+	if instance != "" {
+		// A single occurrence is removed by excluding it from the recurring event
+		return c.modify(ctx, calendarID, name, etag, excludeOccurrence(instance))
+	}
+	target, err := c.resourceURL(ctx, calendarID, name)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-Match", etag)
+	}
+	resp, err := c.request(ctx, http.MethodDelete, target, header, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *caldavProvider) ListCalendars(ctx context.Context) ([]*calendar.CalendarListEntry, error) {
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:displayname/></D:prop></D:propfind>`
+	var reply davMultistatus
+	if err := c.multistatus(ctx, "PROPFIND", c.home, "1", body, &reply); err != nil {
+		return nil, err
+	}
+	var entries []*calendar.CalendarListEntry
+	for _, response := range reply.Responses {
+		for _, propstat := range response.Propstat {
+			if propstat.Prop.ResourceType.Calendar == nil {
+				continue
+			}
+			id := resourceName(response.Href)
+			summary := propstat.Prop.DisplayName
+			if summary == "" {
+				summary = id
+			}
+			entries = append(entries, &calendar.CalendarListEntry{Id: id, Summary: summary})
+		}
+	}
+	return entries, nil
+}
+
+func (c *caldavProvider) CreateCalendar(ctx context.Context, name, timeZone string) (string, error) {
+	id := randomName()
+	target, err := c.calendarURL(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<C:mkcalendar xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:set><D:prop><D:displayname>` + xmlText(name) + `</D:displayname></D:prop></D:set>
+</C:mkcalendar>`
+	resp, err := c.request(ctx, "MKCALENDAR", target, http.Header{"Content-Type": {"application/xml; charset=utf-8"}}, body)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return id, nil
+}
+
+func (c *caldavProvider) DeleteCalendar(ctx context.Context, calendarID string) error {
+	target, err := c.calendarURL(ctx, calendarID)
+	if err != nil {
+		return err
+	}
+	resp, err := c.request(ctx, http.MethodDelete, target, nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// calendarURL returns the URL of a calendar collection, looking up the calendar "primary" stands for.
+func (c *caldavProvider) calendarURL(ctx context.Context, calendarID string) (*url.URL, error) {
+	if calendarID == "primary" {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.primary == "" {
+			calendars, err := c.ListCalendars(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if len(calendars) == 0 {
+				return nil, fmt.Errorf("no calendars found at %s", c.home.Redacted())
+			}
+			c.primary = calendars[0].Id
+		}
+		calendarID = c.primary
+	}
+	return c.home.ResolveReference(&url.URL{Path: url.PathEscape(calendarID) + "/"}), nil
+}
+
+// resourceURL returns the URL of an event resource.
+func (c *caldavProvider) resourceURL(ctx context.Context, calendarID, name string) (*url.URL, error) {
+	collection, err := c.calendarURL(ctx, calendarID)
+	if err != nil {
+		return nil, err
+	}
+	return collection.ResolveReference(&url.URL{Path: url.PathEscape(name + ".ics")}), nil
+}
+
+// getResource fetches and parses the resource holding an event, returning its ETag.
+func (c *caldavProvider) getResource(ctx context.Context, calendarID, eventID string) (*icalComponent, string, error) {
+	name, _ := splitInstanceID(eventID)
+	target, err := c.resourceURL(ctx, calendarID, name)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := c.request(ctx, http.MethodGet, target, nil, "")
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	cal, err := parseICal(string(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read event %s: %v", eventID, err)
+	}
+	return cal, resp.Header.Get("ETag"), nil
+}
+
+// modify rewrites the resource holding an event with changeComponent, while it still has the given
+// ETag unless it is empty.
+func (c *caldavProvider) modify(ctx context.Context, calendarID, eventID, etag string, change func(cal, vevent *icalComponent)) error {
+	cal, current, err := c.getResource(ctx, calendarID, eventID)
+	if err != nil {
+		return err
+	}
+	if etag == "" {
+		etag = current
+	}
+	name, instance := splitInstanceID(eventID)
+	if err := changeComponent(cal, instance, change); err != nil {
+		return err
+	}
+
+	target, err := c.resourceURL(ctx, calendarID, name)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"text/calendar; charset=utf-8"}}
+	if etag != "" {
+		header.Set("If-Match", etag)
+	}
+	resp, err := c.request(ctx, http.MethodPut, target, header, cal.encode())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// multistatus sends a PROPFIND or REPORT request and decodes the reply.
+func (c *caldavProvider) multistatus(ctx context.Context, method string, target *url.URL, depth, body string, out *davMultistatus) error {
+	header := http.Header{"Depth": {depth}, "Content-Type": {"application/xml; charset=utf-8"}}
+	resp, err := c.request(ctx, method, target, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return xml.NewDecoder(resp.Body).Decode(out)
+}
+
+// request sends a request to the CalDAV server. Failures are returned as *googleapi.Error carrying
+// the HTTP status; on success the caller closes the response body.
+func (c *caldavProvider) request(ctx context.Context, method string, target *url.URL, header http.Header, body string) (*http.Response, error) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, &googleapi.Error{Code: resp.StatusCode, Message: fmt.Sprintf("%s %s: %s", method, target.Path, resp.Status), Body: string(b)}
+	}
+	return resp, nil
+}
+
+// caldavResponseStatuses maps iCalendar participation statuses onto the Google equivalents.
+var caldavResponseStatuses = map[string]string{
+	"ACCEPTED":     "accepted",
+	"TENTATIVE":    "tentative",
+	"DECLINED":     "declined",
+	"NEEDS-ACTION": "needsAction",
+}
+
+// veventToEvent converts a VEVENT from the named resource to the tracker's representation, with times
+// in loc. self is the address of the user among the attendees, whose response is reported.
+func veventToEvent(name, etag string, vevent *icalComponent, loc *time.Location, self string) *calendar.Event {
+	item := &calendar.Event{
+		Id:          name,
+		Etag:        etag,
+		ICalUID:     vevent.value("UID"),
+		Summary:     vevent.text("SUMMARY"),
+		Description: vevent.text("DESCRIPTION"),
+		Location:    vevent.text("LOCATION"),
+		Status:      "confirmed",
+	}
+	if rid := vevent.prop("RECURRENCE-ID"); rid != nil {
+		item.Id = name + "_" + normalizeICalTime(rid)
+	}
+	switch strings.ToUpper(vevent.value("STATUS")) {
+	case "CANCELLED":
+		item.Status = "cancelled"
+	case "TENTATIVE":
+		item.Status = "tentative"
+	}
+	if strings.EqualFold(vevent.value("TRANSP"), "TRANSPARENT") {
+		item.Transparency = "transparent"
+	}
+	for _, attendee := range vevent.Props {
+		address := strings.TrimPrefix(strings.ToLower(attendee.Value), "mailto:")
+		if attendee.Name != "ATTENDEE" || self == "" || address != strings.ToLower(self) {
+			continue
+		}
+		if status, ok := caldavResponseStatuses[strings.ToUpper(attendee.Params["PARTSTAT"])]; ok {
+			item.Attendees = []*calendar.EventAttendee{{Self: true, ResponseStatus: status}}
+		}
+	}
+
+	if dtstart := vevent.prop("DTSTART"); dtstart != nil {
+		if start, ok := parseICalTime(dtstart, loc); ok {
+			item.Start = fromTime(start, dtstart, loc.String())
+			end := start
+			if dtend := vevent.prop("DTEND"); dtend != nil {
+				end, _ = parseICalTime(dtend, loc)
+			} else if duration, ok := parseICalDuration(vevent.value("DURATION")); ok {
+				end = start.Add(duration)
+			} else if isICalDate(dtstart) {
+				end = start.AddDate(0, 0, 1)
+			}
+			item.End = fromTime(end, dtstart, loc.String())
+		}
+	}
+	if private := vevent.text(caldavPrivateProperty); private != "" {
+		properties := make(map[string]string)
+		if json.Unmarshal([]byte(private), &properties) == nil {
+			item.ExtendedProperties = &calendar.EventExtendedProperties{Private: properties}
+		}
+	}
+	return item
+}
+
+// applyEvent writes the fields set in an event to a VEVENT. With replace, fields the event leaves
+// empty are removed too.
+func applyEvent(vevent *icalComponent, event *calendar.Event, replace bool) {
+	for name, value := range map[string]string{"SUMMARY": event.Summary, "DESCRIPTION": event.Description, "LOCATION": event.Location} {
+		field := strings.ToUpper(name[:1]) + strings.ToLower(name[1:])
+		switch {
+		case value != "":
+			vevent.setText(name, value)
+		case replace || containsField(event.NullFields, field):
+			vevent.remove(name)
+		}
+	}
+	if event.Start != nil && event.End != nil {
+		vevent.remove("DURATION")
+		vevent.Props = append(removeProps(vevent.Props, "DTSTART", "DTEND"), toICalTime("DTSTART", event.Start), toICalTime("DTEND", event.End))
+	}
+	switch event.Transparency {
+	case "transparent":
+		vevent.set("TRANSP", nil, "TRANSPARENT")
+	case "opaque":
+		vevent.set("TRANSP", nil, "OPAQUE")
+	case "":
+		if replace {
+			vevent.remove("TRANSP")
+		}
+	}
+	switch event.Status {
+	case "confirmed", "tentative", "cancelled":
+		vevent.set("STATUS", nil, strings.ToUpper(event.Status))
+	case "":
+		if replace {
+			vevent.remove("STATUS")
+		}
+	}
+	if event.ExtendedProperties != nil && event.ExtendedProperties.Private != nil {
+		private, _ := json.Marshal(event.ExtendedProperties.Private)
+		vevent.setText(caldavPrivateProperty, string(private))
+	} else if replace {
+		vevent.remove(caldavPrivateProperty)
+	}
+}
+
+// splitInstanceID splits the ID of an occurrence of a recurring event into the resource name and
+// the start of the occurrence as a UTC iCalendar time or date. Other IDs have no instance.
+func splitInstanceID(eventID string) (name, instance string) {
+	i := strings.LastIndex(eventID, "_")
+	if i < 0 || !instancePattern.MatchString(eventID[i+1:]) {
+		return eventID, ""
+	}
+	return eventID[:i], eventID[i+1:]
+}
+
+var instancePattern = regexp.MustCompile(`^[0-9]{8}(T[0-9]{6}Z)?$`)
+
+// findComponent returns the VEVENT for an occurrence: the one overriding it if there is one, or
+// otherwise the recurring event itself. Without an instance it returns the main VEVENT.
+func findComponent(cal *icalComponent, instance string) (*icalComponent, error) {
+	var master *icalComponent
+	for _, vevent := range cal.components("VEVENT") {
+		rid := vevent.prop("RECURRENCE-ID")
+		switch {
+		case rid == nil && master == nil:
+			master = vevent
+		case rid != nil && instance != "" && normalizeICalTime(rid) == instance:
+			return vevent, nil
+		}
+	}
+	if master == nil {
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: "no event in the calendar resource"}
+	}
+	return master, nil
+}
+
+// newVCalendar returns a calendar written by the tracker holding the given components.
+func newVCalendar(components ...*icalComponent) *icalComponent {
+	cal := &icalComponent{Name: "VCALENDAR", Components: components}
+	cal.set("VERSION", nil, "2.0")
+	cal.set("PRODID", nil, "-//paymentTracker//EN")
+	return cal
+}
+
+// newVEvent returns a VEVENT for an event, with the given UID.
+func newVEvent(uid string, event *calendar.Event) *icalComponent {
+	vevent := &icalComponent{Name: "VEVENT"}
+	vevent.set("UID", nil, uid)
+	vevent.set("DTSTAMP", nil, icalTime(time.Now()))
+	applyEvent(vevent, event, true)
+	return vevent
+}
+
+// resourceEvent returns an event from the calendar resource holding it, the occurrence when the ID
+// names one.
+func resourceEvent(cal *icalComponent, eventID, etag, self string) (*calendar.Event, error) {
+	name, instance := splitInstanceID(eventID)
+	vevent, err := findComponent(cal, instance)
+	if err != nil {
+		return nil, err
+	}
+	item := veventToEvent(name, etag, vevent, time.UTC, self)
+	if instance != "" && vevent.prop("RECURRENCE-ID") == nil {
+		occurrence := instanceProp("DTSTART", instance)
+		start, _ := parseICalTime(&occurrence, time.UTC)
+		moveToOccurrence(item, eventID, start, &occurrence, time.UTC)
+	}
+	return item, nil
+}
+
+// changeComponent makes a change to the component of an event in cal, the occurrence instance of it
+// when set. For an occurrence of a recurring event that has no changes of its own yet, a component
+// overriding the occurrence is added first.
+func changeComponent(cal *icalComponent, instance string, change func(cal, vevent *icalComponent)) error {
+	vevent, err := findComponent(cal, instance)
+	if err != nil {
+		return err
+	}
+	if instance != "" && vevent.prop("RECURRENCE-ID") == nil {
+		vevent = overrideOccurrence(cal, vevent, instance)
+	}
+	change(cal, vevent)
+	vevent.set("DTSTAMP", nil, icalTime(time.Now()))
+	sequence, _ := strconv.Atoi(vevent.value("SEQUENCE"))
+	vevent.set("SEQUENCE", nil, strconv.Itoa(sequence+1))
+	return nil
+}
+
+// excludeOccurrence returns the change removing a single occurrence of a recurring event, which is
+// excluded from the recurring event along with any changes made to it.
+func excludeOccurrence(instance string) func(cal, vevent *icalComponent) {
+	return func(cal, vevent *icalComponent) {
+		var kept []*icalComponent
+		for _, component := range cal.Components {
+			if rid := component.prop("RECURRENCE-ID"); component.Name != "VEVENT" || rid == nil || normalizeICalTime(rid) != instance {
+				kept = append(kept, component)
+			}
+		}
+		cal.Components = kept
+		vevent.Props = append(vevent.Props, instanceProp("EXDATE", instance))
+	}
+}
+
+// instanceProp returns a time property holding the start of an occurrence, as found in instance IDs.
+func instanceProp(name, instance string) icalProp {
+	p := icalProp{Name: name, Value: instance}
+	if len(instance) == 8 {
+		p.Params = map[string]string{"VALUE": "DATE"}
+	}
+	return p
+}
+
+// moveToOccurrence turns a recurring event into one of its occurrences that has no changes of its
+// own, moved to the occurrence's start with the same length.
+func moveToOccurrence(item *calendar.Event, eventID string, start time.Time, occurrence *icalProp, loc *time.Location) {
+	masterStart, masterEnd := eventBounds(item, loc)
+	end := start.Add(masterEnd.Sub(masterStart))
+	if isICalDate(occurrence) {
+		end = start.AddDate(0, 0, int(math.Round(masterEnd.Sub(masterStart).Hours()/24)))
+	}
+	item.Id = eventID
+	item.Start = fromTime(start, occurrence, loc.String())
+	item.End = fromTime(end, occurrence, loc.String())
+}
+
+// overrideOccurrence adds a VEVENT overriding one occurrence of a recurring event, a copy of the
+// recurring event moved to the occurrence's start.
+func overrideOccurrence(cal, master *icalComponent, instance string) *icalComponent {
+	rid := instanceProp("RECURRENCE-ID", instance)
+	override := &icalComponent{
+		Name:       "VEVENT",
+		Props:      removeProps(master.Props, "RRULE", "RDATE", "EXDATE", "DTSTART", "DTEND", "DURATION"),
+		Components: append([]*icalComponent(nil), master.Components...),
+	}
+	if dtstart := master.prop("DTSTART"); dtstart != nil {
+		start, _ := parseICalTime(dtstart, time.UTC)
+		occurrence, _ := parseICalTime(&rid, time.UTC)
+		shift := occurrence.Sub(start)
+		override.Props = append(override.Props, shiftICalTime(*dtstart, shift))
+		if dtend := master.prop("DTEND"); dtend != nil {
+			override.Props = append(override.Props, shiftICalTime(*dtend, shift))
+		} else if duration := master.prop("DURATION"); duration != nil {
+			override.Props = append(override.Props, *duration)
+		}
+	}
+	override.Props = append(override.Props, rid)
+	cal.Components = append(cal.Components, override)
+	return override
+}
+
+// shiftICalTime moves a time property by the given amount, keeping its form.
+func shiftICalTime(p icalProp, shift time.Duration) icalProp {
+	t, _ := parseICalTime(&p, time.UTC)
+	t = t.Add(shift)
+	switch {
+	case isICalDate(&p):
+		p.Value = t.Format("20060102")
+	case strings.HasSuffix(p.Value, "Z"):
+		p.Value = icalTime(t)
+	default:
+		p.Value = t.Format("20060102T150405")
+	}
+	return p
+}
+
+// resourceName returns the last segment of an href without its .ics extension.
+func resourceName(href string) string {
+	name := path.Base(strings.TrimSuffix(href, "/"))
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	return strings.TrimSuffix(name, ".ics")
+}
+
+// randomName returns a fresh resource name, in the same characters as idempotency keys.
+func randomName() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "pt" + hex.EncodeToString(b)
+}
+
+// xmlText escapes a value for use as XML character data.
+func xmlText(value string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(value))
+	return b.String()
+}
+
+// icalComponent is a parsed iCalendar component such as VCALENDAR or VEVENT.
+type icalComponent struct {
+	Name       string
+	Props      []icalProp
+	Components []*icalComponent
+}
+
+// icalProp is a content line. Text values are kept escaped as they appear in the data.
+type icalProp struct {
+	Name   string            // Upper case
+	Params map[string]string // Upper case names, unquoted values
+	Value  string
+}
+
+// components returns the subcomponents with the given name.
+func (c *icalComponent) components(name string) []*icalComponent {
+	var found []*icalComponent
+	for _, component := range c.Components {
+		if component.Name == name {
+			found = append(found, component)
+		}
+	}
+	return found
+}
+
+// prop returns the first property with the given name, or nil.
+func (c *icalComponent) prop(name string) *icalProp {
+	for i := range c.Props {
+		if c.Props[i].Name == name {
+			return &c.Props[i]
+		}
+	}
+	return nil
+}
+
+func (c *icalComponent) value(name string) string {
+	if p := c.prop(name); p != nil {
+		return p.Value
+	}
+	return ""
+}
+
+// text returns the unescaped value of a text property.
+func (c *icalComponent) text(name string) string {
+	value := c.value(name)
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+			if value[i] == 'n' || value[i] == 'N' {
+				b.WriteByte('\n')
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// set replaces every property with the given name by a single one.
+func (c *icalComponent) set(name string, params map[string]string, value string) {
+	c.Props = append(removeProps(c.Props, name), icalProp{Name: name, Params: params, Value: value})
+}
+
+// setText sets a text property, escaping the value.
+func (c *icalComponent) setText(name, value string) {
+	value = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(value)
+	c.set(name, nil, value)
+}
+
+func (c *icalComponent) remove(name string) {
+	c.Props = removeProps(c.Props, name)
+}
+
+// removeProps returns a copy of the properties without those with the given names.
+func removeProps(props []icalProp, names ...string) []icalProp {
+	kept := make([]icalProp, 0, len(props))
+	for _, p := range props {
+		if !containsField(names, p.Name) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// parseICal parses iCalendar data into its top-level component.
+func parseICal(data string) (*icalComponent, error) {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.NewReplacer("\n ", "", "\n\t", "").Replace(data)
+
+	var stack []*icalComponent
+	var root *icalComponent
+	for _, line := range strings.Split(data, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		p, err := parseICalLine(line)
+		if err != nil {
+			return nil, err
+		}
+		switch p.Name {
+		case "BEGIN":
+			component := &icalComponent{Name: strings.ToUpper(p.Value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, component)
+			} else if root == nil {
+				root = component
+			}
+			stack = append(stack, component)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(p.Value) {
+				return nil, fmt.Errorf("unexpected END:%s", p.Value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("property %s outside a component", p.Name)
+			}
+			component := stack[len(stack)-1]
+			component.Props = append(component.Props, p)
+		}
+	}
+	if root == nil || len(stack) > 0 {
+		return nil, fmt.Errorf("incomplete calendar data")
+	}
+	return root, nil
+}
+
+// parseICalLine splits an unfolded content line into its name, parameters and value.
+func parseICalLine(line string) (icalProp, error) {
+	end := strings.IndexAny(line, ";:")
+	if end <= 0 {
+		return icalProp{}, fmt.Errorf("invalid line %q", line)
+	}
+	p := icalProp{Name: strings.ToUpper(line[:end])}
+	rest := line[end:]
+	for strings.HasPrefix(rest, ";") {
+		rest = rest[1:]
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			return icalProp{}, fmt.Errorf("invalid parameter in line %q", line)
+		}
+		name := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+		var value strings.Builder
+		quoted := false
+		i := 0
+		for ; i < len(rest); i++ {
+			ch := rest[i]
+			if ch == '"' {
+				quoted = !quoted
+				continue
+			}
+			if !quoted && (ch == ';' || ch == ':') {
+				break
+			}
+			value.WriteByte(ch)
+		}
+		if p.Params == nil {
+			p.Params = make(map[string]string)
+		}
+		p.Params[name] = value.String()
+		rest = rest[i:]
+	}
+	if !strings.HasPrefix(rest, ":") {
+		return icalProp{}, fmt.Errorf("invalid line %q", line)
+	}
+	p.Value = rest[1:]
+	return p, nil
+}
+
+// encode renders a component as iCalendar data, folding long lines.
+func (c *icalComponent) encode() string {
+	var b strings.Builder
+	c.encodeTo(&b)
+	return b.String()
+}
+
+func (c *icalComponent) encodeTo(b *strings.Builder) {
+	writeICalLine(b, "BEGIN:"+c.Name)
+	for _, p := range c.Props {
+		line := p.Name
+		names := make([]string, 0, len(p.Params))
+		for name := range p.Params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value := p.Params[name]
+			if strings.ContainsAny(value, ";:,") {
+				value = `"` + value + `"`
+			}
+			line += ";" + name + "=" + value
+		}
+		writeICalLine(b, line+":"+p.Value)
+	}
+	for _, component := range c.Components {
+		component.encodeTo(b)
+	}
+	writeICalLine(b, "END:"+c.Name)
+}
+
+// writeICalLine writes a content line folded at 75 octets, never splitting a character.
+func writeICalLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74 // The leading space counts towards the continuation line
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+func isICalDate(p *icalProp) bool {
+	return strings.EqualFold(p.Params["VALUE"], "DATE") || len(p.Value) == 8
+}
+
+// parseICalTime parses a DATE or DATE-TIME property. Dates are midnight in loc, as are times in an
+// unknown or no time zone.
+func parseICalTime(p *icalProp, loc *time.Location) (time.Time, bool) {
+	if isICalDate(p) {
+		t, err := time.ParseInLocation("20060102", p.Value, loc)
+		return t, err == nil
+	}
+	if strings.HasSuffix(p.Value, "Z") {
+		t, err := time.Parse("20060102T150405Z", p.Value)
+		return t, err == nil
+	}
+	zone := loc
+	if tzid := p.Params["TZID"]; tzid != "" {
+		if resolved, ok := resolveTimeZone(tzid); ok {
+			if l, err := time.LoadLocation(resolved); err == nil {
+				zone = l
+			}
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", p.Value, zone)
+	return t, err == nil
+}
+
+// normalizeICalTime renders a RECURRENCE-ID the way it appears in instance IDs: a date, or a UTC time.
+func normalizeICalTime(p *icalProp) string {
+	t, ok := parseICalTime(p, time.UTC)
+	switch {
+	case !ok:
+		return p.Value
+	case isICalDate(p):
+		return t.Format("20060102")
+	default:
+		return icalTime(t)
+	}
+}
+
+// fromTime converts a parsed time back to the tracker's form, a date when the property it came
+// from is one and an RFC 3339 time in the zone otherwise.
+func fromTime(t time.Time, p *icalProp, zone string) *calendar.EventDateTime {
+	if isICalDate(p) {
+		return &calendar.EventDateTime{Date: t.Format("2006-01-02")}
+	}
+	if loc, err := time.LoadLocation(zone); err == nil {
+		t = t.In(loc)
+	}
+	return &calendar.EventDateTime{DateTime: t.Format(time.RFC3339)}
+}
+
+// toICalTime converts a date or RFC 3339 time to a DTSTART or DTEND property.
+func toICalTime(name string, d *calendar.EventDateTime) icalProp {
+	if d.Date != "" {
+		return icalProp{Name: name, Params: map[string]string{"VALUE": "DATE"}, Value: strings.ReplaceAll(d.Date, "-", "")}
+	}
+	t, err := time.Parse(time.RFC3339, d.DateTime)
+	if err != nil {
+		return icalProp{Name: name, Value: d.DateTime}
+	}
+	return icalProp{Name: name, Value: icalTime(t)}
+}
+
+// parseICalDuration parses a DURATION value such as PT1H30M or P1D.
+func parseICalDuration(value string) (time.Duration, bool) {
+	sign := time.Duration(1)
+	if strings.HasPrefix(value, "-") {
+		sign = -1
+	}
+	value = strings.TrimLeft(value, "+-")
+	if !strings.HasPrefix(value, "P") {
+		return 0, false
+	}
+	var total time.Duration
+	units := map[byte]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour, 'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+	n := 0
+	for i := 1; i < len(value); i++ {
+		ch := value[i]
+		switch {
+		case ch == 'T':
+		case ch >= '0' && ch <= '9':
+			n = n*10 + int(ch-'0')
+		case units[ch] != 0:
+			total += time.Duration(n) * units[ch]
+			n = 0
+		default:
+			return 0, false
+		}
+	}
+	return sign * total, true
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// parseCalendarList splits a comma separated list of calendar IDs or names.
+func parseCalendarList(value string) []string {
+	var calendars []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			calendars = append(calendars, entry)
+		}
+	}
+	return calendars
+}
+
+// resolveCalendarIDs maps calendar names to their IDs using the user's calendar list. Entries that
+// are already IDs ("primary" or "partner:primary", an address containing @ or the path of an .ics
+// file) are passed through unchanged.
+func resolveCalendarIDs(ctx context.Context, srv CalendarProvider, calendars []string) ([]string, error) {
+	var byName map[string]string
+	resolved := make([]string, 0, len(calendars))
+	for _, name := range calendars {
+		if _, id := splitAccount(name); id == "primary" || strings.Contains(name, "@") || isICSFile(name) {
+			resolved = append(resolved, name)
+			continue
+		}
+
+		if byName == nil {
+			list, err := srv.ListCalendars(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("unable to retrieve calendar list: %v", err)
+			}
+			byName = make(map[string]string)
+			for _, entry := range list {
+				byName[strings.ToLower(entry.Summary)] = entry.Id
+				if entry.SummaryOverride != "" {
+					byName[strings.ToLower(entry.SummaryOverride)] = entry.Id
+				}
+			}
+		}
+
+		id, ok := byName[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("no calendar named %q in the calendar list", name)
+		}
+		resolved = append(resolved, id)
+	}
+	return resolved, nil
+}
+
+// parseCalendarTimeZones reads per-calendar time zones in the format "family=Asia/Karachi,bills=Europe/London".
+func parseCalendarTimeZones(value string) map[string]string {
+	timeZones := make(map[string]string)
+	for _, entry := range parseCalendarList(value) {
+		name, zone, found := strings.Cut(entry, "=")
+		if !found {
+			slog.Warn("Ignoring invalid CALENDAR_TIMEZONES entry, expected calendar=zone", "entry", entry)
+			continue
+		}
+		resolved, ok := resolveTimeZone(zone)
+		if !ok {
+			slog.Warn("Ignoring CALENDAR_TIMEZONES entry with an unknown time zone", "entry", entry, "did_you_mean", resolved)
+			continue
+		}
+		timeZones[strings.TrimSpace(name)] = resolved
+	}
+	return timeZones
+}
+
+// resolveCalendarTimeZones re-keys the per-calendar time zones by calendar ID.
+func resolveCalendarTimeZones(ctx context.Context, srv CalendarProvider, timeZones map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(timeZones))
+	for name, zone := range timeZones {
+		ids, err := resolveCalendarIDs(ctx, srv, []string{name})
+		if err != nil {
+			return nil, err
+		}
+		resolved[ids[0]] = zone
+	}
+	return resolved, nil
+}
+
+// calendarTimeZone returns the time zone events of the given calendar live in.
+func calendarTimeZone(config Config, calendarID string) string {
+	if zone, ok := config.CalendarTimeZones[calendarID]; ok {
+		return zone
+	}
+	return config.TimeZone
+}
+
+// eventLocalDate returns the calendar date of an event as seen in its calendar's time zone,
+// placed at midnight in loc so it can be compared against pay period boundaries.
+func eventLocalDate(item *calendar.Event, calendarLoc, loc *time.Location) time.Time {
+	start := eventStartDate(item, calendarLoc)
+	return time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Hashtags such as #rent or #utilities put a payment in a category.
+var hashtagPattern = regexp.MustCompile(`#([\p{L}\p{N}_-]+)`)
+
+// Categories used for amounts that do not come from payment events.
+const (
+	giftsCategory     = "gifts"
+	estimatedCategory = "estimated"
+)
+
+// PeriodTotal is the amount due in a pay period, with its split across categories. Payments
+// without a hashtag are counted under the empty category.
+type PeriodTotal struct {
+	Start, End time.Time
+	Amount     float64
+	Categories map[string]float64
+	Payments   []*calendar.Event  // Payment events counted in the total
+	Events     []*calendar.Event  // Every event fetched for the period, counted or not
+	Income     float64            // Income for the period, when INCOME_SOURCE is set
+	Paid       float64            // Payments already made in the period, when INCOME_SOURCE is set
+	Foreign    map[string]float64 // Upcoming payments in other currencies, by symbol, left out of Amount
+}
+
+// Remaining returns the amount shown on the period's "Total Remaining" event: the upcoming
+// payments, or with an income source, what is left of the income once they and the payments
+// already made are paid.
+func (t PeriodTotal) Remaining(config Config) float64 {
+	if config.IncomeSource == "" {
+		return t.Amount
+	}
+	return t.Income - t.Paid - t.Amount
+}
+
+// Add counts an amount towards the total and its category.
+func (t *PeriodTotal) Add(category string, amount float64) {
+	if t.Categories == nil {
+		t.Categories = make(map[string]float64)
+	}
+	t.Amount += amount
+	t.Categories[category] += amount
+}
+
+// AddForeign counts an amount in another currency, which is kept apart from the total.
+func (t *PeriodTotal) AddForeign(symbol string, amount float64) {
+	if t.Foreign == nil {
+		t.Foreign = make(map[string]float64)
+	}
+	t.Foreign[symbol] += amount
+}
+
+// paymentCategory returns the first hashtag of a payment event, from its summary or else its
+// description, in lower case. Events without one return an empty category.
+func paymentCategory(item *calendar.Event) string {
+	for _, text := range []string{item.Summary, item.Description} {
+		if match := hashtagPattern.FindStringSubmatch(text); match != nil {
+			return strings.ToLower(match[1])
+		}
+	}
+	return ""
+}
+
+// categoryBreakdown renders the per-category subtotals one per line, e.g. "#rent £800.00", sorted by
+// category with uncategorised payments last, in the given event language. It is empty when nothing
+// is categorised.
+func categoryBreakdown(total PeriodTotal, currency Currency, language string) string {
+	categories := make([]string, 0, len(total.Categories))
+	for category := range total.Categories {
+		if category != "" {
+			categories = append(categories, category)
+		}
+	}
+	if len(categories) == 0 {
+		return ""
+	}
+	sort.Strings(categories)
+
+	lines := make([]string, 0, len(categories)+1)
+	for _, category := range categories {
+		lines = append(lines, fmt.Sprintf("#%s %s", category, currency.Format(total.Categories[category])))
+	}
+	if amount, ok := total.Categories[""]; ok {
+		lines = append(lines, fmt.Sprintf(eventText(language, "Other %s"), currency.Format(amount)))
+	}
+	return strings.Join(lines, "\n")
+}
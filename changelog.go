@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// changelogHeading opens the list of amount changes at the end of a "Total Remaining" description.
+const changelogHeading = "Changes:"
+
+// paymentsProperty lists short keys of the payments a "Total Remaining" event was written with, so
+// the next change can name the payments behind it. Google limits property values to 1024 bytes; the
+// property is left out when the keys don't fit.
+const (
+	paymentsProperty = "payments"
+	maxPaymentsValue = 1024
+)
+
+// paymentKey returns a short key identifying a payment event across runs.
+func paymentKey(item *calendar.Event) string {
+	sum := sha256.Sum256([]byte(item.Id))
+	return hex.EncodeToString(sum[:4])
+}
+
+// addChangelogs keeps the changelog of each "Total Remaining" event in the description of the event
+// replacing it, adding an entry when the amount moves by more than WriteThreshold. The newest entry
+// comes first and only the last CHANGELOG_ENTRIES are kept.
+func addChangelogs(desired []desiredEvent, actual []*calendar.Event, config Config, now time.Time) {
+	if config.ChangelogEntries <= 0 {
+		return
+	}
+	bySlot := make(map[string]*calendar.Event)
+	for _, item := range actual {
+		if key := managedEventKey(item); bySlot[key] == nil {
+			bySlot[key] = item
+		}
+	}
+	for _, d := range desired {
+		if !isManagedEvent(d.Event, totalRemainingEventType) || !hasAmount(d.Event) {
+			continue
+		}
+		var keys []string
+		for _, item := range d.Period.Payments {
+			keys = append(keys, paymentKey(item))
+		}
+		if value := strings.Join(keys, ","); len(value) <= maxPaymentsValue {
+			d.Event.ExtendedProperties.Private[paymentsProperty] = value
+		}
+
+		existing := bySlot[managedEventKey(d.Event)]
+		if existing == nil {
+			continue
+		}
+		_, entries := splitChangelog(existing.Description)
+		if previous, ok := eventAmount(existing, config); ok && math.Abs(previous-roundAmount(d.Amount)) > config.WriteThreshold {
+			entry := fmt.Sprintf("was %s on %s → %s", config.Currency.Format(previous), now.Format("2 Jan"), config.Currency.Format(d.Amount))
+			if cause := changeCause(existing, d.Period, config); cause != "" {
+				entry += " after " + cause
+			}
+			entries = append([]string{entry}, entries...)
+		}
+		if len(entries) > config.ChangelogEntries {
+			entries = entries[:config.ChangelogEntries]
+		}
+		if len(entries) > 0 {
+			d.Event.Description = strings.TrimPrefix(d.Event.Description+"\n\n"+changelogHeading+"\n"+strings.Join(entries, "\n"), "\n\n")
+		}
+	}
+}
+
+// splitChangelog separates the changelog from the rest of a description.
+func splitChangelog(description string) (rest string, entries []string) {
+	i := strings.LastIndex(description, changelogHeading+"\n")
+	if i < 0 || (i > 0 && !strings.HasSuffix(description[:i], "\n\n")) {
+		return description, nil
+	}
+	for _, line := range strings.Split(description[i+len(changelogHeading)+1:], "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return strings.TrimSuffix(description[:i], "\n\n"), entries
+}
+
+// changeCause names the payments that stopped or started counting since an event was written, such
+// as "marking Gym Payment paid, adding Netflix Payment". It is empty when the event has no record of
+// its payments or they are the same, the change then coming from amounts, income or adjustments.
+func changeCause(existing *calendar.Event, period PeriodTotal, config Config) string {
+	if existing.ExtendedProperties == nil {
+		return ""
+	}
+	recorded, ok := existing.ExtendedProperties.Private[paymentsProperty]
+	if !ok {
+		return ""
+	}
+	before := make(map[string]bool)
+	for _, key := range strings.Split(recorded, ",") {
+		if key != "" {
+			before[key] = true
+		}
+	}
+	counted := make(map[string]bool)
+	for _, item := range period.Payments {
+		counted[paymentKey(item)] = true
+	}
+
+	var causes []string
+	for _, item := range period.Payments {
+		if !before[paymentKey(item)] {
+			causes = append(causes, "adding "+paymentName(item, config))
+		}
+	}
+	for _, item := range period.Events {
+		if key := paymentKey(item); before[key] && !counted[key] && isPaid(item, config) {
+			causes = append(causes, "marking "+paymentName(item, config)+" paid")
+			delete(before, key)
+		}
+	}
+	gone := 0
+	for key := range before {
+		if !counted[key] {
+			gone++
+		}
+	}
+	switch {
+	case gone == 1:
+		causes = append(causes, "a payment went out or was removed")
+	case gone > 1:
+		causes = append(causes, fmt.Sprintf("%d payments went out or were removed", gone))
+	}
+
+	if len(causes) > 3 {
+		causes = append(causes[:3], fmt.Sprintf("%d more changes", len(causes)-3))
+	}
+	return strings.Join(causes, ", ")
+}
+
+// paymentName returns a payment's title without its amount or paid marker.
+func paymentName(item *calendar.Event, config Config) string {
+	name := config.Currency.StripAmounts(item.Summary)
+	if config.PaidMarker != "" {
+		name = strings.ReplaceAll(name, config.PaidMarker, "")
+	}
+	return strings.Join(strings.Fields(name), " ")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+)
+
+// connection is the calendar provider and configuration the run loop syncs with. It is set up once,
+// by the first run that gets that far, and shared with the dashboard and API, so their requests
+// neither read the environment, authorize nor touch the sync state themselves.
+type connection struct {
+	monitor *quotaMonitor
+	runs    sync.Mutex // Held while a run prepares, so only one syncs the event cache at a time
+	mu      sync.Mutex
+	srv     CalendarProvider
+	base    Config // As connected, before any events are synced
+	config  Config // As of the latest run, with the events it synced
+	stale   bool   // A run failed, so the next one connects afresh
+}
+
+func newConnection(monitor *quotaMonitor) *connection {
+	return &connection{monitor: monitor}
+}
+
+// prepare returns the provider and configuration for a run, connecting on first use and after a
+// failed run, and brings the event cache up to date. Only runs call it.
+func (c *connection) prepare(ctx context.Context) (CalendarProvider, Config, error) {
+	c.runs.Lock()
+	defer c.runs.Unlock()
+
+	c.mu.Lock()
+	srv, config, stale := c.srv, c.base, c.stale
+	c.mu.Unlock()
+	if srv == nil || stale {
+		var err error
+		if srv, config, err = connectRun(ctx, c.monitor); err != nil {
+			return nil, config, err
+		}
+		c.mu.Lock()
+		c.srv, c.base, c.config, c.stale = srv, config, config, false
+		c.mu.Unlock()
+	}
+
+	if err := syncEventCache(ctx, srv, &config); err != nil {
+		return nil, config, err
+	}
+	c.mu.Lock()
+	c.config = config
+	c.mu.Unlock()
+
+	// Calendars that refuse a write are only given up on for the rest of the run, and skipped
+	// events are logged once per run
+	config.CalendarAccess = maps.Clone(config.CalendarAccess)
+	config.Skipped = newSkipLog()
+	return srv, config, nil
+}
+
+// failed notes that a run failed, which may be down to a revoked token or a calendar that was
+// renamed, so the next run connects afresh. Until then requests keep using the current connection.
+func (c *connection) failed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stale = true
+}
+
+// current returns the provider and configuration of the latest run, for requests served alongside
+// the run loop. It fails until the first run has connected. Requests do not log skipped events,
+// only runs do.
+func (c *connection) current() (CalendarProvider, Config, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	config := c.config
+	config.Skipped = nil
+	if c.srv == nil {
+		return nil, config, fmt.Errorf("not connected to the calendar yet, waiting for the first sync")
+	}
+	return c.srv, config, nil
+}
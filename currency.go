@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Currency describes how amounts are written in event summaries for the configured CURRENCY and LOCALE.
+type Currency struct {
+	Symbol    string
+	Decimal   string // Decimal separator
+	Thousands string // Thousands separator
+	Decimals  int    // Digits after the decimal separator when formatting
+	pattern   *regexp.Regexp
+	strict    *regexp.Regexp // Amounts written with the symbol
+	foreign   []Currency     // The other known currencies, with the same separators
+}
+
+// currencySymbols maps ISO codes to the symbol used in event summaries.
+var currencySymbols = map[string]string{
+	"GBP": "£", "USD": "$", "EUR": "€", "JPY": "¥", "CNY": "¥", "INR": "₹",
+	"PKR": "Rs", "SEK": "kr", "NOK": "kr", "DKK": "kr", "PLN": "zł", "CHF": "CHF",
+}
+
+// newCurrency builds the amount parser for a currency symbol or ISO code and a locale such as
+// en-GB or de_DE. Locales of languages that write 1.234,56 or 1 234,56 swap the separators.
+func newCurrency(symbol, locale string) Currency {
+	if mapped, ok := currencySymbols[strings.ToUpper(symbol)]; ok {
+		symbol = mapped
+	}
+	if symbol == "" {
+		symbol = "£"
+	}
+	c := buildCurrency(symbol, locale)
+
+	// Amounts written with another currency's symbol are told apart so they are not added to the total
+	seen := map[string]bool{symbol: true}
+	var symbols []string
+	for _, other := range currencySymbols {
+		if !seen[other] {
+			seen[other] = true
+			symbols = append(symbols, other)
+		}
+	}
+	sort.Strings(symbols)
+	for _, other := range symbols {
+		c.foreign = append(c.foreign, buildCurrency(other, locale))
+	}
+	return c
+}
+
+// buildCurrency builds the parser for a single currency symbol.
+func buildCurrency(symbol, locale string) Currency {
+	c := Currency{Symbol: symbol, Decimal: ".", Thousands: ",", Decimals: 2}
+	language, _, _ := strings.Cut(strings.ReplaceAll(strings.ToLower(locale), "_", "-"), "-")
+	switch language {
+	case "de", "es", "it", "nl", "pt", "da", "tr", "id":
+		c.Decimal, c.Thousands = ",", "."
+	case "fr", "sv", "pl", "cs", "fi", "ru", "nb", "no":
+		c.Decimal, c.Thousands = ",", " "
+	}
+	if symbol == "¥" {
+		c.Decimals = 0
+	}
+
+	thousands := regexp.QuoteMeta(c.Thousands)
+	if c.Thousands == " " {
+		thousands = `[ \x{00A0}\x{202F}]` // Plain, non-breaking and narrow non-breaking spaces
+	}
+	sym := regexp.QuoteMeta(symbol)
+	number := `(\d{1,3}(` + thousands + `\d{3})+|\d+)(` + regexp.QuoteMeta(c.Decimal) + `\d{1,2})?`
+	c.pattern = regexp.MustCompile(`(` + sym + `\s?)?` + number + `(\s?` + sym + `)?`)
+	if isASCIIWord(symbol) {
+		c.strict = regexp.MustCompile(`\b` + sym + `\s?` + number + `|` + number + `\s?` + sym + `\b`)
+	} else {
+		c.strict = regexp.MustCompile(sym + `\s?` + number + `|` + number + `\s?` + sym)
+	}
+	return c
+}
+
+// isASCIIWord reports whether a symbol is written in ASCII letters, like "kr" or "CHF", and so
+// must stand apart from the words around it.
+func isASCIIWord(symbol string) bool {
+	for _, r := range symbol {
+		if r > unicode.MaxASCII || !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return symbol != ""
+}
+
+// ParseForeign finds an amount written with the symbol of another known currency, such as "€40"
+// when the currency is pounds, returning that currency. Summaries that also have an amount in
+// this currency's symbol count in this currency instead.
+func (c Currency) ParseForeign(summary string) (Currency, float64, bool) {
+	if c.strict.MatchString(summary) {
+		return Currency{}, 0, false
+	}
+	for _, other := range c.foreign {
+		if match := other.strict.FindString(summary); match != "" {
+			amount, ok := other.Parse(match)
+			return other, amount, ok
+		}
+	}
+	return Currency{}, 0, false
+}
+
+// Parse finds and parses the first amount in a summary, with or without the currency symbol.
+func (c Currency) Parse(summary string) (float64, bool) {
+	match := c.pattern.FindString(summary)
+	if match == "" {
+		return 0, false // No match found
+	}
+
+	// Remove the symbol, spaces and thousands separators, then normalise the decimal separator
+	amountStr := strings.ReplaceAll(match, c.Symbol, "")
+	amountStr = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == ' ' || r == ' ' {
+			return -1
+		}
+		return r
+	}, amountStr)
+	if c.Thousands != " " {
+		amountStr = strings.ReplaceAll(amountStr, c.Thousands, "")
+	}
+	amountStr = strings.ReplaceAll(amountStr, c.Decimal, ".")
+
+	amount, err := strconv.ParseFloat(amountStr, 64)
+	if err != nil {
+		return 0, false // Failed to parse amount
+	}
+	return amount, true
+}
+
+// Format renders an amount with the currency symbol, placing word-like symbols such as "kr" after it.
+func (c Currency) Format(amount float64) string {
+	number := strings.Replace(strconv.FormatFloat(amount, 'f', c.Decimals, 64), ".", c.Decimal, 1)
+	if r := []rune(c.Symbol); len(r) > 0 && unicode.IsLetter(r[0]) {
+		return fmt.Sprintf("%s %s", number, c.Symbol)
+	}
+	return c.Symbol + number
+}
+
+// foreignCurrency returns the other known currency with the given symbol.
+func (c Currency) foreignCurrency(symbol string) Currency {
+	for _, other := range c.foreign {
+		if other.Symbol == symbol {
+			return other
+		}
+	}
+	return buildCurrency(symbol, "")
+}
+
+// foreignAmounts returns every amount in a summary written with another currency's symbol, as written.
+func (c Currency) foreignAmounts(summary string) []string {
+	var amounts []string
+	for _, other := range c.foreign {
+		amounts = append(amounts, other.strict.FindAllString(summary, -1)...)
+	}
+	return amounts
+}
+
+// StripAmounts removes every amount from a summary, leaving its wording and markers.
+func (c Currency) StripAmounts(summary string) string {
+	return c.pattern.ReplaceAllString(summary, "")
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How long a computed dashboard is served before it is recomputed, so page reloads don't eat into
+// the Calendar API quota.
+const dashboardTTL = time.Minute
+
+// dashboardForecastMonths is how far ahead the dashboard's forecast chart reaches.
+const dashboardForecastMonths = 12
+
+// dashboardView is what the dashboard page shows.
+type dashboardView struct {
+	Label     string // "Total Remaining" wording
+	Period    string
+	Remaining string
+	Payments  []dashboardPayment
+	Forecast  []dashboardBar
+	ChartSize int // Width of the forecast chart in pixels
+	LastSync  string
+	SyncError string
+	Error     string // Why the totals could not be computed
+	UpdatedAt string
+}
+
+// dashboardPayment is an upcoming payment of the current pay period.
+type dashboardPayment struct {
+	Date     string
+	Summary  string
+	Amount   string
+	Category string
+}
+
+// dashboardBar is a pay period in the forecast chart, with its bar's position and height in pixels.
+type dashboardBar struct {
+	Period   string
+	Amount   string
+	X, Y     int
+	Height   int
+	Negative bool
+}
+
+// Dimensions of the forecast chart in pixels.
+const (
+	chartBarWidth = 36
+	chartHeight   = 160
+)
+
+// dashboard serves the web dashboard, computing its totals the way a sync does with the run loop's
+// connection.
+type dashboard struct {
+	health  *healthState
+	conn    *connection
+	mu      sync.Mutex
+	view    dashboardView
+	builtAt time.Time
+}
+
+// startDashboardServer serves the dashboard on addr in the background. The last sync status comes
+// from health and the calendar connection from conn, both of which the run loop keeps up to date.
+func startDashboardServer(addr string, health *healthState, conn *connection) {
+	d := &dashboard{health: health, conn: conn}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := dashboardTemplate.Execute(w, d.current(r.Context())); err != nil {
+			slog.Error("Error rendering the dashboard", "error", err)
+		}
+	})
+
+	go func() {
+		slog.Info("Serving the dashboard", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("Dashboard server stopped", "error", err)
+		}
+	}()
+}
+
+// current returns the dashboard, recomputing it once it is older than dashboardTTL.
+func (d *dashboard) current(ctx context.Context) dashboardView {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Since(d.builtAt) > dashboardTTL {
+		d.view = buildDashboard(ctx, d.conn, time.Now())
+		d.builtAt = time.Now()
+	}
+	view := d.view
+	lastSuccess, lastErr := d.health.lastRun()
+	view.LastSync = "never"
+	if !lastSuccess.IsZero() {
+		view.LastSync = lastSuccess.Format("2006-01-02 15:04:05")
+	}
+	if lastErr != nil {
+		view.SyncError = lastErr.Error()
+	}
+	return view
+}
+
+// buildDashboard computes the current pay period's total and upcoming payments and the forecast for
+// the pay periods of the next dashboardForecastMonths, as of now. Nothing is written.
+func buildDashboard(ctx context.Context, conn *connection, now time.Time) dashboardView {
+	view := dashboardView{UpdatedAt: now.Format("2006-01-02 15:04:05")}
+	ctx, cancel := context.WithTimeout(ctx, getRunTimeout())
+	defer cancel()
+	srv, config, err := conn.current()
+	if err != nil {
+		view.Error = err.Error()
+		return view
+	}
+	view.Label = config.EventLabel
+	loc, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		view.Error = err.Error()
+		return view
+	}
+	now = now.In(loc)
+
+	startDate, endDate := config.Periods.Period(now)
+	total, err := currentPeriodTotal(ctx, srv, startDate, endDate, config, now)
+	if err != nil {
+		view.Error = err.Error()
+		return view
+	}
+	view.Period = formatPeriod(config.Periods, startDate, endDate)
+	view.Remaining = formatRemaining(total, config)
+
+	events := append(total.Events[:0:0], total.Events...)
+	sort.SliceStable(events, func(i, j int) bool {
+		return eventStartDate(events[i], loc).Before(eventStartDate(events[j], loc))
+	})
+	for _, item := range events {
+		if isPaid(item, config) {
+			continue
+		}
+		payment := dashboardPayment{
+			Date:     eventStartDate(item, loc).Format("Mon 2 Jan"),
+			Summary:  item.Summary,
+			Category: paymentCategory(item),
+		}
+		if currency, amount, ok := config.Currency.ParseForeign(item.Summary); ok {
+			payment.Amount = currency.Format(amount)
+		} else if amount, ok := parseAmountFromSummary(item.Summary, config.Currency); ok {
+			payment.Amount = config.Currency.Format(amount)
+		} else {
+			continue
+		}
+		view.Payments = append(view.Payments, payment)
+	}
+
+	// The forecast, one bar per pay period scaled to the largest amount
+	var totals []PeriodTotal
+	horizon := now.AddDate(0, dashboardForecastMonths, 0)
+	for start, end := nextPeriod(config.Periods, endDate); start.Before(horizon); start, end = nextPeriod(config.Periods, end) {
+		total, err := futurePeriodTotal(ctx, srv, start, end, config)
+		if err != nil {
+			view.Error = err.Error()
+			return view
+		}
+		totals = append(totals, total)
+	}
+	largest := 0.0
+	for _, total := range totals {
+		largest = math.Max(largest, math.Abs(total.Remaining(config)))
+	}
+	for i, total := range totals {
+		amount := total.Remaining(config)
+		bar := dashboardBar{
+			Period:   formatPeriod(config.Periods, total.Start, total.End),
+			Amount:   formatRemaining(total, config),
+			X:        i * chartBarWidth,
+			Negative: amount < 0,
+		}
+		if largest > 0 {
+			bar.Height = int(math.Round(math.Abs(amount) / largest * chartHeight))
+		}
+		bar.Y = chartHeight - bar.Height
+		view.Forecast = append(view.Forecast, bar)
+	}
+	view.ChartSize = len(view.Forecast) * chartBarWidth
+	return view
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{with .Label}}{{.}}{{else}}Payment Tracker{{end}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem auto; max-width: 60rem; padding: 0 1rem; color: #222; }
+h1 { font-size: 1.4rem; margin-bottom: 0; }
+.total { font-size: 2.4rem; font-weight: bold; margin: .3rem 0 1.5rem; }
+.muted { color: #777; }
+.error { color: #b00020; }
+table { border-collapse: collapse; width: 100%; }
+td, th { text-align: left; padding: .3rem .6rem; border-bottom: 1px solid #eee; }
+td.amount { text-align: right; font-variant-numeric: tabular-nums; }
+rect { fill: #3b7dd8; }
+rect.negative { fill: #d0473b; }
+</style>
+</head>
+<body>
+{{if .Error}}<p class="error">Unable to compute the totals: {{.Error}}</p>{{end}}
+{{if .Period}}
+<h1>{{.Label}}, pay period {{.Period}}</h1>
+<p class="total">{{.Remaining}}</p>
+
+<h2>Upcoming payments</h2>
+{{if .Payments}}
+<table>
+<tr><th>Date</th><th>Payment</th><th>Category</th><th class="amount">Amount</th></tr>
+{{range .Payments}}<tr><td>{{.Date}}</td><td>{{.Summary}}</td><td>{{with .Category}}#{{.}}{{end}}</td><td class="amount">{{.Amount}}</td></tr>
+{{end}}</table>
+{{else}}<p class="muted">No payments left this pay period.</p>{{end}}
+{{end}}
+
+{{if .Forecast}}
+<h2>Forecast</h2>
+<svg width="{{.ChartSize}}" height="170" role="img" aria-label="Forecast by pay period">
+{{range .Forecast}}<rect x="{{.X}}" y="{{.Y}}" width="30" height="{{.Height}}"{{if .Negative}} class="negative"{{end}}><title>{{.Period}}: {{.Amount}}</title></rect>
+{{end}}</svg>
+{{end}}
+
+<h2>Sync</h2>
+<p>Last successful sync: {{.LastSync}}{{if .SyncError}}<br><span class="error">Last run failed: {{.SyncError}}</span>{{end}}</p>
+<p class="muted">Totals computed {{.UpdatedAt}}</p>
+</body>
+</html>
+`))
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Policies for managed events edited by hand, set with DRIFT_POLICY.
+const (
+	driftOverwrite = "overwrite" // Rewrite the event with the calculated amount, logging the edit
+	driftPreserve  = "preserve"  // Keep the edit without logging it
+	driftAlert     = "alert"     // Keep the edit, logging it on every run until it is undone
+)
+
+// eventDrift is a managed event whose title or description no longer match what the tracker wrote.
+type eventDrift struct {
+	EventID    string  `json:"eventId"`
+	Date       string  `json:"date"`
+	Summary    string  `json:"summary"`    // Title as edited
+	Written    float64 `json:"written"`    // Amount the tracker last wrote
+	Calculated float64 `json:"calculated"` // Amount calculated in this run
+}
+
+// eventFingerprint hashes the title and description of an event, so edits made to it after the
+// tracker wrote it can be told apart from the tracker's own writes.
+func eventFingerprint(event *calendar.Event) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(event.Summary) + "\x00" + strings.TrimSpace(event.Description)))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// editedByHand reports whether a managed event was changed since the tracker wrote it. Events
+// written before fingerprints were recorded are assumed not to be.
+func editedByHand(item *calendar.Event) bool {
+	if item.ExtendedProperties == nil {
+		return false
+	}
+	written, ok := item.ExtendedProperties.Private[fingerprintProperty]
+	return ok && written != eventFingerprint(item)
+}
+
+func newEventDrift(item *calendar.Event, d desiredEvent, config Config) eventDrift {
+	written, _ := eventAmount(item, config)
+	return eventDrift{EventID: item.Id, Date: item.Start.Date, Summary: item.Summary, Written: written, Calculated: d.Amount}
+}
+
+// describeDrift returns a one-line description of an edited event for logs and plan summaries.
+func describeDrift(d eventDrift, config Config) string {
+	return fmt.Sprintf("%q on %s (event %s) was edited by hand, it was written with %s and the amount is now %s",
+		d.Summary, d.Date, d.EventID, config.Currency.Format(d.Written), config.Currency.Format(d.Calculated))
+}
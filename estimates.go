@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// BillEstimate describes how to forecast a variable bill for months without a calendar event yet.
+type BillEstimate struct {
+	Name     string
+	LastYear bool        // Use last year's actual for the same period
+	Monthly  [12]float64 // Manual estimate per calendar month, January first
+}
+
+// parseBillEstimates reads estimates in the format "Electricity=120,110,...;Gas=last-year;Water=30".
+// A single amount applies to every month, twelve amounts give one estimate per month.
+func parseBillEstimates(value string) []BillEstimate {
+	var estimates []BillEstimate
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, spec, found := strings.Cut(entry, "=")
+		if !found || strings.TrimSpace(name) == "" {
+			slog.Warn("Ignoring invalid UTILITY_ESTIMATES entry, expected name=estimate", "entry", entry)
+			continue
+		}
+		estimate := BillEstimate{Name: strings.TrimSpace(name)}
+		spec = strings.TrimSpace(spec)
+		if spec == "last-year" {
+			estimate.LastYear = true
+			estimates = append(estimates, estimate)
+			continue
+		}
+
+		amounts := strings.Split(spec, ",")
+		if len(amounts) != 1 && len(amounts) != 12 {
+			slog.Warn("Ignoring UTILITY_ESTIMATES entry, expected 1 or 12 amounts", "entry", entry)
+			continue
+		}
+		valid := true
+		for i := range estimate.Monthly {
+			amount, err := strconv.ParseFloat(strings.TrimSpace(amounts[i%len(amounts)]), 64)
+			if err != nil {
+				slog.Warn("Ignoring UTILITY_ESTIMATES entry with an invalid amount", "entry", entry, "error", err)
+				valid = false
+				break
+			}
+			estimate.Monthly[i] = amount
+		}
+		if valid {
+			estimates = append(estimates, estimate)
+		}
+	}
+	return estimates
+}
+
+// estimateMissingBills adds up the estimates for variable bills that have no payment event in the period yet.
+func estimateMissingBills(ctx context.Context, srv CalendarProvider, events []*calendar.Event, startDate, endDate time.Time, config Config) (float64, error) {
+	var total float64
+	for _, estimate := range config.BillEstimates {
+		if len(matchingEvents(events, estimate.Name)) > 0 {
+			continue
+		}
+
+		if !estimate.LastYear {
+			total += estimate.Monthly[startDate.Month()-1]
+			continue
+		}
+
+		// The pay period a year earlier, which for week based schedules need not line up with the dates
+		lastStart, lastEnd := config.Periods.Period(startDate.AddDate(-1, 0, 0))
+		lastYear, err := listPaymentEvents(ctx, srv, lastStart, lastEnd, config)
+		if err != nil {
+			return 0, err
+		}
+		for _, item := range matchingEvents(lastYear, estimate.Name) {
+			if amount, ok := localAmount(item, config); ok {
+				total += amount
+			}
+		}
+	}
+	return total, nil
+}
+
+// matchingEvents returns the events whose summary mentions the given bill name.
+func matchingEvents(events []*calendar.Event, name string) []*calendar.Event {
+	var matches []*calendar.Event
+	for _, item := range events {
+		if strings.Contains(strings.ToLower(item.Summary), strings.ToLower(name)) {
+			matches = append(matches, item)
+		}
+	}
+	return matches
+}
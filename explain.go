@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// runExplainCommand handles "explain <event ID>", which looks the event up in the payment calendars
+// and prints whether it counts towards a total, and "explain -month 2024-08", which breaks down the
+// totals of the pay periods starting that month.
+func runExplainCommand(args []string) error {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	month := fs.String("month", "", "explain the totals of the pay periods starting in this month, as YYYY-MM")
+	fs.Parse(args)
+	if (*month == "") == (fs.NArg() == 0) {
+		return fmt.Errorf("usage: paymentTracker explain <event ID> | explain -month YYYY-MM")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), getRunTimeout())
+	defer cancel()
+
+	srv, config, err := prepareRun(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if *month != "" {
+		return explainMonth(ctx, srv, *month, config)
+	}
+	config.Skipped = nil // The explanation below covers what the skip log would say
+	return explainEvent(ctx, srv, fs.Arg(0), config)
+}
+
+// explainMonth prints, for each pay period starting in the month, every event behind its total with
+// its parsed amount, the adjustments on top and how the result compares with the calendar event.
+func explainMonth(ctx context.Context, srv CalendarProvider, month string, config Config) error {
+	loc, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		return fmt.Errorf("failed to load time zone '%s': %v", config.TimeZone, err)
+	}
+	first, err := time.ParseInLocation("2006-01", month, loc)
+	if err != nil {
+		return fmt.Errorf("invalid month %q, expected YYYY-MM", month)
+	}
+	next := first.AddDate(0, 1, 0)
+
+	events, err := loadTotalRemainingEvents(ctx, srv, config)
+	if err != nil {
+		return err
+	}
+	startDate, endDate := config.Periods.Period(first)
+	if startDate.Before(first) {
+		startDate, endDate = nextPeriod(config.Periods, endDate)
+	}
+	for ; startDate.Before(next); startDate, endDate = nextPeriod(config.Periods, endDate) {
+		if err := explainPeriod(ctx, srv, startDate, endDate, events, config, loc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// explainPeriod prints the breakdown of a single pay period, following the steps of the sync.
+func explainPeriod(ctx context.Context, srv CalendarProvider, startDate, endDate time.Time, events []*calendar.Event, config Config, loc *time.Location) error {
+	now := time.Now().In(loc)
+	fmt.Printf("Pay period %s\n", formatPeriod(config.Periods, startDate, endDate))
+	if !endDate.After(now) {
+		fmt.Println("  The period is over, its \"Total Remaining\" event is no longer kept. Its payments were:")
+	} else if startDate.Before(now) {
+		fmt.Println("  Only payments still to come count:")
+	}
+
+	total, err := calculateTotalPayments(ctx, srv, startDate, endDate, config)
+	if !endDate.After(now) {
+		var events []*calendar.Event
+		events, err = listPaymentEvents(ctx, srv, startDate, endDate, config)
+		total = PeriodTotal{Start: startDate, End: endDate, Events: events}
+		for _, item := range events {
+			if currency, amount, ok := config.Currency.ParseForeign(item.Summary); ok {
+				total.AddForeign(currency.Symbol, amount)
+			} else if amount, ok := parseAmountFromSummary(item.Summary, config.Currency); ok {
+				total.Add(paymentCategory(item), amount)
+				total.Payments = append(total.Payments, item)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for _, item := range total.Payments {
+		amount, _ := parseAmountFromSummary(item.Summary, config.Currency)
+		fmt.Printf("  %s  %12s  %s (event %s)\n", eventStartDate(item, loc).Format("2006-01-02"), config.Currency.Format(amount), item.Summary, item.Id)
+	}
+	fmt.Printf("  %-10s  %12s  Payments\n", "", config.Currency.Format(total.Amount))
+	if len(total.Foreign) > 0 {
+		fmt.Printf("  Payments in other currencies are not converted and are shown next to the total: %s\n", strings.Join(foreignTotals(total, config.Currency), ", "))
+	}
+	if !endDate.After(now) {
+		return nil
+	}
+
+	// Adjustments made on top of the payments, as in futurePeriodTotal
+	if gifts := plannedGiftSpend(config.Occasions, startDate, endDate); gifts > 0 {
+		total.Add(giftsCategory, gifts)
+		fmt.Printf("  %-10s %+13s  Planned gifts\n", "", config.Currency.Format(gifts))
+	}
+	if len(config.BillEstimates) > 0 && startDate.After(now) {
+		estimate, err := estimateMissingBills(ctx, srv, total.Events, startDate, endDate, config)
+		if err != nil {
+			return err
+		}
+		if estimate > 0 {
+			total.Add(estimatedCategory, estimate)
+			fmt.Printf("  %-10s %+13s  Estimated bills not entered yet\n", "", config.Currency.Format(estimate))
+		}
+	}
+	if tracksIncome(config) {
+		if err := addIncome(ctx, srv, &total, config, now); err != nil {
+			return err
+		}
+	}
+	if config.IncomeSource != "" {
+		fmt.Printf("  %-10s  %12s  Income\n", "", config.Currency.Format(total.Income))
+		fmt.Printf("  %-10s  %12s  Already paid this period\n", "", config.Currency.Format(-total.Paid))
+		fmt.Printf("  %-10s  %12s  Upcoming payments and adjustments\n", "", config.Currency.Format(-total.Amount))
+	}
+	remaining := total.Remaining(config)
+	fmt.Printf("  %-10s  %12s  %s\n", "", formatRemaining(total, config), config.EventLabel)
+
+	// Reconcile with the event on the calendar
+	eventDate, err := getTotalRemainingEventDate(startDate, endDate, config)
+	if err != nil {
+		return err
+	}
+	for _, item := range events {
+		if item.Start.Date != eventDate.Format("2006-01-02") {
+			continue
+		}
+		shown, ok := eventAmount(item, config)
+		switch {
+		case !ok:
+			fmt.Printf("  The calendar event %q shows no amount\n", item.Summary)
+		case math.Abs(shown-remaining) <= config.WriteThreshold:
+			fmt.Printf("  Matches the calendar event %q\n", item.Summary)
+		default:
+			fmt.Printf("  The calendar event %q differs by %s, the next run will update it\n", item.Summary, config.Currency.Format(remaining-shown))
+		}
+		return nil
+	}
+	fmt.Printf("  No \"Total Remaining\" event on %s yet, the next run will add it\n", eventDate.Format("2006-01-02"))
+	return nil
+}
+
+// explainEvent prints the verdict for a single event, checking the rules in the order the sync applies them.
+func explainEvent(ctx context.Context, srv CalendarProvider, eventID string, config Config) error {
+	item, calendarID, err := findPaymentEvent(ctx, srv, eventID, config)
+	if err != nil {
+		return err
+	}
+	if item.Start == nil {
+		return fmt.Errorf("event %s has no start", eventID)
+	}
+	loc, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		return fmt.Errorf("failed to load time zone '%s': %v", config.TimeZone, err)
+	}
+	date := eventStartDate(item, loc)
+	fmt.Printf("%q on %s in calendar %s\n", item.Summary, date.Format("2006-01-02"), calendarID)
+
+	if !mentionsAny(item, []string{"Payment"}) {
+		fmt.Println("Skipped: it does not mention \"Payment\", so the payment query does not match it")
+		return nil
+	}
+	if reason := statusSkipReason(item, config); reason != "" {
+		fmt.Printf("Skipped: %s\n", reason)
+		return nil
+	}
+	if isPaid(item, config) {
+		fmt.Println("Skipped: it is marked as paid, by PAID_COLOR or PAID_MARKER")
+		return nil
+	}
+	if currency, amount, ok := config.Currency.ParseForeign(item.Summary); ok {
+		fmt.Printf("Counted apart: %s is in another currency, so it is shown next to the total rather than added to it\n", currency.Format(amount))
+		return nil
+	}
+	amount, ok := parseAmountFromSummary(item.Summary, config.Currency)
+	if !ok {
+		fmt.Printf("Skipped: no amount found in the title, amounts look like %s\n", config.Currency.Format(1234.56))
+		return nil
+	}
+	if original, ok, err := findDuplicateOf(ctx, srv, item, calendarID, config); err != nil {
+		return err
+	} else if ok {
+		fmt.Printf("Skipped: duplicate of the same event in calendar %s, which is counted instead\n", original)
+		return nil
+	}
+
+	now := time.Now().In(loc)
+	if _, end := eventBounds(item, loc); !end.After(now) {
+		fmt.Println("Skipped: it has already happened, so it is no longer remaining")
+		return nil
+	}
+
+	startDate, endDate := config.Periods.Period(date)
+	_, horizon := config.Periods.Period(now)
+	for i := 0; i < config.ForecastPeriods; i++ {
+		_, horizon = nextPeriod(config.Periods, horizon)
+	}
+	if startDate.After(horizon) {
+		fmt.Printf("Skipped: its pay period starts after the forecast horizon of %d periods\n", config.ForecastPeriods)
+		return nil
+	}
+
+	category := paymentCategory(item)
+	if category == "" {
+		category = "none"
+	}
+	fmt.Printf("Counted: %s towards the pay period %s (category %s)\n",
+		config.Currency.Format(amount), formatPeriod(config.Periods, startDate, endDate), category)
+	return nil
+}
+
+// findPaymentEvent fetches an event by ID from whichever payment calendar holds it.
+func findPaymentEvent(ctx context.Context, srv CalendarProvider, eventID string, config Config) (*calendar.Event, string, error) {
+	for _, calendarID := range config.PaymentCalendars {
+		item, err := srv.GetEvent(ctx, calendarID, eventID)
+		if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to retrieve event from calendar %s: %v", calendarID, err)
+		}
+		return item, calendarID, nil
+	}
+	return nil, "", fmt.Errorf("event %s was not found in the payment calendars", eventID)
+}
+
+// findDuplicateOf reports the earlier payment calendar holding a copy of the event, if any. The
+// sync keeps the copy from the first calendar listed in PAYMENT_CALENDARS.
+func findDuplicateOf(ctx context.Context, srv CalendarProvider, item *calendar.Event, calendarID string, config Config) (string, bool, error) {
+	key := duplicateKey(item)
+	if key == "" {
+		return "", false, nil
+	}
+	for _, other := range config.PaymentCalendars {
+		if other == calendarID {
+			return "", false, nil
+		}
+		copies, err := srv.ListEvents(ctx, other, EventQuery{ICalUID: item.ICalUID})
+		if err != nil {
+			return "", false, fmt.Errorf("unable to retrieve events from calendar %s: %v", other, err)
+		}
+		for _, dup := range copies {
+			if duplicateKey(dup) == key {
+				return other, true, nil
+			}
+		}
+	}
+	return "", false, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// defaultExportTemplate renders the upcoming payments as a simple bulk-payment CSV.
+const defaultExportTemplate = `Date,Payee,Amount,Currency
+{{range .Payments}}{{.Date.Format "2006-01-02"}},{{csv .Payee}},{{printf "%.2f" .Amount}},{{csv .Currency}}
+{{end}}`
+
+// ScheduledPayment is a single upcoming payment as exposed to export templates.
+type ScheduledPayment struct {
+	Date     time.Time
+	Payee    string
+	Amount   float64
+	Currency string // Symbol of the amount's currency, which need not be the configured currency
+}
+
+// exportScheduledPayments writes the upcoming payments of the period to a file rendered from
+// the configured template, so they can be uploaded to a bank's bulk-payment facility. Payments
+// marked as paid are left out, as they need no paying.
+func exportScheduledPayments(events []*calendar.Event, config Config, loc *time.Location) error {
+	text := defaultExportTemplate
+	if config.ExportTemplatePath != "" {
+		b, err := os.ReadFile(config.ExportTemplatePath)
+		if err != nil {
+			return fmt.Errorf("unable to read export template: %v", err)
+		}
+		text = string(b)
+	}
+
+	tmpl, err := template.New("export").Funcs(template.FuncMap{"csv": csvField}).Parse(text)
+	if err != nil {
+		return fmt.Errorf("unable to parse export template: %v", err)
+	}
+
+	var payments []ScheduledPayment
+	for _, item := range events {
+		if isPaid(item, config) {
+			continue
+		}
+		currency := config.Currency
+		amount, ok := parseAmountFromSummary(item.Summary, currency)
+		if foreign, foreignAmount, isForeign := config.Currency.ParseForeign(item.Summary); isForeign {
+			currency, amount, ok = foreign, foreignAmount, true
+		}
+		if !ok {
+			continue
+		}
+		payments = append(payments, ScheduledPayment{
+			Date:     eventStartDate(item, loc),
+			Payee:    strings.TrimSpace(item.Summary),
+			Amount:   amount,
+			Currency: currency.Symbol,
+		})
+	}
+
+	f, err := os.Create(config.ExportPath)
+	if err != nil {
+		return fmt.Errorf("unable to create export file: %v", err)
+	}
+	defer f.Close()
+
+	return tmpl.Execute(f, struct {
+		Payments    []ScheduledPayment
+		GeneratedAt time.Time
+	}{payments, time.Now().In(loc)})
+}
+
+// eventStartDate returns the start of an event, whether it is all-day or timed.
+func eventStartDate(item *calendar.Event, loc *time.Location) time.Time {
+	if item.Start == nil {
+		return time.Time{}
+	}
+	if item.Start.DateTime != "" {
+		if t, err := time.Parse(time.RFC3339, item.Start.DateTime); err == nil {
+			return t.In(loc)
+		}
+	}
+	t, _ := time.ParseInLocation("2006-01-02", item.Start.Date, loc)
+	return t
+}
+
+// csvField quotes a value for use in a CSV row when needed.
+func csvField(value string) string {
+	if strings.ContainsAny(value, ",\"\n") {
+		return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+	}
+	return value
+}
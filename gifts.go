@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Occasion is a yearly date (birthday, anniversary...) with a planned gift budget.
+type Occasion struct {
+	Name   string
+	Month  time.Month
+	Day    int
+	Budget float64
+}
+
+// parseOccasions reads occasions in the format "Mum:03-14:50,Dad:11-02:40".
+func parseOccasions(value string) []Occasion {
+	var occasions []Occasion
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			slog.Warn("Ignoring invalid GIFT_OCCASIONS entry, expected name:MM-DD:budget", "entry", entry)
+			continue
+		}
+		date, err := time.Parse("01-02", strings.TrimSpace(parts[1]))
+		if err != nil {
+			slog.Warn("Ignoring GIFT_OCCASIONS entry with an invalid date", "entry", entry, "error", err)
+			continue
+		}
+		budget, err := strconv.ParseFloat(strings.TrimSpace(parts[2]), 64)
+		if err != nil {
+			slog.Warn("Ignoring GIFT_OCCASIONS entry with an invalid budget", "entry", entry, "error", err)
+			continue
+		}
+		occasions = append(occasions, Occasion{
+			Name:   strings.TrimSpace(parts[0]),
+			Month:  date.Month(),
+			Day:    date.Day(),
+			Budget: budget,
+		})
+	}
+	return occasions
+}
+
+// nextOccurrence returns the first date of the occasion on or after the given time. An occasion on
+// 29 February falls on the 28th in other years.
+func (o Occasion) nextOccurrence(after time.Time) time.Time {
+	date := dateInMonth(after.Year(), o.Month, o.Day, after.Location())
+	if date.Before(time.Date(after.Year(), after.Month(), after.Day(), 0, 0, 0, 0, after.Location())) {
+		date = dateInMonth(after.Year()+1, o.Month, o.Day, after.Location())
+	}
+	return date
+}
+
+// plannedGiftSpend sums the gift budgets of the occasions falling in the given period.
+func plannedGiftSpend(occasions []Occasion, startDate, endDate time.Time) float64 {
+	var total float64
+	now := time.Now()
+
+	// Ensure start date is not before today, matching calculateTotalPayments
+	if startDate.Before(now) {
+		startDate = now
+	}
+
+	for _, occasion := range occasions {
+		for date := occasion.nextOccurrence(startDate); !date.After(endDate); date = occasion.nextOccurrence(date.AddDate(0, 0, 1)) {
+			total += occasion.Budget
+		}
+	}
+	return total
+}
+
+// giftReminders holds the occasions reminded of when there is no history file to record them in,
+// so a run loop still reminds of each only once.
+var giftReminders = struct {
+	sync.Mutex
+	sent map[string]time.Time
+}{sent: make(map[string]time.Time)}
+
+// remindUpcomingOccasions sends a reminder through the notifiers for each occasion coming up within
+// GIFT_REMINDER_DAYS, once per occurrence. The occasions reminded of are recorded in the history file,
+// or for as long as the process runs without one. Failures are only logged.
+func remindUpcomingOccasions(config Config, now time.Time) {
+	type reminder struct {
+		occasion Occasion
+		date     time.Time
+		days     int
+	}
+	var due []reminder
+	for _, occasion := range config.Occasions {
+		date := occasion.nextOccurrence(now)
+		if days := int(date.Sub(now).Hours() / 24); days <= config.GiftReminderDays {
+			due = append(due, reminder{occasion, date, days})
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	giftReminders.Lock()
+	defer giftReminders.Unlock()
+	sent := giftReminders.sent
+	var history *History
+	if config.HistoryPath != "" {
+		var err error
+		if history, err = loadHistory(config.HistoryPath); err != nil {
+			slog.Error("Error reading the gift occasions already reminded of", "error", err)
+			return
+		}
+		sent = history.Reminders
+	}
+
+	var lines []string
+	for _, r := range due {
+		key := r.occasion.Name + " " + r.date.Format("2006-01-02")
+		if _, ok := sent[key]; ok {
+			continue
+		}
+		sent[key] = now
+		slog.Info("Gift occasion coming up", "occasion", r.occasion.Name, "date", r.date.Format("2006-01-02"), "in", pluralDays(r.days), "budget", config.Currency.Format(r.occasion.Budget))
+		lines = append(lines, fmt.Sprintf("%s on %s, in %s, budget %s", r.occasion.Name, r.date.Format("Mon 2 Jan"), pluralDays(r.days), config.Currency.Format(r.occasion.Budget)))
+	}
+	if len(lines) == 0 {
+		return
+	}
+	notify(config, notifyGifts, "Gift occasions coming up", strings.Join(lines, "\n"))
+	if history != nil {
+		if err := saveHistory(history, config.HistoryPath); err != nil {
+			slog.Error("Error recording the gift occasions reminded of", "error", err)
+		}
+	}
+}
+
+func pluralDays(days int) string {
+	if days == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", days)
+}
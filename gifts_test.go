@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextOccurrence(t *testing.T) {
+	london := mustLoadLocation(t, "Europe/London")
+	tests := []struct {
+		name     string
+		occasion Occasion
+		after    time.Time
+		want     time.Time
+	}{
+		{"later this year", Occasion{Month: time.March, Day: 14}, time.Date(2025, time.January, 10, 15, 0, 0, 0, london), date(2025, time.March, 14, london)},
+		{"today", Occasion{Month: time.March, Day: 14}, time.Date(2025, time.March, 14, 18, 30, 0, 0, london), date(2025, time.March, 14, london)},
+		{"passed this year", Occasion{Month: time.March, Day: 14}, time.Date(2025, time.March, 15, 0, 0, 0, 0, london), date(2026, time.March, 14, london)},
+		{"29 February in a leap year", Occasion{Month: time.February, Day: 29}, date(2028, time.January, 1, london), date(2028, time.February, 29, london)},
+		{"29 February in a common year", Occasion{Month: time.February, Day: 29}, date(2025, time.January, 1, london), date(2025, time.February, 28, london)},
+		{"29 February passed in a common year", Occasion{Month: time.February, Day: 29}, date(2027, time.March, 1, london), date(2028, time.February, 29, london)},
+		{"29 February passed in a leap year", Occasion{Month: time.February, Day: 29}, date(2028, time.March, 1, london), date(2029, time.February, 28, london)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.occasion.nextOccurrence(tt.after); !got.Equal(tt.want) {
+				t.Errorf("nextOccurrence(%v) = %v, want %v", tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOccasionsLeapDay(t *testing.T) {
+	occasions := parseOccasions("Leapling:02-29:25")
+	if len(occasions) != 1 || occasions[0].Month != time.February || occasions[0].Day != 29 {
+		t.Fatalf("parseOccasions(\"Leapling:02-29:25\") = %+v, want one occasion on 29 February", occasions)
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/microsoft"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const graphBaseURL = "https://graph.microsoft.com/v1.0"
+
+// The tracker's private properties are kept in two single-value extended properties in its own
+// namespace: the managed event type on its own so events can be filtered by it, and the whole
+// set as JSON.
+const (
+	graphPropertyNamespace = "String {5b8e0c2a-7d4f-4e61-9a3b-2c6f1d8e4a07} Name "
+	graphTypeProperty      = graphPropertyNamespace + managedEventProperty
+	graphPrivateProperty   = graphPropertyNamespace + "private"
+)
+
+// loadOutlookOAuth2Config returns the OAuth configuration of the Azure app registration given by
+// OUTLOOK_CLIENT_ID, OUTLOOK_CLIENT_SECRET (empty for public clients) and OUTLOOK_TENANT.
+func loadOutlookOAuth2Config() (*oauth2.Config, error) {
+	clientID := os.Getenv("OUTLOOK_CLIENT_ID")
+	if clientID == "" {
+		return nil, fmt.Errorf("OUTLOOK_CLIENT_ID must be set for PROVIDER=outlook")
+	}
+	tenant := os.Getenv("OUTLOOK_TENANT")
+	if tenant == "" {
+		tenant = "common" // Default value, work, school and personal accounts
+	}
+	endpoint := microsoft.AzureADEndpoint(tenant)
+	endpoint.DeviceAuthURL = "https://login.microsoftonline.com/" + tenant + "/oauth2/v2.0/devicecode"
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("OUTLOOK_CLIENT_SECRET"),
+		Endpoint:     endpoint,
+		RedirectURL:  "http://localhost",
+		Scopes:       []string{"offline_access", "Calendars.ReadWrite"},
+	}, nil
+}
+
+// graphProvider is the Microsoft Graph API, for Outlook and Microsoft 365 calendars. Outlook has
+// no event colours, so colour IDs are neither read nor written.
+type graphProvider struct {
+	client *http.Client
+}
+
+// graphEvent is the subset of a Graph event the tracker uses.
+type graphEvent struct {
+	ID             string          `json:"id,omitempty"`
+	ETag           string          `json:"@odata.etag,omitempty"`
+	TransactionID  string          `json:"transactionId,omitempty"`
+	Subject        *string         `json:"subject,omitempty"`
+	Body           *graphBody      `json:"body,omitempty"`
+	Start          *graphDateTime  `json:"start,omitempty"`
+	End            *graphDateTime  `json:"end,omitempty"`
+	IsAllDay       *bool           `json:"isAllDay,omitempty"`
+	ShowAs         string          `json:"showAs,omitempty"`
+	Location       *graphLocation  `json:"location,omitempty"`
+	ICalUID        string          `json:"iCalUId,omitempty"`
+	IsCancelled    bool            `json:"isCancelled,omitempty"`
+	ResponseStatus *graphResponse  `json:"responseStatus,omitempty"`
+	Properties     []graphProperty `json:"singleValueExtendedProperties,omitempty"`
+}
+
+type graphBody struct {
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+}
+
+type graphDateTime struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+type graphLocation struct {
+	DisplayName string `json:"displayName"`
+}
+
+type graphResponse struct {
+	Response string `json:"response"`
+}
+
+type graphProperty struct {
+	ID    string `json:"id"`
+	Value string `json:"value"`
+}
+
+// graphResponseStatuses maps Graph responses to an invitation onto the Google equivalents.
+var graphResponseStatuses = map[string]string{
+	"accepted":            "accepted",
+	"tentativelyAccepted": "tentative",
+	"declined":            "declined",
+	"notResponded":        "needsAction",
+}
+
+// toEvent converts a Graph event, with times in the given zone, to the tracker's representation.
+func (e graphEvent) toEvent(loc *time.Location) *calendar.Event {
+	item := &calendar.Event{Id: e.ID, Etag: e.ETag, ICalUID: e.ICalUID, Status: "confirmed"}
+	if e.Subject != nil {
+		item.Summary = *e.Subject
+	}
+	if e.Body != nil {
+		item.Description = strings.TrimSpace(strings.ReplaceAll(e.Body.Content, "\r\n", "\n"))
+	}
+	if e.Location != nil {
+		item.Location = e.Location.DisplayName
+	}
+	if e.IsCancelled {
+		item.Status = "cancelled"
+	}
+	if e.ShowAs == "free" {
+		item.Transparency = "transparent"
+	}
+	if e.ResponseStatus != nil {
+		if status, ok := graphResponseStatuses[e.ResponseStatus.Response]; ok {
+			item.Attendees = []*calendar.EventAttendee{{Self: true, ResponseStatus: status}}
+		}
+	}
+	allDay := e.IsAllDay != nil && *e.IsAllDay
+	item.Start = e.Start.toDateTime(allDay, loc)
+	item.End = e.End.toDateTime(allDay, loc)
+	for _, property := range e.Properties {
+		if property.ID != graphPrivateProperty {
+			continue
+		}
+		private := make(map[string]string)
+		if json.Unmarshal([]byte(property.Value), &private) == nil {
+			item.ExtendedProperties = &calendar.EventExtendedProperties{Private: private}
+		}
+	}
+	return item
+}
+
+// toDateTime converts a Graph time to a date for all-day events and an RFC 3339 time otherwise.
+func (d *graphDateTime) toDateTime(allDay bool, loc *time.Location) *calendar.EventDateTime {
+	if d == nil {
+		return nil
+	}
+	if allDay && len(d.DateTime) >= 10 {
+		return &calendar.EventDateTime{Date: d.DateTime[:10]}
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04:05.9999999", d.DateTime, loc)
+	if err != nil {
+		return &calendar.EventDateTime{DateTime: d.DateTime}
+	}
+	return &calendar.EventDateTime{DateTime: t.Format(time.RFC3339)}
+}
+
+// fromEvent converts the fields set in an event to a Graph event, for inserts and patches.
+func fromEvent(event *calendar.Event) graphEvent {
+	var e graphEvent
+	if event.Summary != "" {
+		e.Subject = &event.Summary
+	}
+	if event.Description != "" || containsField(event.NullFields, "Description") {
+		e.Body = &graphBody{ContentType: "text", Content: event.Description}
+	}
+	if event.Start != nil && event.End != nil {
+		allDay := event.Start.Date != ""
+		e.IsAllDay = &allDay
+		e.Start = fromDateTime(event.Start)
+		e.End = fromDateTime(event.End)
+	}
+	switch event.Transparency {
+	case "transparent":
+		e.ShowAs = "free"
+	case "opaque":
+		e.ShowAs = "busy"
+	}
+	if event.ExtendedProperties != nil && event.ExtendedProperties.Private != nil {
+		private, _ := json.Marshal(event.ExtendedProperties.Private)
+		e.Properties = []graphProperty{
+			{ID: graphTypeProperty, Value: event.ExtendedProperties.Private[managedEventProperty]},
+			{ID: graphPrivateProperty, Value: string(private)},
+		}
+	}
+	return e
+}
+
+// fromDateTime converts a date or RFC 3339 time to a Graph time.
+func fromDateTime(d *calendar.EventDateTime) *graphDateTime {
+	zone := d.TimeZone
+	if zone == "" {
+		zone = "UTC"
+	}
+	if d.Date != "" {
+		return &graphDateTime{DateTime: d.Date + "T00:00:00", TimeZone: zone}
+	}
+	t, err := time.Parse(time.RFC3339, d.DateTime)
+	if err != nil {
+		return &graphDateTime{DateTime: d.DateTime, TimeZone: zone}
+	}
+	return &graphDateTime{DateTime: t.UTC().Format("2006-01-02T15:04:05"), TimeZone: "UTC"}
+}
+
+func containsField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// calendarPath returns the Graph path of a calendar, "primary" being the user's default one.
+func calendarPath(calendarID string) string {
+	if calendarID == "primary" {
+		return "/me/calendar"
+	}
+	return "/me/calendars/" + url.PathEscape(calendarID)
+}
+
+// expandProperties asks Graph to include the tracker's extended properties with each event.
+var expandProperties = fmt.Sprintf("singleValueExtendedProperties($filter=id eq '%s' or id eq '%s')", graphTypeProperty, graphPrivateProperty)
+
+func (g *graphProvider) ListEvents(ctx context.Context, calendarID string, query EventQuery) ([]*calendar.Event, error) {
+	zone := query.TimeZone
+	if zone == "" {
+		zone = "UTC"
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load time zone '%s': %v", zone, err)
+	}
+
+	params := url.Values{"$top": {"100"}, "$expand": {expandProperties}}
+	path := calendarPath(calendarID) + "/events"
+	if !query.TimeMin.IsZero() || !query.TimeMax.IsZero() {
+		// The calendar view expands recurring events into their instances; it needs both bounds
+		timeMin, timeMax := query.TimeMin, query.TimeMax
+		if timeMin.IsZero() {
+			timeMin = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+		}
+		if timeMax.IsZero() {
+			timeMax = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+		}
+		path = calendarPath(calendarID) + "/calendarView"
+		params.Set("startDateTime", timeMin.UTC().Format(time.RFC3339))
+		params.Set("endDateTime", timeMax.UTC().Format(time.RFC3339))
+		params.Set("$orderby", "start/dateTime")
+	}
+	var filters []string
+	if query.ICalUID != "" {
+		filters = append(filters, fmt.Sprintf("iCalUId eq '%s'", odataString(query.ICalUID)))
+	}
+	if query.Property != "" {
+		name, value, _ := strings.Cut(query.Property, "=")
+		if name != managedEventProperty {
+			return nil, fmt.Errorf("unsupported property filter %q for Outlook calendars", query.Property)
+		}
+		filters = append(filters, fmt.Sprintf("singleValueExtendedProperties/Any(ep: ep/id eq '%s' and ep/value eq '%s')", graphTypeProperty, odataString(value)))
+	}
+	if len(filters) > 0 {
+		params.Set("$filter", strings.Join(filters, " and "))
+	}
+
+	var items []*calendar.Event
+	next := graphBaseURL + path + "?" + params.Encode()
+	for next != "" {
+		var page struct {
+			Value    []graphEvent `json:"value"`
+			NextLink string       `json:"@odata.nextLink"`
+		}
+		if err := g.do(ctx, http.MethodGet, next, "", zone, nil, &page); err != nil {
+			return nil, err
+		}
+		for _, e := range page.Value {
+			item := e.toEvent(loc)
+			if item.Status == "cancelled" && !query.ShowDeleted {
+				continue
+			}
+			// Graph has no free text search on calendar views, so the text is matched here
+			if query.Text != "" && !mentionsAny(item, []string{query.Text}) {
+				continue
+			}
+			items = append(items, item)
+		}
+		next = page.NextLink
+	}
+	return items, nil
+}
+
+func (g *graphProvider) GetEvent(ctx context.Context, calendarID, eventID string) (*calendar.Event, error) {
+	var e graphEvent
+	endpoint := graphBaseURL + calendarPath(calendarID) + "/events/" + url.PathEscape(eventID) + "?" + url.Values{"$expand": {expandProperties}}.Encode()
+	if err := g.do(ctx, http.MethodGet, endpoint, "", "UTC", nil, &e); err != nil {
+		return nil, err
+	}
+	return e.toEvent(time.UTC), nil
+}
+
+func (g *graphProvider) InsertEvent(ctx context.Context, calendarID string, event *calendar.Event) (*calendar.Event, error) {
+	e := fromEvent(event)
+	e.TransactionID = event.Id // Graph assigns IDs itself, but drops repeated inserts with the same transaction ID
+	var created graphEvent
+	if err := g.do(ctx, http.MethodPost, graphBaseURL+calendarPath(calendarID)+"/events", "", "UTC", e, &created); err != nil {
+		return nil, err
+	}
+	return created.toEvent(time.UTC), nil
+}
+
+func (g *graphProvider) PatchEvent(ctx context.Context, calendarID, eventID, etag string, event *calendar.Event) error {
+	endpoint := graphBaseURL + calendarPath(calendarID) + "/events/" + url.PathEscape(eventID)
+	return g.do(ctx, http.MethodPatch, endpoint, etag, "UTC", fromEvent(event), nil)
+}
+
+// UpdateEvent writes every field the tracker sets; Graph has no way to replace an event whole.
+func (g *graphProvider) UpdateEvent(ctx context.Context, calendarID, eventID, etag string, event *calendar.Event) error {
+	e := fromEvent(event)
+	if e.Body == nil {
+		e.Body = &graphBody{ContentType: "text"}
+	}
+	if e.ShowAs == "" {
+		e.ShowAs = "busy"
+	}
+	endpoint := graphBaseURL + calendarPath(calendarID) + "/events/" + url.PathEscape(eventID)
+	return g.do(ctx, http.MethodPatch, endpoint, etag, "UTC", e, nil)
+}
+
+func (g *graphProvider) DeleteEvent(ctx context.Context, calendarID, eventID, etag string) error {
+	endpoint := graphBaseURL + calendarPath(calendarID) + "/events/" + url.PathEscape(eventID)
+	return g.do(ctx, http.MethodDelete, endpoint, etag, "UTC", nil, nil)
+}
+
+func (g *graphProvider) ListCalendars(ctx context.Context) ([]*calendar.CalendarListEntry, error) {
+	var entries []*calendar.CalendarListEntry
+	next := graphBaseURL + "/me/calendars?$top=100"
+	for next != "" {
+		var page struct {
+			Value []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		if err := g.do(ctx, http.MethodGet, next, "", "UTC", nil, &page); err != nil {
+			return nil, err
+		}
+		for _, c := range page.Value {
+			entries = append(entries, &calendar.CalendarListEntry{Id: c.ID, Summary: c.Name})
+		}
+		next = page.NextLink
+	}
+	return entries, nil
+}
+
+func (g *graphProvider) CreateCalendar(ctx context.Context, name, timeZone string) (string, error) {
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := g.do(ctx, http.MethodPost, graphBaseURL+"/me/calendars", "", "UTC", map[string]string{"name": name}, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (g *graphProvider) DeleteCalendar(ctx context.Context, calendarID string) error {
+	return g.do(ctx, http.MethodDelete, graphBaseURL+calendarPath(calendarID), "", "UTC", nil, nil)
+}
+
+// do sends a Graph request with times in the given zone and plain text bodies, decoding the reply
+// into out. Failures are returned as *googleapi.Error carrying the HTTP status.
+func (g *graphProvider) do(ctx context.Context, method, endpoint, etag, zone string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	req.Header.Add("Prefer", fmt.Sprintf("outlook.timezone=%q", zone))
+	req.Header.Add("Prefer", `outlook.body-content-type="text"`)
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var reply struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		b, _ := io.ReadAll(resp.Body)
+		json.Unmarshal(b, &reply)
+		return &googleapi.Error{Code: resp.StatusCode, Message: reply.Error.Message, Body: string(b)}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// odataString escapes a value for a single quoted OData string literal.
+func odataString(value string) string {
+	return strings.ReplaceAll(value, "'", "''")
+}
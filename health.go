@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// How long a Calendar API reachability check is reused for, so frequent probes don't eat into the quota.
+const apiProbeTTL = time.Minute
+
+// healthState tracks what the readiness endpoint reports on: when the last run succeeded, the
+// current run interval and the result of the latest Calendar API check.
+type healthState struct {
+	config      Config        // Whose accounts the Calendar API check covers
+	monitor     *quotaMonitor // Counts the check's quota responses with the runs', nil for none
+	probing     sync.Mutex    // Held while the Calendar API is checked, so only one check runs at a time
+	mu          sync.Mutex
+	lastSuccess time.Time
+	lastErr     error
+	interval    time.Duration
+	probedAt    time.Time
+	probeErr    error
+}
+
+// recordRun notes the outcome of a run and the interval until the next one.
+func (h *healthState) recordRun(err error, interval time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastErr = err
+	h.interval = interval
+	if err == nil {
+		h.lastSuccess = time.Now()
+	}
+}
+
+// lastRun returns when the last successful run finished and the error of the latest run, nil when
+// it succeeded.
+func (h *healthState) lastRun() (time.Time, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastSuccess, h.lastErr
+}
+
+// ready reports why the tracker is not ready, or nil when it is: the OAuth token must load, the
+// Calendar API must answer with it and the last successful run must be within two run intervals.
+func (h *healthState) ready() error {
+	if err := h.probe(); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lastSuccess.IsZero() {
+		return fmt.Errorf("no successful run yet")
+	}
+	if age := time.Since(h.lastSuccess); age > 2*h.interval {
+		return fmt.Errorf("last successful run was %v ago (last error: %v)", age.Round(time.Second), h.lastErr)
+	}
+	return nil
+}
+
+// probe returns the result of the latest Calendar API check, checking again once it is older than
+// apiProbeTTL. The check runs without h.mu held, so runs recording their outcome never wait on it.
+func (h *healthState) probe() error {
+	h.probing.Lock()
+	defer h.probing.Unlock()
+
+	h.mu.Lock()
+	probedAt, probeErr := h.probedAt, h.probeErr
+	h.mu.Unlock()
+	if time.Since(probedAt) <= apiProbeTTL {
+		return probeErr
+	}
+
+	probeErr = probeCalendarAPI(h.config, h.monitor)
+	h.mu.Lock()
+	h.probedAt, h.probeErr = time.Now(), probeErr
+	h.mu.Unlock()
+	return probeErr
+}
+
+// probeCalendarAPI checks that the saved token of every account is usable by listing a single
+// calendar with it. Unlike getClient it never falls back to interactive authorization. The checks
+// take their turn with the runs' requests and back off from rate limiting as they do.
+func probeCalendarAPI(appConfig Config, monitor *quotaMonitor) error {
+	switch getProvider() {
+	case providerCaldav:
+		return probeCaldav()
+	case providerICS:
+		return nil // No calendar service to reach
+	}
+	config, err := loadOAuth2Config(nil) // Refreshing the token needs no scopes
+	if err != nil {
+		return fmt.Errorf("error loading OAuth2 configuration: %v", err)
+	}
+	for _, account := range accountNames(appConfig) {
+		if err := probeToken(config, accountTokenFile(appConfig, account), monitor); err != nil {
+			if account != "" {
+				return fmt.Errorf("account %s: %v", account, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// probeToken checks that the token saved in tokenFile is usable by listing a single calendar.
+func probeToken(config *oauth2.Config, tokenFile string, monitor *quotaMonitor) error {
+	tok, err := tokenFromFile(tokenFile)
+	if err != nil {
+		return fmt.Errorf("no usable OAuth token: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	endpoint := "https://www.googleapis.com/calendar/v3/users/me/calendarList?maxResults=1"
+	if getProvider() == providerOutlook {
+		endpoint = graphBaseURL + "/me/calendars?$top=1"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	client := config.Client(ctx, tok)
+	if monitor != nil {
+		client.Transport = monitor.wrap(client.Transport)
+	}
+	client.Transport = retrying(client.Transport)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Calendar API unreachable: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Calendar API returned %s", resp.Status)
+	}
+	return nil
+}
+
+// probeCaldav checks that the CalDAV server accepts the configured credentials by looking up the
+// calendar home.
+func probeCaldav() error {
+	client, err := caldavClient()
+	if err != nil {
+		return err
+	}
+	home, err := caldavHome()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "PROPFIND", home.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Depth", "0")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("CalDAV server unreachable: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return fmt.Errorf("CalDAV server returned %s", resp.Status)
+	}
+	return nil
+}
+
+// startHealthServer serves /healthz, which answers as long as the process is up, and /readyz, which
+// fails while the tracker cannot do its job, so an orchestrator can restart it when it wedges.
+func startHealthServer(addr string, health *healthState) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := health.ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	})
+
+	go func() {
+		slog.Info("Serving health checks", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("Health server stopped", "error", err)
+		}
+	}()
+}
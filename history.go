@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// PaymentRecord is a payment event as it was last seen on a payment calendar.
+type PaymentRecord struct {
+	Summary   string    `json:"summary"`
+	Date      string    `json:"date"`
+	Amount    float64   `json:"amount"`
+	Category  string    `json:"category,omitempty"`
+	Payees    []string  `json:"payees,omitempty"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// PeriodRecord is the most recent total computed for a pay period.
+type PeriodRecord struct {
+	Start      string             `json:"start"`
+	End        string             `json:"end"`
+	Total      float64            `json:"total"`
+	Categories map[string]float64 `json:"categories,omitempty"`
+	ComputedAt time.Time          `json:"computedAt"`
+}
+
+// History is the payment history kept in HISTORY_PATH, with payments keyed by event ID and periods
+// by their start date. Entries older than HISTORY_RETENTION_DAYS are dropped, so the file, which is
+// rewritten on every run, stays small however long the tracker runs.
+type History struct {
+	Payments  map[string]*PaymentRecord `json:"payments"`
+	Periods   map[string]*PeriodRecord  `json:"periods"`
+	Reminders map[string]time.Time      `json:"reminders"` // When each gift occasion was reminded of, by name and date
+}
+
+// loadHistory reads the history file, starting an empty history when it does not exist yet.
+func loadHistory(path string) (*History, error) {
+	history := &History{
+		Payments:  make(map[string]*PaymentRecord),
+		Periods:   make(map[string]*PeriodRecord),
+		Reminders: make(map[string]time.Time),
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open history file: %v", err)
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(history); err != nil {
+		return nil, fmt.Errorf("unable to read history file: %v", err)
+	}
+	return history, nil
+}
+
+// saveHistory writes the history to a temporary file and renames it into place, so an interrupted
+// write never leaves a truncated history behind.
+func saveHistory(history *History, path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".history-*.json")
+	if err != nil {
+		return fmt.Errorf("unable to create history file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	encoder := json.NewEncoder(tmp)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(history); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write history file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write history file: %v", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// recordHistory stores the payments and totals behind the desired events, logging payments that are
+// new or have changed since they were last seen. It returns the new ones, none when the history is
+// empty, as a first run sees every payment for the first time.
+func recordHistory(path string, desired []desiredEvent, config Config, now time.Time) ([]*calendar.Event, error) {
+	history, err := loadHistory(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var added []*calendar.Event
+	seeding := len(history.Payments) == 0
+	loc := now.Location()
+	for _, d := range desired {
+		period := d.Period
+		for _, item := range period.Payments {
+			amount, _ := parseAmountFromSummary(item.Summary, config.Currency)
+			date := eventStartDate(item, loc).Format("2006-01-02")
+			record, seen := history.Payments[item.Id]
+			switch {
+			case !seen:
+				slog.Info("New payment", "summary", item.Summary, "date", date)
+				record = &PaymentRecord{FirstSeen: now}
+				history.Payments[item.Id] = record
+				if !seeding {
+					added = append(added, item)
+				}
+			case math.Abs(record.Amount-amount) >= 0.005 || record.Date != date:
+				slog.Info("Payment changed", "summary", item.Summary, "old_amount", config.Currency.Format(record.Amount), "old_date", record.Date, "amount", config.Currency.Format(amount), "date", date)
+			}
+			record.Summary = item.Summary
+			record.Date = date
+			record.Amount = amount
+			record.Category = paymentCategory(item)
+			record.Payees = paymentPayees(item)
+			record.LastSeen = now
+		}
+
+		start := period.Start.Format("2006-01-02")
+		history.Periods[start] = &PeriodRecord{
+			Start:      start,
+			End:        period.End.Format("2006-01-02"),
+			Total:      period.Amount,
+			Categories: period.Categories,
+			ComputedAt: now,
+		}
+	}
+	if config.HistoryRetention > 0 {
+		pruneHistory(history, now.Add(-config.HistoryRetention))
+	}
+	return added, saveHistory(history, path)
+}
+
+// pruneHistory drops the payments last seen before cutoff, the periods that ended before it and the
+// gift reminders sent before it.
+func pruneHistory(history *History, cutoff time.Time) {
+	for id, record := range history.Payments {
+		if record.LastSeen.Before(cutoff) {
+			delete(history.Payments, id)
+		}
+	}
+	for key, remindedAt := range history.Reminders {
+		if remindedAt.Before(cutoff) {
+			delete(history.Reminders, key)
+		}
+	}
+	day := cutoff.Format("2006-01-02")
+	for start, record := range history.Periods {
+		if record.End < day {
+			delete(history.Periods, start)
+		}
+	}
+}
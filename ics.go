@@ -0,0 +1,504 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// icsHorizonYears bounds the expansion of recurring events in queries without an end, which list
+// their occurrences up to this many years ahead.
+const icsHorizonYears = 2
+
+// maxOccurrences caps how many occurrences of a single recurring event are generated.
+const maxOccurrences = 10000
+
+// icsFileLock serialises reading and rewriting .ics files, which runs in the background share.
+var icsFileLock sync.Mutex
+
+// isICSFile reports whether a calendar ID is the path of a local .ics file rather than a calendar
+// of the calendar service.
+func isICSFile(calendarID string) bool {
+	return strings.HasSuffix(strings.ToLower(calendarID), ".ics")
+}
+
+// usesICSFiles reports whether payments are read from or the tracker's events written to a local
+// .ics file.
+func usesICSFiles(config Config) bool {
+	if isICSFile(config.TargetCalendar) {
+		return true
+	}
+	for _, calendarID := range config.PaymentCalendars {
+		if isICSFile(calendarID) {
+			return true
+		}
+	}
+	return false
+}
+
+// icsRouter sends calendar operations on .ics files to files and the rest to live, the calendar
+// service, which is nil with PROVIDER=ics.
+type icsRouter struct {
+	live  CalendarProvider
+	files icsFiles
+}
+
+// route returns the provider holding a calendar.
+func (r *icsRouter) route(calendarID string) (CalendarProvider, error) {
+	if isICSFile(calendarID) {
+		return r.files, nil
+	}
+	if r.live == nil {
+		return nil, fmt.Errorf("PROVIDER=ics only reads and writes .ics files, %q is not one", calendarID)
+	}
+	return r.live, nil
+}
+
+func (r *icsRouter) ListEvents(ctx context.Context, calendarID string, query EventQuery) ([]*calendar.Event, error) {
+	p, err := r.route(calendarID)
+	if err != nil {
+		return nil, err
+	}
+	return p.ListEvents(ctx, calendarID, query)
+}
+
+func (r *icsRouter) GetEvent(ctx context.Context, calendarID, eventID string) (*calendar.Event, error) {
+	p, err := r.route(calendarID)
+	if err != nil {
+		return nil, err
+	}
+	return p.GetEvent(ctx, calendarID, eventID)
+}
+
+func (r *icsRouter) InsertEvent(ctx context.Context, calendarID string, event *calendar.Event) (*calendar.Event, error) {
+	p, err := r.route(calendarID)
+	if err != nil {
+		return nil, err
+	}
+	return p.InsertEvent(ctx, calendarID, event)
+}
+
+func (r *icsRouter) PatchEvent(ctx context.Context, calendarID, eventID, etag string, event *calendar.Event) error {
+	p, err := r.route(calendarID)
+	if err != nil {
+		return err
+	}
+	return p.PatchEvent(ctx, calendarID, eventID, etag, event)
+}
+
+func (r *icsRouter) UpdateEvent(ctx context.Context, calendarID, eventID, etag string, event *calendar.Event) error {
+	p, err := r.route(calendarID)
+	if err != nil {
+		return err
+	}
+	return p.UpdateEvent(ctx, calendarID, eventID, etag, event)
+}
+
+func (r *icsRouter) DeleteEvent(ctx context.Context, calendarID, eventID, etag string) error {
+	p, err := r.route(calendarID)
+	if err != nil {
+		return err
+	}
+	return p.DeleteEvent(ctx, calendarID, eventID, etag)
+}
+
+// ListCalendars lists the calendars of the calendar service. Files are not listed, as they are
+// named by their path.
+func (r *icsRouter) ListCalendars(ctx context.Context) ([]*calendar.CalendarListEntry, error) {
+	if r.live == nil {
+		return nil, nil
+	}
+	return r.live.ListCalendars(ctx)
+}
+
+// CreateCalendar creates a calendar with the calendar service, or a temporary file without one.
+func (r *icsRouter) CreateCalendar(ctx context.Context, name, timeZone string) (string, error) {
+	if r.live == nil {
+		return r.files.CreateCalendar(ctx, name, timeZone)
+	}
+	return r.live.CreateCalendar(ctx, name, timeZone)
+}
+
+func (r *icsRouter) DeleteCalendar(ctx context.Context, calendarID string) error {
+	p, err := r.route(calendarID)
+	if err != nil {
+		return err
+	}
+	return p.DeleteCalendar(ctx, calendarID)
+}
+
+// icsFiles keeps events in local .ics files, the calendar ID being the file's path. Events are
+// identified by their UID, and occurrences of recurring events by the UID and their start as with
+// CalDAV. Files have no ETags, so writes always go ahead. A file that does not exist yet reads as an
+// empty calendar and is created by the first write.
+type icsFiles struct{}
+
+func (f icsFiles) ListEvents(ctx context.Context, path string, query EventQuery) ([]*calendar.Event, error) {
+	zone := query.TimeZone
+	if zone == "" {
+		zone = "UTC"
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load time zone '%s': %v", zone, err)
+	}
+	cal, err := readICSFile(path)
+	if err != nil {
+		return nil, err
+	}
+	timeMax := query.TimeMax
+	if timeMax.IsZero() {
+		timeMax = time.Now().AddDate(icsHorizonYears, 0, 0)
+	}
+
+	var items []*calendar.Event
+	for _, uid := range eventUIDs(cal) {
+		if query.ICalUID != "" && uid != query.ICalUID {
+			continue
+		}
+		for _, item := range expandEvent(uid, eventResource(cal, uid), loc, timeMax) {
+			start, end := eventBounds(item, loc)
+			switch {
+			case !query.TimeMin.IsZero() && !end.After(query.TimeMin):
+			case !query.TimeMax.IsZero() && !start.Before(query.TimeMax):
+			case matchesQuery(item, query):
+				items = append(items, item)
+			}
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return eventStartDate(items[i], loc).Before(eventStartDate(items[j], loc))
+	})
+	return items, nil
+}
+
+func (f icsFiles) GetEvent(ctx context.Context, path, eventID string) (*calendar.Event, error) {
+	cal, err := readICSFile(path)
+	if err != nil {
+		return nil, err
+	}
+	uid, _ := splitInstanceID(eventID)
+	return resourceEvent(eventResource(cal, uid), eventID, "", "")
+}
+
+func (f icsFiles) InsertEvent(ctx context.Context, path string, event *calendar.Event) (*calendar.Event, error) {
+	icsFileLock.Lock()
+	defer icsFileLock.Unlock()
+	cal, err := readICSFile(path)
+	if err != nil {
+		return nil, err
+	}
+	uid := event.Id
+	if uid == "" {
+		uid = randomName()
+	}
+	if len(eventResource(cal, uid).Components) > 0 {
+		return nil, &googleapi.Error{Code: http.StatusConflict, Message: fmt.Sprintf("event %s already exists in %s", uid, path)}
+	}
+	cal.Components = append(cal.Components, newVEvent(uid, event))
+	if err := writeICSFile(path, cal); err != nil {
+		return nil, err
+	}
+	created := *event
+	created.Id = uid
+	created.ICalUID = uid
+	return &created, nil
+}
+
+func (f icsFiles) PatchEvent(ctx context.Context, path, eventID, etag string, event *calendar.Event) error {
+	return f.modify(path, eventID, func(cal, vevent *icalComponent) {
+		applyEvent(vevent, event, false)
+	})
+}
+
+func (f icsFiles) UpdateEvent(ctx context.Context, path, eventID, etag string, event *calendar.Event) error {
+	return f.modify(path, eventID, func(cal, vevent *icalComponent) {
+		applyEvent(vevent, event, true)
+	})
+}
+
+func (f icsFiles) DeleteEvent(ctx context.Context, path, eventID, etag string) error {
+	uid, instance := splitInstanceID(eventID)
+	if instance != "" {
+		return f.modify(path, uid, excludeOccurrence(instance))
+	}
+	icsFileLock.Lock()
+	defer icsFileLock.Unlock()
+	cal, err := readICSFile(path)
+	if err != nil {
+		return err
+	}
+	if len(eventResource(cal, uid).Components) == 0 {
+		return &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("no event %s in %s", uid, path)}
+	}
+	replaceResource(cal, uid, &icalComponent{})
+	return writeICSFile(path, cal)
+}
+
+func (f icsFiles) ListCalendars(ctx context.Context) ([]*calendar.CalendarListEntry, error) {
+	return nil, nil
+}
+
+// CreateCalendar creates an empty calendar file in the temporary directory.
+func (f icsFiles) CreateCalendar(ctx context.Context, name, timeZone string) (string, error) {
+	tmp, err := os.CreateTemp("", "paymenttracker-*.ics")
+	if err != nil {
+		return "", fmt.Errorf("unable to create calendar file: %v", err)
+	}
+	tmp.Close()
+	cal := newVCalendar()
+	cal.setText("X-WR-CALNAME", name)
+	if err := writeICSFile(tmp.Name(), cal); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func (f icsFiles) DeleteCalendar(ctx context.Context, path string) error {
+	return os.Remove(path)
+}
+
+// modify rewrites the file holding an event with changeComponent applied to it.
+func (f icsFiles) modify(path, eventID string, change func(cal, vevent *icalComponent)) error {
+	icsFileLock.Lock()
+	defer icsFileLock.Unlock()
+	cal, err := readICSFile(path)
+	if err != nil {
+		return err
+	}
+	uid, instance := splitInstanceID(eventID)
+	resource := eventResource(cal, uid)
+	if err := changeComponent(resource, instance, change); err != nil {
+		return err
+	}
+	replaceResource(cal, uid, resource)
+	return writeICSFile(path, cal)
+}
+
+// eventUIDs returns the UIDs of the events in a calendar, in the order they first appear.
+func eventUIDs(cal *icalComponent) []string {
+	var uids []string
+	seen := make(map[string]bool)
+	for _, vevent := range cal.components("VEVENT") {
+		if uid := vevent.value("UID"); !seen[uid] {
+			seen[uid] = true
+			uids = append(uids, uid)
+		}
+	}
+	return uids
+}
+
+// eventResource returns the VEVENTs of a file with the given UID, the recurring event and the
+// occurrences overriding it, as a calendar of their own the way CalDAV stores them.
+func eventResource(cal *icalComponent, uid string) *icalComponent {
+	resource := &icalComponent{Name: "VCALENDAR"}
+	for _, vevent := range cal.components("VEVENT") {
+		if vevent.value("UID") == uid {
+			resource.Components = append(resource.Components, vevent)
+		}
+	}
+	return resource
+}
+
+// replaceResource puts the VEVENTs of an event back into the file it was taken from, where the
+// event's first VEVENT was, or at the end for a new event.
+func replaceResource(cal *icalComponent, uid string, resource *icalComponent) {
+	var components []*icalComponent
+	placed := false
+	for _, component := range cal.Components {
+		if component.Name != "VEVENT" || component.value("UID") != uid {
+			components = append(components, component)
+			continue
+		}
+		if !placed {
+			components = append(components, resource.Components...)
+			placed = true
+		}
+	}
+	if !placed {
+		components = append(components, resource.Components...)
+	}
+	cal.Components = components
+}
+
+// expandEvent converts the VEVENTs of an event to the tracker's representation with times in loc,
+// expanding a recurring event into its occurrences before timeMax. Occurrences with changes of
+// their own are taken from the VEVENTs overriding them.
+func expandEvent(uid string, resource *icalComponent, loc *time.Location, timeMax time.Time) []*calendar.Event {
+	var items []*calendar.Event
+	master, _ := findComponent(resource, "")
+	if master == nil || master.prop("RRULE") == nil || master.prop("DTSTART") == nil {
+		for _, vevent := range resource.Components {
+			items = append(items, veventToEvent(uid, "", vevent, loc, ""))
+		}
+		return items
+	}
+	dtstart := master.prop("DTSTART")
+
+	overrides := make(map[string]*icalComponent)
+	for _, vevent := range resource.Components {
+		if rid := vevent.prop("RECURRENCE-ID"); rid != nil {
+			overrides[normalizeICalTime(rid)] = vevent
+		}
+	}
+	start, _ := parseICalTime(dtstart, loc)
+	for _, t := range recurrenceStarts(master, start, timeMax) {
+		instance := icalTime(t)
+		if isICalDate(dtstart) {
+			instance = t.Format("20060102")
+		}
+		if override, ok := overrides[instance]; ok {
+			items = append(items, veventToEvent(uid, "", override, loc, ""))
+			delete(overrides, instance)
+			continue
+		}
+		item := veventToEvent(uid, "", master, loc, "")
+		moveToOccurrence(item, uid+"_"+instance, t, dtstart, loc)
+		items = append(items, item)
+	}
+	// Occurrences moved away from where the rule puts them are listed wherever they now are
+	for _, vevent := range resource.Components {
+		if rid := vevent.prop("RECURRENCE-ID"); rid != nil && overrides[normalizeICalTime(rid)] == vevent {
+			items = append(items, veventToEvent(uid, "", vevent, loc, ""))
+		}
+	}
+	return items
+}
+
+// recurrenceStarts returns the starts of a recurring event's occurrences before timeMax, leaving out
+// those its EXDATE properties exclude. The FREQ, INTERVAL, COUNT and UNTIL parts of the RRULE are
+// followed; finer rules such as BYDAY are not, so those events recur on the weekday or day of the
+// month they start on.
+func recurrenceStarts(master *icalComponent, start, timeMax time.Time) []time.Time {
+	rule := make(map[string]string)
+	for _, part := range strings.Split(master.value("RRULE"), ";") {
+		if key, value, ok := strings.Cut(part, "="); ok {
+			rule[strings.ToUpper(key)] = value
+		}
+	}
+	interval, err := strconv.Atoi(rule["INTERVAL"])
+	if err != nil || interval < 1 {
+		interval = 1
+	}
+	count, _ := strconv.Atoi(rule["COUNT"])
+	// UNTIL is inclusive, a date counting as the whole day
+	var until time.Time
+	if value := rule["UNTIL"]; value != "" {
+		p := icalProp{Name: "UNTIL", Value: value}
+		if t, ok := parseICalTime(&p, start.Location()); ok {
+			until = t.Add(time.Second)
+			if isICalDate(&p) {
+				until = t.AddDate(0, 0, 1)
+			}
+		}
+	}
+	excluded := make(map[int64]bool)
+	for _, p := range master.Props {
+		if p.Name != "EXDATE" {
+			continue
+		}
+		for _, value := range strings.Split(p.Value, ",") {
+			p.Value = value
+			if t, ok := parseICalTime(&p, start.Location()); ok {
+				excluded[t.Unix()] = true
+			}
+		}
+	}
+
+	var starts []time.Time
+	for i, n := 0, 0; i < maxOccurrences; i++ {
+		t := start
+		switch strings.ToUpper(rule["FREQ"]) {
+		case "DAILY":
+			t = start.AddDate(0, 0, i*interval)
+		case "WEEKLY":
+			t = start.AddDate(0, 0, 7*i*interval)
+		case "MONTHLY", "YEARLY":
+			months := i * interval
+			if strings.EqualFold(rule["FREQ"], "YEARLY") {
+				months *= 12
+			}
+			year, month := addMonths(start.Year(), start.Month(), months)
+			if start.Day() > daysInMonth(year, month) {
+				continue // Such as the 31st in a shorter month, which has no occurrence
+			}
+			t = time.Date(year, month, start.Day(), start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+		default:
+			if i > 0 {
+				return starts
+			}
+		}
+		if !t.Before(timeMax) || (!until.IsZero() && !t.Before(until)) || (count > 0 && n >= count) {
+			break
+		}
+		n++
+		if !excluded[t.Unix()] {
+			starts = append(starts, t)
+		}
+	}
+	return starts
+}
+
+// readICSFile reads a calendar file, an empty calendar when it does not exist yet.
+func readICSFile(path string) (*icalComponent, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return newVCalendar(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read calendar file: %v", err)
+	}
+	cal, err := parseICal(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read calendar file %s: %v", path, err)
+	}
+	return cal, nil
+}
+
+// writeICSFile writes a calendar to a temporary file and renames it into place, so calendar apps
+// subscribed to the file never see it half written.
+func writeICSFile(path string, cal *icalComponent) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".calendar-*.ics")
+	if err != nil {
+		return fmt.Errorf("unable to create calendar file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	// Readable by whatever serves the file to subscribers
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write calendar file: %v", err)
+	}
+	if _, err := tmp.WriteString(cal.encode()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write calendar file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write calendar file: %v", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeICSFeed writes the tracker's events to an .ics file for calendar apps to subscribe to, in
+// addition to the target calendar. The file is rewritten as a whole each run, with every event
+// keeping its UID so subscribers update it in place.
+func writeICSFeed(path string, desired []desiredEvent, config Config) error {
+	cal := newVCalendar()
+	cal.setText("X-WR-CALNAME", "Payment Tracker")
+	cal.setText("X-WR-TIMEZONE", config.TimeZone)
+	for _, d := range desired {
+		cal.Components = append(cal.Components, newVEvent(idempotencyKey(d.Event, config), d.Event))
+	}
+	return writeICSFile(path, cal)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Sources of income for the net remaining calculation, set with INCOME_SOURCE.
+const (
+	incomeFromCalendar = "calendar" // Events tagged "Income" or "Salary" on the payment calendars
+	incomeFixed        = "fixed"    // MONTHLY_INCOME every month
+)
+
+// tracksIncome reports whether period totals need their income and payments made so far, for the
+// net remaining amount, the payday summary or the budget limit.
+func tracksIncome(config Config) bool {
+	return config.IncomeSource != "" || config.PaydaySummary || config.BudgetLimit > 0
+}
+
+// addIncome records the income of a pay period and the payments already made in it, so that
+// PeriodTotal.Remaining can report what is left of the income once every bill is paid.
+func addIncome(ctx context.Context, srv CalendarProvider, total *PeriodTotal, config Config, now time.Time) error {
+	income, err := periodIncome(ctx, srv, total.Start, total.End, config)
+	if err != nil {
+		return err
+	}
+	total.Income = income
+
+	// Payments made earlier in the period are spent too, though the total only counts upcoming ones,
+	// as are upcoming payments already marked as paid
+	total.Paid = 0
+	for _, item := range total.Events {
+		if start, _ := eventBounds(item, now.Location()); !start.Before(now) && isPaid(item, config) {
+			if amount, ok := localAmount(item, config); ok {
+				total.Paid += amount
+			}
+		}
+	}
+	if now.After(total.Start) {
+		paidUntil := now
+		if paidUntil.After(total.End) {
+			paidUntil = total.End
+		}
+		events, err := listPaymentEvents(ctx, srv, total.Start, paidUntil, config)
+		if err != nil {
+			return err
+		}
+		for _, item := range events {
+			if start, _ := eventBounds(item, now.Location()); start.Before(paidUntil) {
+				if amount, ok := localAmount(item, config); ok {
+					total.Paid += amount
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// periodIncome returns the income for a pay period: the amounts of the income events in it, or
+// MONTHLY_INCOME, pro rata for pay periods that are not monthly. Income events are also used when
+// only the payday summary needs the income.
+func periodIncome(ctx context.Context, srv CalendarProvider, startDate, endDate time.Time, config Config) (float64, error) {
+	if config.IncomeSource == incomeFixed {
+		if _, monthly := config.Periods.(monthlyPeriods); monthly {
+			return config.MonthlyIncome, nil
+		}
+		days := endDate.Sub(startDate).Hours() / 24
+		return config.MonthlyIncome * days * 12 / 365.25, nil
+	}
+
+	var income float64
+	seen := make(map[string]bool)
+	for _, query := range []string{"Income", "Salary"} {
+		events, err := listCalendarEvents(ctx, srv, startDate, endDate, query, config)
+		if err != nil {
+			return 0, err
+		}
+		for _, item := range events {
+			if seen[item.Id] {
+				continue
+			}
+			seen[item.Id] = true
+			if amount, ok := localAmount(item, config); ok {
+				income += amount
+			}
+		}
+	}
+	return income, nil
+}
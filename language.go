@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// eventLanguage is the wording of generated events in one EVENT_LANGUAGE: its phrases, keyed by the
+// English phrase or format string they translate, and its abbreviated month and weekday names.
+type eventLanguage struct {
+	phrases  map[string]string
+	months   [12]string
+	weekdays [7]string // Sunday first, as time.Weekday counts
+}
+
+// eventLanguages holds the translations of generated event text for each supported EVENT_LANGUAGE.
+// English is the text as written in the code.
+var eventLanguages = map[string]eventLanguage{
+	"ur": {
+		phrases: map[string]string{
+			"Total Remaining":          "کل باقی رقم",
+			"Pay period %s – %s":       "تنخواہ کی مدت %s – %s",
+			"Pay period %s: %s – %s":   "تنخواہ کی مدت %s: %s – %s",
+			"Income %s":                "آمدنی %s",
+			"Payments %s":              "ادائیگیاں %s",
+			"Bills scheduled %s":       "طے شدہ بل %s",
+			"Planned savings %s":       "منصوبہ بند بچت %s",
+			"Safe to spend %s":         "خرچ کے لیے دستیاب %s",
+			"Payday: safe to spend %s": "تنخواہ کا دن: خرچ کے لیے دستیاب %s",
+			"Due today %s":             "آج واجب الادا %s",
+			"Remaining this week %s":   "اس ہفتے باقی %s",
+			"Bills %s of %s":           "بل %s، بجٹ %s",
+			"#%s %s of %s":             "#%s %s، بجٹ %s",
+			"Over budget by %s":        "بجٹ سے %s زیادہ",
+			"Other %s":                 "دیگر %s",
+		},
+		months:   [12]string{"جنوری", "فروری", "مارچ", "اپریل", "مئی", "جون", "جولائی", "اگست", "ستمبر", "اکتوبر", "نومبر", "دسمبر"},
+		weekdays: [7]string{"اتوار", "پیر", "منگل", "بدھ", "جمعرات", "جمعہ", "ہفتہ"},
+	},
+	"de": {
+		phrases: map[string]string{
+			"Total Remaining":          "Restbetrag",
+			"Pay period %s – %s":       "Zahlungszeitraum %s – %s",
+			"Pay period %s: %s – %s":   "Zahlungszeitraum %s: %s – %s",
+			"Income %s":                "Einkommen %s",
+			"Payments %s":              "Zahlungen %s",
+			"Bills scheduled %s":       "Geplante Rechnungen %s",
+			"Planned savings %s":       "Geplantes Sparen %s",
+			"Safe to spend %s":         "Frei verfügbar %s",
+			"Payday: safe to spend %s": "Zahltag: frei verfügbar %s",
+			"Due today %s":             "Heute fällig %s",
+			"Remaining this week %s":   "Diese Woche offen %s",
+			"Bills %s of %s":           "Rechnungen %s von %s",
+			"#%s %s of %s":             "#%s %s von %s",
+			"Over budget by %s":        "Budget überschritten um %s",
+			"Other %s":                 "Sonstiges %s",
+		},
+		months:   [12]string{"Jan", "Feb", "Mär", "Apr", "Mai", "Jun", "Jul", "Aug", "Sep", "Okt", "Nov", "Dez"},
+		weekdays: [7]string{"So", "Mo", "Di", "Mi", "Do", "Fr", "Sa"},
+	},
+	"fr": {
+		phrases: map[string]string{
+			"Total Remaining":          "Reste à payer",
+			"Pay period %s – %s":       "Période de paie %s – %s",
+			"Pay period %s: %s – %s":   "Période de paie %s : %s – %s",
+			"Income %s":                "Revenus %s",
+			"Payments %s":              "Paiements %s",
+			"Bills scheduled %s":       "Factures prévues %s",
+			"Planned savings %s":       "Épargne prévue %s",
+			"Safe to spend %s":         "Disponible %s",
+			"Payday: safe to spend %s": "Jour de paie : disponible %s",
+			"Due today %s":             "À payer aujourd'hui %s",
+			"Remaining this week %s":   "Reste cette semaine %s",
+			"Bills %s of %s":           "Factures %s sur %s",
+			"#%s %s of %s":             "#%s %s sur %s",
+			"Over budget by %s":        "Budget dépassé de %s",
+			"Other %s":                 "Autres %s",
+		},
+		months:   [12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+		weekdays: [7]string{"dim.", "lun.", "mar.", "mer.", "jeu.", "ven.", "sam."},
+	},
+	"es": {
+		phrases: map[string]string{
+			"Total Remaining":          "Total restante",
+			"Pay period %s – %s":       "Periodo de pago %s – %s",
+			"Pay period %s: %s – %s":   "Periodo de pago %s: %s – %s",
+			"Income %s":                "Ingresos %s",
+			"Payments %s":              "Pagos %s",
+			"Bills scheduled %s":       "Facturas previstas %s",
+			"Planned savings %s":       "Ahorro previsto %s",
+			"Safe to spend %s":         "Disponible para gastar %s",
+			"Payday: safe to spend %s": "Día de pago: disponible para gastar %s",
+			"Due today %s":             "Vence hoy %s",
+			"Remaining this week %s":   "Pendiente esta semana %s",
+			"Bills %s of %s":           "Facturas %s de %s",
+			"#%s %s of %s":             "#%s %s de %s",
+			"Over budget by %s":        "Presupuesto excedido en %s",
+			"Other %s":                 "Otros %s",
+		},
+		months:   [12]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"},
+		weekdays: [7]string{"dom", "lun", "mar", "mié", "jue", "vie", "sáb"},
+	},
+	"it": {
+		phrases: map[string]string{
+			"Total Remaining":          "Totale rimanente",
+			"Pay period %s – %s":       "Periodo di paga %s – %s",
+			"Pay period %s: %s – %s":   "Periodo di paga %s: %s – %s",
+			"Income %s":                "Entrate %s",
+			"Payments %s":              "Pagamenti %s",
+			"Bills scheduled %s":       "Bollette previste %s",
+			"Planned savings %s":       "Risparmi previsti %s",
+			"Safe to spend %s":         "Spendibile %s",
+			"Payday: safe to spend %s": "Giorno di paga: spendibile %s",
+			"Due today %s":             "In scadenza oggi %s",
+			"Remaining this week %s":   "Rimanente questa settimana %s",
+			"Bills %s of %s":           "Bollette %s su %s",
+			"#%s %s of %s":             "#%s %s su %s",
+			"Over budget by %s":        "Budget superato di %s",
+			"Other %s":                 "Altro %s",
+		},
+		months:   [12]string{"gen", "feb", "mar", "apr", "mag", "giu", "lug", "ago", "set", "ott", "nov", "dic"},
+		weekdays: [7]string{"dom", "lun", "mar", "mer", "gio", "ven", "sab"},
+	},
+	"pt": {
+		phrases: map[string]string{
+			"Total Remaining":          "Total restante",
+			"Pay period %s – %s":       "Período de pagamento %s – %s",
+			"Pay period %s: %s – %s":   "Período de pagamento %s: %s – %s",
+			"Income %s":                "Rendimentos %s",
+			"Payments %s":              "Pagamentos %s",
+			"Bills scheduled %s":       "Contas previstas %s",
+			"Planned savings %s":       "Poupança prevista %s",
+			"Safe to spend %s":         "Disponível para gastar %s",
+			"Payday: safe to spend %s": "Dia de pagamento: disponível para gastar %s",
+			"Due today %s":             "Vence hoje %s",
+			"Remaining this week %s":   "Restante esta semana %s",
+			"Bills %s of %s":           "Contas %s de %s",
+			"#%s %s of %s":             "#%s %s de %s",
+			"Over budget by %s":        "Orçamento excedido em %s",
+			"Other %s":                 "Outros %s",
+		},
+		months:   [12]string{"jan", "fev", "mar", "abr", "mai", "jun", "jul", "ago", "set", "out", "nov", "dez"},
+		weekdays: [7]string{"dom", "seg", "ter", "qua", "qui", "sex", "sáb"},
+	},
+	"pl": {
+		phrases: map[string]string{
+			"Total Remaining":          "Pozostało do zapłaty",
+			"Pay period %s – %s":       "Okres rozliczeniowy %s – %s",
+			"Pay period %s: %s – %s":   "Okres rozliczeniowy %s: %s – %s",
+			"Income %s":                "Dochód %s",
+			"Payments %s":              "Płatności %s",
+			"Bills scheduled %s":       "Zaplanowane rachunki %s",
+			"Planned savings %s":       "Planowane oszczędności %s",
+			"Safe to spend %s":         "Do wydania %s",
+			"Payday: safe to spend %s": "Wypłata: do wydania %s",
+			"Due today %s":             "Do zapłaty dziś %s",
+			"Remaining this week %s":   "Pozostało w tym tygodniu %s",
+			"Bills %s of %s":           "Rachunki %s z %s",
+			"#%s %s of %s":             "#%s %s z %s",
+			"Over budget by %s":        "Przekroczono budżet o %s",
+			"Other %s":                 "Inne %s",
+		},
+		months:   [12]string{"sty", "lut", "mar", "kwi", "maj", "cze", "lip", "sie", "wrz", "paź", "lis", "gru"},
+		weekdays: [7]string{"nd", "pn", "wt", "śr", "cz", "pt", "sb"},
+	},
+}
+
+// getEventLanguage returns the supported EVENT_LANGUAGE generated events are written in, falling
+// back to English.
+func getEventLanguage(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if _, ok := eventLanguages[language]; ok {
+		return language
+	}
+	if language != "" && language != "en" {
+		slog.Warn("No event translation for EVENT_LANGUAGE, using English", "language", language)
+	}
+	return ""
+}
+
+// getEventLabel returns the label used in generated event titles. EVENT_LABEL overrides the
+// translation entirely; otherwise it is "Total Remaining" in the event language.
+func getEventLabel(language, custom string) string {
+	if custom != "" {
+		return custom
+	}
+	return eventText(language, "Total Remaining")
+}
+
+// eventText returns an English phrase or format string of generated event text in the event
+// language, or as it is when the language has no translation for it.
+func eventText(language, english string) string {
+	if phrase, ok := eventLanguages[language].phrases[english]; ok {
+		return phrase
+	}
+	return english
+}
+
+// eventDate formats a date for generated event text in the event language, e.g. "2 Jan", or with
+// the weekday "Mon 2 Jan".
+func eventDate(language string, t time.Time, weekday bool) string {
+	names, ok := eventLanguages[language]
+	if !ok {
+		if weekday {
+			return t.Format("Mon 2 Jan")
+		}
+		return t.Format("2 Jan")
+	}
+	date := fmt.Sprintf("%d %s", t.Day(), names.months[t.Month()-1])
+	if weekday {
+		date = names.weekdays[t.Weekday()] + " " + date
+	}
+	return date
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// setupLogging installs the default logger: LOG_LEVEL picks the lowest level logged, debug, info, warn
+// or error, info by default, and LOG_FORMAT picks text or json lines, text by default. Lines written
+// through the standard log package, such as those of libraries, go through it too.
+func setupLogging() {
+	level := slog.LevelInfo
+	levelStr := os.Getenv("LOG_LEVEL")
+	var levelErr error
+	if levelStr != "" {
+		if levelErr = level.UnmarshalText([]byte(levelStr)); levelErr != nil {
+			level = slog.LevelInfo
+		}
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	formatStr := strings.ToLower(os.Getenv("LOG_FORMAT"))
+	var handler slog.Handler
+	if formatStr == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(handler))
+
+	if levelErr != nil {
+		slog.Warn("Invalid LOG_LEVEL value, expected debug, info, warn or error, using info", "value", levelStr)
+	}
+	if formatStr != "" && formatStr != "json" && formatStr != "text" {
+		slog.Warn("Invalid LOG_FORMAT value, expected text or json, using text", "value", formatStr)
+	}
+}
+
+// countingProvider counts the calendar operations made through it and the events they return, for
+// the summary logged after each sync.
+type countingProvider struct {
+	CalendarProvider
+	calls  atomic.Int32
+	events atomic.Int32
+}
+
+func (p *countingProvider) ListEvents(ctx context.Context, calendarID string, query EventQuery) ([]*calendar.Event, error) {
+	p.calls.Add(1)
+	events, err := p.CalendarProvider.ListEvents(ctx, calendarID, query)
+	p.events.Add(int32(len(events)))
+	slog.Debug("Listed events", "calendar", calendarID, "query", query.Text, "events", len(events))
+	return events, err
+}
+
+func (p *countingProvider) GetEvent(ctx context.Context, calendarID, eventID string) (*calendar.Event, error) {
+	p.calls.Add(1)
+	return p.CalendarProvider.GetEvent(ctx, calendarID, eventID)
+}
+
+func (p *countingProvider) InsertEvent(ctx context.Context, calendarID string, event *calendar.Event) (*calendar.Event, error) {
+	p.calls.Add(1)
+	return p.CalendarProvider.InsertEvent(ctx, calendarID, event)
+}
+
+func (p *countingProvider) PatchEvent(ctx context.Context, calendarID, eventID, etag string, event *calendar.Event) error {
+	p.calls.Add(1)
+	return p.CalendarProvider.PatchEvent(ctx, calendarID, eventID, etag, event)
+}
+
+func (p *countingProvider) UpdateEvent(ctx context.Context, calendarID, eventID, etag string, event *calendar.Event) error {
+	p.calls.Add(1)
+	return p.CalendarProvider.UpdateEvent(ctx, calendarID, eventID, etag, event)
+}
+
+func (p *countingProvider) DeleteEvent(ctx context.Context, calendarID, eventID, etag string) error {
+	p.calls.Add(1)
+	return p.CalendarProvider.DeleteEvent(ctx, calendarID, eventID, etag)
+}
+
+func (p *countingProvider) ListCalendars(ctx context.Context) ([]*calendar.CalendarListEntry, error) {
+	p.calls.Add(1)
+	return p.CalendarProvider.ListCalendars(ctx)
+}
+
+func (p *countingProvider) CreateCalendar(ctx context.Context, name, timeZone string) (string, error) {
+	p.calls.Add(1)
+	return p.CalendarProvider.CreateCalendar(ctx, name, timeZone)
+}
+
+func (p *countingProvider) DeleteCalendar(ctx context.Context, calendarID string) error {
+	p.calls.Add(1)
+	return p.CalendarProvider.DeleteCalendar(ctx, calendarID)
+}
@@ -3,15 +3,18 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
+	_ "time/tzdata" // Embed the zoneinfo database for minimal container images
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
@@ -19,22 +22,81 @@ import (
 	"google.golang.org/api/option"
 )
 
-func loadCredentials() (*oauth2.Config, error) {
+func loadCredentials(scopes []string) (*oauth2.Config, error) {
 	credentialsPath := getCredentialsPath()
 	b, err := ioutil.ReadFile(credentialsPath)
 	if err != nil {
 		return nil, fmt.Errorf("unable to read client secret file: %v", err)
 	}
-	return google.ConfigFromJSON(b, calendar.CalendarScope)
+	return google.ConfigFromJSON(b, scopes...)
 }
 
-func initializeCalendarService() (*calendar.Service, error) {
-	oauth2Config, err := loadOAuth2Config()
+// initializeCalendarService connects to the calendar service chosen with PROVIDER, and to each
+// account in GOOGLE_ACCOUNTS. Calendars named by the path of an .ics file are read and written
+// locally instead, and with PROVIDER=ics all of them are.
+func initializeCalendarService(ctx context.Context, monitor *quotaMonitor, config Config) (CalendarProvider, error) {
+	if config.Provider == providerICS {
+		return &icsRouter{}, nil
+	}
+	srv, err := connectCalendarService(ctx, monitor, config, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Accounts) > 0 {
+		router := &accountRouter{accounts: map[string]CalendarProvider{"": srv}}
+		for account := range config.Accounts {
+			if router.accounts[account], err = connectCalendarService(ctx, monitor, config, account); err != nil {
+				return nil, fmt.Errorf("error connecting to account %s: %v", account, err)
+			}
+		}
+		srv = router
+	}
+	if !usesICSFiles(config) {
+		return srv, nil
+	}
+	return &icsRouter{live: srv}, nil
+}
+
+// connectCalendarService connects to the calendar service chosen with PROVIDER as the given account,
+// the main one for "".
+func connectCalendarService(ctx context.Context, monitor *quotaMonitor, config Config, account string) (CalendarProvider, error) {
+	client, err := getCalendarClient(ctx, config, account)
+	if err != nil {
+		return nil, err
+	}
+	if monitor != nil {
+		client.Transport = monitor.wrap(client.Transport)
+	}
+	apiLimiter.setRate(config.APIRateLimit)
+	client.Transport = retrying(client.Transport)
+	switch config.Provider {
+	case providerOutlook:
+		return &graphProvider{client: client}, nil
+	case providerCaldav:
+		return newCaldavProvider(client)
+	}
+	service, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	return &googleProvider{service: service, sendUpdates: config.SendUpdates, pageSize: config.PageSize}, nil
+}
+
+// getCalendarClient returns the HTTP client for the calendar service, signed in with OAuth except
+// for CalDAV servers, which take a user name and password.
+func getCalendarClient(ctx context.Context, config Config, account string) (*http.Client, error) {
+	if config.Provider == providerCaldav {
+		return caldavClient()
+	}
+	oauth2Config, err := loadOAuth2Config(config.OAuthScopes)
 	if err != nil {
 		return nil, fmt.Errorf("error loading OAuth2 configuration: %v", err)
 	}
-	client := getClient(oauth2Config)
-	return calendar.NewService(context.Background(), option.WithHTTPClient(client))
+	client, err := getClient(ctx, oauth2Config, accountTokenFile(config, account))
+	if isTokenRevoked(err) {
+		err = fmt.Errorf("%v. %s", err, reauthHint(account))
+	}
+	return client, err
 }
 
 func getCredentialsPath() string {
@@ -44,26 +106,60 @@ func getCredentialsPath() string {
 	return "credentials/credentials.json"
 }
 
-func loadOAuth2Config() (*oauth2.Config, error) {
-	return loadCredentials()
+// loadOAuth2Config returns the OAuth configuration of the calendar service, asking Google for the
+// given scopes.
+func loadOAuth2Config(scopes []string) (*oauth2.Config, error) {
+	switch getProvider() {
+	case providerOutlook:
+		return loadOutlookOAuth2Config()
+	case providerCaldav:
+		return nil, fmt.Errorf("PROVIDER=caldav signs in with CALDAV_USERNAME and CALDAV_PASSWORD, not OAuth")
+	case providerICS:
+		return nil, fmt.Errorf("PROVIDER=ics only uses local files and does not sign in")
+	}
+	return loadCredentials(scopes)
 }
 
-func getClient(config *oauth2.Config) *http.Client {
-	tokFile := getTokenFilePath()
+// getClient returns an HTTP client signed in with the token saved in tokFile, refreshing it within ctx
+// when it has expired and running the authorization flow when there is none.
+func getClient(ctx context.Context, config *oauth2.Config, tokFile string) (*http.Client, error) {
 	tok, err := tokenFromFile(tokFile)
+	granted, recorded := grantedScopes(tokFile)
+	if err == nil && recorded {
+		// A feature turned on since the token was saved can need more access, which is asked for on
+		// top of what was granted
+		if missing := missingScopes(granted, config.Scopes); len(missing) > 0 {
+			slog.Info("The saved token lacks scopes, authorizing again", "missing", strings.Join(missing, " "))
+			err = fmt.Errorf("missing scopes")
+		}
+	}
 	if err != nil {
-		tok = getTokenFromWeb(config)
-		saveToken(tokFile, tok)
+		if useDeviceFlow() {
+			tok, err = getTokenFromDevice(config)
+		} else {
+			tok, err = getTokenFromWeb(config)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := saveToken(tokFile, tok, tokenScopes(tok, append(granted, config.Scopes...)), time.Now()); err != nil {
+			return nil, err
+		}
 	} else {
 		if tok.Expiry.Before(time.Now()) {
-			tok, err = config.TokenSource(context.Background(), tok).Token()
+			tok, err = config.TokenSource(ctx, tok).Token()
+			if isTokenRevoked(err) {
+				return nil, fmt.Errorf("the token saved in %s has expired or was revoked: %v", tokFile, err)
+			}
 			if err != nil {
-				log.Fatalf("Unable to refresh token: %v", err)
+				return nil, fmt.Errorf("unable to refresh token: %v", err)
+			}
+			if err := saveToken(tokFile, tok, tokenScopes(tok, granted), tokenAuthorizedAt(tokFile)); err != nil {
+				return nil, err
 			}
-			saveToken(tokFile, tok)
 		}
 	}
-	return config.Client(context.Background(), tok)
+	return config.Client(context.Background(), tok), nil
 }
 
 func getTokenFilePath() string {
@@ -73,21 +169,93 @@ func getTokenFilePath() string {
 	return "token.json" // Default token file location
 }
 
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
+	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline, oauth2.SetAuthURLParam("include_granted_scopes", "true"))
 	fmt.Printf("Go to the following link in your web browser then type the authorization code: \n%v\n", authURL)
 
 	var authCode string
 	fmt.Println("Enter the authorization code here: ")
 	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("Unable to read authorization code: %v", err)
+		return nil, fmt.Errorf("unable to read authorization code: %v", err)
 	}
 
 	tok, err := config.Exchange(context.Background(), authCode)
 	if err != nil {
-		log.Fatalf("Unable to retrieve token from web: %v", err)
+		return nil, fmt.Errorf("unable to retrieve token from web: %v", err)
+	}
+	return tok, nil
+}
+
+// useDeviceFlow reports whether first-time authorization should use the device flow, either because
+// AUTH_FLOW=device is set or because there is no terminal to type an authorization code into.
+func useDeviceFlow() bool {
+	switch strings.ToLower(os.Getenv("AUTH_FLOW")) {
+	case "device":
+		return true
+	case "web":
+		return false
+	}
+	info, err := os.Stdin.Stat()
+	return err != nil || info.Mode()&os.ModeCharDevice == 0
+}
+
+// getTokenFromDevice runs the OAuth device authorization grant: it prints a code for the user to enter
+// on another device and polls until they approve it. The OAuth client must be of the
+// "TVs and Limited Input devices" type for Google to accept it.
+func getTokenFromDevice(config *oauth2.Config) (*oauth2.Token, error) {
+	if config.Endpoint.DeviceAuthURL == "" {
+		config.Endpoint.DeviceAuthURL = google.Endpoint.DeviceAuthURL
+	}
+	ctx := context.Background()
+	response, err := config.DeviceAuth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start device authorization: %v", err)
+	}
+	fmt.Printf("Go to %s on any device and enter the code: %s\n", response.VerificationURI, response.UserCode)
+	fmt.Println("Waiting for authorization...")
+
+	tok, err := config.DeviceAccessToken(ctx, response)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve token from device authorization: %v", err)
+	}
+	return tok, nil
+}
+
+// runAuthCommand handles "auth": it runs the authorization flow and saves a fresh token, replacing
+// any existing one, so a deployment can be bootstrapped before the tracker first runs and a running
+// one can be given a new token before the old one expires. -status reports on the saved token instead,
+// and -account picks one of the GOOGLE_ACCOUNTS in place of the main account.
+func runAuthCommand(args []string) error {
+	fs := flag.NewFlagSet("auth", flag.ExitOnError)
+	device := fs.Bool("device", useDeviceFlow(), "authorize with a code entered on another device")
+	status := fs.Bool("status", false, "print when the saved token was authorized and when it expires")
+	account := fs.String("account", "", "authorize one of the GOOGLE_ACCOUNTS instead of the main account")
+	fs.Parse(args)
+	appConfig := getConfig()
+	if _, ok := appConfig.Accounts[*account]; *account != "" && !ok {
+		return fmt.Errorf("unknown account %q, add it to GOOGLE_ACCOUNTS first", *account)
+	}
+	if *status {
+		return printTokenStatus(appConfig, *account, time.Now())
+	}
+
+	if *account != "" {
+		fmt.Printf("Sign in with the Google account you named %q.\n", *account)
+	}
+	config, err := loadOAuth2Config(requiredScopes(appConfig))
+	if err != nil {
+		return fmt.Errorf("error loading OAuth2 configuration: %v", err)
 	}
-	return tok
+	var tok *oauth2.Token
+	if *device {
+		tok, err = getTokenFromDevice(config)
+	} else {
+		tok, err = getTokenFromWeb(config)
+	}
+	if err != nil {
+		return err
+	}
+	return saveToken(accountTokenFile(appConfig, *account), tok, tokenScopes(tok, config.Scopes), time.Now())
 }
 
 func tokenFromFile(file string) (*oauth2.Token, error) {
@@ -101,21 +269,111 @@ func tokenFromFile(file string) (*oauth2.Token, error) {
 	return tok, err
 }
 
-func saveToken(path string, token *oauth2.Token) {
+// saveToken writes the token to the token file along with the scopes it was granted and when the
+// user authorized it, when known.
+func saveToken(path string, token *oauth2.Token, scopes []string, authorizedAt time.Time) error {
 	fmt.Printf("Saving credential file to: %s\n", path)
 	f, err := os.Create(path)
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		return fmt.Errorf("unable to cache oauth token: %v", err)
 	}
 	defer f.Close()
-	json.NewEncoder(f).Encode(token)
+	saved := struct {
+		*oauth2.Token
+		Scopes       []string   `json:"scopes,omitempty"`
+		AuthorizedAt *time.Time `json:"authorizedAt,omitempty"`
+	}{Token: token, Scopes: scopes}
+	if !authorizedAt.IsZero() {
+		saved.AuthorizedAt = &authorizedAt
+	}
+	return json.NewEncoder(f).Encode(saved)
 }
 
+// Private extended property identifying the events this tracker creates and manages.
+const (
+	managedEventProperty    = "paymentTracker"
+	totalRemainingEventType = "totalRemaining"
+	periodBandEventType     = "periodBand"
+	paydaySummaryEventType  = "paydaySummary"
+	breakdownEventType      = "breakdown"
+	budgetAlertEventType    = "budgetAlert"
+	amountProperty          = "amount"      // Amount the event was last written with
+	fingerprintProperty     = "fingerprint" // Hash of the title and description the event was last written with
+)
+
 type Config struct {
-	TotalRemainingOn string
-	TimeZone         string //Asia/Karachi (option)
-	PayDate          int
-	TickInterval     time.Duration // Tick interval in minutes
+	TotalRemainingOn    string
+	TimeZone            string //Asia/Karachi (option)
+	PayDate             int
+	TickInterval        time.Duration       // Tick interval in minutes
+	MaxTickInterval     time.Duration       // Longest interval the tick backs off to under quota pressure
+	WriteThreshold      float64             // Minimum change in amount before an existing event is rewritten
+	SendUpdates         string              // sendUpdates parameter for event writes: all, externalOnly or none
+	PageSize            int64               // Events per page Google Calendar lists, 0 for its default of 250
+	APIRateLimit        float64             // Calendar API requests allowed per second, 0 for no limit
+	Occasions           []Occasion          // Birthdays and other occasions with planned gift budgets
+	GiftReminderDays    int                 // Days ahead of an occasion to start reminding
+	BillEstimates       []BillEstimate      // Forecast estimates for variable bills not yet in the calendar
+	MaintenanceWindows  []MaintenanceWindow // Periods during which calendar writes are paused
+	ExportPath          string              // File to write the current period's upcoming payments to
+	ExportTemplatePath  string              // Optional text/template used to render the export file
+	ICSFeedPath         string              // .ics file the tracker's events are also written to, for calendar apps to subscribe to
+	EventColor          string              // ColorId of generated events, empty for the calendar default
+	EventMarker         string              // Text or emoji prefix of generated events
+	MaxSummaryLength    int                 // Longest event title before the full text moves to the description
+	ChangelogEntries    int                 // Amount changes listed in the "Total Remaining" description, 0 for none
+	SummaryTemplate     *template.Template  // Optional title of the "Total Remaining" event
+	DescriptionTemplate *template.Template  // Optional description of the "Total Remaining" event
+	EventLabel          string              // "Total Remaining" wording in the configured event language
+	EventLanguage       string              // EVENT_LANGUAGE generated event text is written in, empty for English
+	PayFrequency        string              // monthly, biweekly, weekly, 4-weekly, a week pattern like 4-4-5, or custom
+	Periods             PeriodCalculator    // Pay period boundaries for PayFrequency
+	PaymentCalendars    []string            // Calendars scanned for payment events
+	TargetCalendar      string              // Calendar the "Total Remaining" events are written to
+	CalendarAccess      map[string]string   // Access role of the user's calendars by ID, where the service reports one
+	CalendarTimeZones   map[string]string   // Per-calendar time zone overrides, keyed by calendar
+	Currency            Currency            // Currency symbol and number format from CURRENCY and LOCALE
+	PlanPath            string              // File each run's plan is saved to as an artifact
+	Profile             string              // Name distinguishing tracker instances that share a calendar
+	Provider            string              // Calendar service, google, outlook, caldav or ics for local files only
+	Accounts            map[string]string   // Token files of the Google accounts besides the main one, by name
+	ForecastPeriods     int                 // Number of future pay periods given a "Total Remaining" event
+	DryRun              bool                // Log calendar changes instead of making them
+	OAuthScopes         []string            // Google OAuth scopes the enabled features need
+	TokenLifetime       time.Duration       // How long Google accepts a refresh token for, 0 for no limit
+	TokenWarning        time.Duration       // How long before the token expires warnings start
+	HistoryPath         string              // File payment and period history is stored in
+	HistoryRetention    time.Duration       // How long history is kept after a payment was last seen or a period ended, 0 for ever
+	ArchivePath         string              // Directory the raw Calendar API events of each run are archived in
+	HealthAddr          string              // Address the /healthz and /readyz endpoints listen on
+	DashboardAddr       string              // Address the web dashboard listens on, empty for none
+	APIAddr             string              // Address the JSON API listens on, empty for none
+	APIToken            string              // Bearer token the JSON API requires, empty for none
+	TriggerURL          string              // Webhook trigger events are posted to, {event} is replaced by their name
+	TriggerBelow        float64             // Current period total below which total_below triggers, 0 for none
+	EventStatuses       map[string]bool     // Statuses of payment events that count towards totals
+	ResponseStatuses    map[string]bool     // Own responses to payment invitations that count towards totals
+	WebhookURL          string              // Public HTTPS URL Calendar API push notifications are sent to
+	WebhookAddr         string              // Address the push notification receiver listens on
+	PeriodBands         bool                // Mark each pay period with an all-day event spanning it
+	PeriodBandColor     string              // Colour ID of the pay period events
+	IncomeSource        string              // Where period income comes from for net remaining totals, empty for none
+	MonthlyIncome       float64             // Income per month when IncomeSource is "fixed"
+	PaydaySummary       bool                // Add an event summarising each pay period on its first day
+	PlannedSavings      float64             // Amount set aside each pay period, shown on the payday summary
+	SyncStatePath       string              // File the synced copy of the payment calendars is kept in
+	EventCache          *eventCache         // Synced payment events, set up by syncEventCache when SyncStatePath is set
+	Skipped             *skipLog            // Events left out of the totals in this run, with the reason
+	PaidColor           string              // Colour ID marking a payment as paid, empty to only use PaidMarker
+	PaidMarker          string              // Text in a payment's title marking it as paid
+	MarkPaid            bool                // Mark payments as paid once their date has passed
+	DriftPolicy         string              // What to do with managed events edited by hand: overwrite, preserve or alert
+	Breakdown           string              // Cadence of the remaining breakdown events, weekly or daily, empty for none
+	Notifiers           []Notifier          // Services notifications are sent through, from the NOTIFY_* settings
+	NotifyOn            map[string]bool     // Occasions notifications are sent on
+	BudgetLimit         float64             // Most a pay period's bills should come to, 0 for no limit
+	CategoryBudgets     map[string]float64  // Most each category should come to in a pay period
+	BudgetAlertColor    string              // Colour ID of the over budget warning events
 }
 
 func getConfig() Config {
@@ -136,254 +394,1169 @@ func getConfig() Config {
 		config.TimeZone = "GMT" // Default value
 	}
 
+	// tzdata is embedded in the binary, so a failure here means the name itself is wrong
+	if timeZone, ok := resolveTimeZone(config.TimeZone); !ok {
+		slog.Warn("TIME_ZONE is not a valid IANA time zone name, using default value GMT", "value", config.TimeZone, "did_you_mean", timeZone)
+		config.TimeZone = "GMT"
+	} else if timeZone != config.TimeZone {
+		slog.Info("TIME_ZONE interpreted as an IANA time zone", "value", config.TimeZone, "zone", timeZone)
+		config.TimeZone = timeZone
+	}
+
 	// Convert PAY_DATE from string to int
 	payDate, err := strconv.Atoi(payDateStr)
 	if err != nil {
-		log.Printf("Error converting PAY_DATE to int or not set: %v, using default value 1\n", err)
+		slog.Warn("Error converting PAY_DATE to int or not set, using default value 1", "error", err)
 		payDate = 1 // Default to 1 if conversion fails or not set
 	}
 	// Convert RUN_TIMER from string to int and then to duration in minutes
 	tickInterval, err := strconv.Atoi(tickIntervalStr)
 	if err != nil {
-		log.Printf("Error converting RUN_TIMER to int or not set: %v, using default value 60 minutes\n", err)
+		slog.Warn("Error converting RUN_TIMER to int or not set, using default value 60 minutes", "error", err)
 		tickInterval = 60 // Default to 60 minutes if conversion fails or not set
 	}
 
 	config.PayDate = payDate
 	config.TickInterval = time.Duration(tickInterval) * time.Minute
 
-	return config
-}
+	// Convert MAX_RUN_TIMER from string to int minutes, defaulting to eight times RUN_TIMER
+	config.MaxTickInterval = 8 * config.TickInterval
+	if maxTickStr := os.Getenv("MAX_RUN_TIMER"); maxTickStr != "" {
+		maxTick, err := strconv.Atoi(maxTickStr)
+		if err != nil || time.Duration(maxTick)*time.Minute < config.TickInterval {
+			slog.Warn("Invalid MAX_RUN_TIMER value, must be at least RUN_TIMER, using the default", "value", maxTickStr, "default", config.MaxTickInterval)
+		} else {
+			config.MaxTickInterval = time.Duration(maxTick) * time.Minute
+		}
+	}
 
-// parseAmountFromSummary attempts to find and parse an amount from an event summary.
-func parseAmountFromSummary(summary string) (float64, bool) {
-	// Regex to find an amount in the format "£999,000" or "£999,000.00", with or without the £ and comma, and with optional decimal places.
-	re := regexp.MustCompile(`£?(\d{1,3}(,\d{3})*|\d+)(\.\d{1,2})?`)
-	matches := re.FindStringSubmatch(summary)
-	if len(matches) == 0 {
-		return 0, false // No match found
+	config.PaymentCalendars = parseCalendarList(os.Getenv("PAYMENT_CALENDARS"))
+	if len(config.PaymentCalendars) == 0 {
+		config.PaymentCalendars = []string{"primary"} // Default value
 	}
-	amountStr := matches[0]
-	// Remove £ and comma for parsing
-	amountStr = strings.ReplaceAll(amountStr, "£", "")
-	amountStr = strings.ReplaceAll(amountStr, ",", "")
-	amount, err := strconv.ParseFloat(amountStr, 64)
-	if err != nil {
-		return 0, false // Failed to parse amount
+
+	config.TargetCalendar = strings.TrimSpace(os.Getenv("TARGET_CALENDAR"))
+	if config.TargetCalendar == "" {
+		config.TargetCalendar = "primary" // Default value
 	}
-	return amount, true
-}
 
-// calculateTotalPayments goes through event items and sums up all payment amounts.
-func calculateTotalPayments(srv *calendar.Service, startDate, endDate time.Time) float64 {
-	var total float64
-	now := time.Now() // Get current time to compare with event dates
+	config.CalendarTimeZones = parseCalendarTimeZones(os.Getenv("CALENDAR_TIMEZONES"))
+	config.Currency = newCurrency(os.Getenv("CURRENCY"), os.Getenv("LOCALE"))
+	config.SummaryTemplate = parseSummaryTemplate("SUMMARY_TEMPLATE", os.Getenv("SUMMARY_TEMPLATE"), config.Currency)
+	config.DescriptionTemplate = parseSummaryTemplate("DESCRIPTION_TEMPLATE", os.Getenv("DESCRIPTION_TEMPLATE"), config.Currency)
+	config.PlanPath = os.Getenv("PLAN_PATH")
+	config.HistoryPath = os.Getenv("HISTORY_PATH")
+	config.HistoryRetention = 730 * 24 * time.Hour // Default value
+	if retentionStr := os.Getenv("HISTORY_RETENTION_DAYS"); retentionStr != "" {
+		days, err := strconv.Atoi(retentionStr)
+		if err != nil || days < 0 {
+			slog.Warn("Invalid HISTORY_RETENTION_DAYS value, using default of 730", "value", retentionStr)
+		} else {
+			config.HistoryRetention = time.Duration(days) * 24 * time.Hour
+		}
+	}
+	config.ArchivePath = os.Getenv("ARCHIVE_PATH")
+	config.HealthAddr = os.Getenv("HEALTH_ADDR")
+	config.DashboardAddr = os.Getenv("DASHBOARD_ADDR")
+	config.APIAddr = os.Getenv("API_ADDR")
+	config.APIToken = os.Getenv("API_TOKEN")
+	config.TriggerURL = os.Getenv("TRIGGER_WEBHOOK_URL")
+	if config.TriggerURL != "" && config.HistoryPath == "" {
+		slog.Warn("TRIGGER_WEBHOOK_URL needs HISTORY_PATH to tell new payments apart, only total_below will be triggered")
+	}
+	if belowStr := os.Getenv("TRIGGER_TOTAL_BELOW"); belowStr != "" {
+		below, err := strconv.ParseFloat(belowStr, 64)
+		if err != nil || below <= 0 {
+			slog.Warn("Invalid TRIGGER_TOTAL_BELOW value, total_below will not be triggered", "value", belowStr)
+		} else {
+			config.TriggerBelow = below
+		}
+	}
+	config.WebhookURL = os.Getenv("WEBHOOK_URL")
+	config.WebhookAddr = os.Getenv("WEBHOOK_ADDR")
+	if config.WebhookAddr == "" {
+		config.WebhookAddr = ":8090" // Default value
+	}
+	if bandsStr := os.Getenv("PERIOD_BANDS"); bandsStr != "" {
+		bands, err := strconv.ParseBool(bandsStr)
+		if err != nil {
+			slog.Warn("Invalid PERIOD_BANDS value, pay periods will not be marked", "value", bandsStr)
+		}
+		config.PeriodBands = bands
+	}
+	config.PeriodBandColor = os.Getenv("PERIOD_BAND_COLOR")
+	if config.PeriodBandColor == "" {
+		config.PeriodBandColor = "8" // Default value, "8" is graphite in the default Google palette
+	}
+	switch breakdown := strings.ToLower(os.Getenv("BREAKDOWN")); breakdown {
+	case "", "none":
+	case breakdownWeekly, breakdownDaily:
+		config.Breakdown = breakdown
+	default:
+		slog.Warn("Invalid BREAKDOWN value, expected none, weekly or daily", "value", breakdown)
+	}
+	if limitStr := os.Getenv("BUDGET_LIMIT"); limitStr != "" {
+		limit, err := strconv.ParseFloat(limitStr, 64)
+		if err != nil || limit < 0 {
+			slog.Warn("Invalid BUDGET_LIMIT value, no budget limit will be set", "value", limitStr)
+		} else {
+			config.BudgetLimit = limit
+		}
+	}
+	config.CategoryBudgets = parseCategoryBudgets(os.Getenv("CATEGORY_BUDGETS"))
+	config.BudgetAlertColor = os.Getenv("BUDGET_ALERT_COLOR")
+	if config.BudgetAlertColor == "" {
+		config.BudgetAlertColor = "6" // Default value, "6" is tangerine in the default Google palette
+	}
+	config.SyncStatePath = os.Getenv("SYNC_STATE_PATH")
+	config.Skipped = newSkipLog()
+	config.Notifiers = loadNotifiers()
+	config.NotifyOn = parseNotifyOn(os.Getenv("NOTIFY_ON"))
 
-	// Ensure start date is not before today
-	if startDate.Before(now) {
-		startDate = now
+	// Payments marked as paid no longer count towards what remains
+	config.PaidColor = os.Getenv("PAID_COLOR")
+	switch strings.ToLower(config.PaidColor) {
+	case "":
+		config.PaidColor = defaultPaidColor // Default value
+	case "none":
+		config.PaidColor = ""
+	}
+	config.PaidMarker = os.Getenv("PAID_MARKER")
+	if config.PaidMarker == "" {
+		config.PaidMarker = defaultPaidMarker // Default value
+	}
+	switch policy := strings.ToLower(os.Getenv("DRIFT_POLICY")); policy {
+	case "":
+		config.DriftPolicy = driftOverwrite // Default value
+	case driftOverwrite, driftPreserve, driftAlert:
+		config.DriftPolicy = policy
+	default:
+		slog.Warn("Invalid DRIFT_POLICY value, expected overwrite, preserve or alert, using overwrite", "value", policy)
+		config.DriftPolicy = driftOverwrite
+	}
+	if markStr := os.Getenv("MARK_PAID"); markStr != "" {
+		mark, err := strconv.ParseBool(markStr)
+		if err != nil {
+			slog.Warn("Invalid MARK_PAID value, past payments will not be marked as paid", "value", markStr)
+		}
+		config.MarkPaid = mark
+	}
+
+	if summaryStr := os.Getenv("PAYDAY_SUMMARY"); summaryStr != "" {
+		summary, err := strconv.ParseBool(summaryStr)
+		if err != nil {
+			slog.Warn("Invalid PAYDAY_SUMMARY value, no payday summaries will be added", "value", summaryStr)
+		}
+		config.PaydaySummary = summary
+	}
+	if savingsStr := os.Getenv("PLANNED_SAVINGS"); savingsStr != "" {
+		savings, err := strconv.ParseFloat(savingsStr, 64)
+		if err != nil || savings < 0 {
+			slog.Warn("Invalid PLANNED_SAVINGS value, no savings will be planned", "value", savingsStr)
+		} else {
+			config.PlannedSavings = savings
+		}
+	}
+
+	// Income turns the "Total Remaining" amount into what is left of it after the period's bills
+	switch source := strings.ToLower(os.Getenv("INCOME_SOURCE")); source {
+	case "", "none":
+	case incomeFromCalendar:
+		config.IncomeSource = source
+	case incomeFixed:
+		income, err := strconv.ParseFloat(os.Getenv("MONTHLY_INCOME"), 64)
+		if err != nil || income < 0 {
+			slog.Warn("Invalid MONTHLY_INCOME value, income will not be tracked", "value", os.Getenv("MONTHLY_INCOME"))
+		} else {
+			config.IncomeSource = source
+			config.MonthlyIncome = income
+		}
+	default:
+		slog.Warn("Invalid INCOME_SOURCE value, expected calendar or fixed, income will not be tracked", "value", source)
+	}
+	config.EventStatuses = parseStatuses("EVENT_STATUSES", os.Getenv("EVENT_STATUSES"), defaultEventStatuses, eventStatuses)
+	config.ResponseStatuses = parseStatuses("RESPONSE_STATUSES", os.Getenv("RESPONSE_STATUSES"), defaultResponseStatuses, responseStatuses)
+	if dryRunStr := os.Getenv("DRY_RUN"); dryRunStr != "" {
+		dryRun, err := strconv.ParseBool(dryRunStr)
+		if err != nil {
+			slog.Warn("Invalid DRY_RUN value, calendar changes will be made", "value", dryRunStr)
+		}
+		config.DryRun = dryRun
+	}
+	config.OAuthScopes = requiredScopes(config)
+	config.Profile = os.Getenv("PROFILE")
+	if config.Profile == "" {
+		config.Profile = "default"
 	}
 
-	events, err := srv.Events.List("primary").
-		ShowDeleted(false).
-		SingleEvents(true).
-		TimeMin(startDate.Format(time.RFC3339)).
-		TimeMax(endDate.Format(time.RFC3339)).
-		OrderBy("startTime").
-		Q("Payment").
-		Do()
+	// Build the pay period calculator, falling back to monthly periods on PayDate
+	loc, _ := time.LoadLocation(config.TimeZone)
+	config.PayFrequency = os.Getenv("PAY_FREQUENCY")
+	periods, err := newPeriodCalculator(config.PayFrequency, config.PayDate, os.Getenv("PAY_ANCHOR_DATE"), os.Getenv("PAY_PERIOD_DATES"), loc)
 	if err != nil {
-		log.Fatalf("Unable to retrieve payment events: %v", err)
+		slog.Warn("Error configuring pay periods, using monthly periods", "error", err)
+		config.PayFrequency = "monthly"
+		periods, _ = newPeriodCalculator("monthly", config.PayDate, "", "", loc)
 	}
+	config.Periods = periods
 
-	for _, item := range events.Items {
-		if amount, ok := parseAmountFromSummary(item.Summary); ok {
-			total += amount
+	// Convert WRITE_THRESHOLD from string to float; unchanged amounts are never rewritten
+	if thresholdStr := os.Getenv("WRITE_THRESHOLD"); thresholdStr != "" {
+		threshold, err := strconv.ParseFloat(thresholdStr, 64)
+		if err != nil || threshold < 0 {
+			slog.Warn("Invalid WRITE_THRESHOLD value, using default value 0", "value", thresholdStr)
+			threshold = 0
 		}
+		config.WriteThreshold = threshold
 	}
 
-	return total
-}
+	config.SendUpdates = os.Getenv("SEND_UPDATES")
+	switch config.SendUpdates {
+	case "all", "externalOnly", "none":
+	case "":
+		config.SendUpdates = "none" // Default value
+	default:
+		slog.Warn("Invalid SEND_UPDATES value, using default value none", "value", config.SendUpdates)
+		config.SendUpdates = "none"
+	}
+	config.APIRateLimit = 5 // Default value, well within the per-user quotas of Google and Microsoft
+	if rateStr := os.Getenv("API_RATE_LIMIT"); rateStr != "" {
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate < 0 {
+			slog.Warn("Invalid API_RATE_LIMIT value, using the default of 5 requests a second", "value", rateStr)
+		} else {
+			config.APIRateLimit = rate
+		}
+	}
+	if pageSizeStr := os.Getenv("EVENTS_PAGE_SIZE"); pageSizeStr != "" {
+		pageSize, err := strconv.ParseInt(pageSizeStr, 10, 64)
+		if err != nil || pageSize < 1 || pageSize > maxEventsPageSize {
+			slog.Warn("Invalid EVENTS_PAGE_SIZE value, expected 1 to 2500, using the default of 250", "value", pageSizeStr)
+		} else {
+			config.PageSize = pageSize
+		}
+	}
 
-func manageTotalRemainingEvent(srv *calendar.Service, total float64, config Config) error {
-	loc, err := time.LoadLocation(config.TimeZone)
-	if err != nil {
-		log.Fatalf("Failed to load time zone '%s': %v", config.TimeZone, err)
+	config.Occasions = parseOccasions(os.Getenv("GIFT_OCCASIONS"))
+	config.BillEstimates = parseBillEstimates(os.Getenv("UTILITY_ESTIMATES"))
+	config.MaintenanceWindows = parseMaintenanceWindows(os.Getenv("MAINTENANCE_WINDOWS"))
+	config.ExportPath = os.Getenv("EXPORT_PAYMENTS_PATH")
+	config.ExportTemplatePath = os.Getenv("EXPORT_PAYMENTS_TEMPLATE")
+	config.ICSFeedPath = os.Getenv("ICS_FEED_PATH")
+
+	config.EventColor = os.Getenv("EVENT_COLOR")
+	switch config.EventColor {
+	case "":
+		config.EventColor = "11" // Default value, "11" is red in the default Google palette
+	case "none":
+		config.EventColor = ""
+	}
+
+	config.Provider = getProvider()
+	config.Accounts = parseAccounts(os.Getenv("GOOGLE_ACCOUNTS"))
+	if len(config.Accounts) > 0 && config.Provider != providerGoogle {
+		slog.Warn("GOOGLE_ACCOUNTS is only supported with PROVIDER=google, ignoring it")
+		config.Accounts = nil
 	}
-	now := time.Now().In(loc)
 
-	var eventDate time.Time
+	// Outlook, CalDAV and .ics files have no event colours the tracker can use. Colours would never
+	// read back as written, rewriting every event on each run.
+	if config.Provider != providerGoogle || usesICSFiles(config) {
+		config.EventColor, config.PeriodBandColor, config.PaidColor, config.BudgetAlertColor = "", "", "", ""
+	}
+	// Sync tokens and push channels are particular to a single Google Calendar account
+	singleGoogleAccount := config.Provider == providerGoogle && !usesICSFiles(config) && len(config.Accounts) == 0
+	if !singleGoogleAccount && (config.SyncStatePath != "" || config.WebhookURL != "") {
+		slog.Warn("SYNC_STATE_PATH and WEBHOOK_URL are only supported with PROVIDER=google, a single account and no .ics calendars, ignoring them")
+		config.SyncStatePath, config.WebhookURL = "", ""
+	}
 
-	switch config.TotalRemainingOn {
-	case "Last Day of the Month":
-		firstOfNextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, loc)
-		eventDate = firstOfNextMonth.Add(-24 * time.Hour)
-	case "First Day of the Month":
-		eventDate = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
-	case "Pay Date":
-		eventDate = time.Date(now.Year(), now.Month(), config.PayDate, 0, 0, 0, 0, loc)
-		if now.Day() > config.PayDate {
-			eventDate = eventDate.AddDate(0, 1, 0)
+	// Apps whose OAuth consent screen is in testing mode get refresh tokens that expire after a week
+	if testingStr := os.Getenv("OAUTH_TESTING_MODE"); testingStr != "" {
+		testing, err := strconv.ParseBool(testingStr)
+		if err != nil {
+			slog.Warn("Invalid OAUTH_TESTING_MODE value, token expiry will not be tracked", "value", testingStr)
 		}
-	default:
-		log.Fatalf("Invalid TotalRemainingOn value: %v", config.TotalRemainingOn)
+		if testing && config.Provider == providerGoogle {
+			config.TokenLifetime = testingTokenLifetime
+		}
+	}
+	config.TokenWarning = 2 * 24 * time.Hour // Default value
+	if warningStr := os.Getenv("TOKEN_WARNING_DAYS"); warningStr != "" {
+		days, err := strconv.Atoi(warningStr)
+		if err != nil || days < 0 {
+			slog.Warn("Invalid TOKEN_WARNING_DAYS value, using default of 2", "value", warningStr)
+		} else {
+			config.TokenWarning = time.Duration(days) * 24 * time.Hour
+		}
+	}
+
+	if maxLengthStr := os.Getenv("MAX_SUMMARY_LENGTH"); maxLengthStr != "" {
+		maxLength, err := strconv.Atoi(maxLengthStr)
+		if err != nil || maxLength < 0 {
+			slog.Warn("Invalid MAX_SUMMARY_LENGTH value, titles will not be truncated", "value", maxLengthStr)
+		} else {
+			config.MaxSummaryLength = maxLength
+		}
+	}
+
+	if entriesStr := os.Getenv("CHANGELOG_ENTRIES"); entriesStr != "" {
+		entries, err := strconv.Atoi(entriesStr)
+		if err != nil || entries < 0 {
+			slog.Warn("Invalid CHANGELOG_ENTRIES value, amount changes will not be listed", "value", entriesStr)
+		} else {
+			config.ChangelogEntries = entries
+		}
+	}
+
+	config.ForecastPeriods = 11 // Default value, a year ahead with monthly periods
+	if periodsStr := os.Getenv("FORECAST_PERIODS"); periodsStr != "" {
+		periods, err := strconv.Atoi(periodsStr)
+		if err != nil || periods < 0 {
+			slog.Warn("Invalid FORECAST_PERIODS value, using the default", "value", periodsStr, "default", config.ForecastPeriods)
+		} else {
+			config.ForecastPeriods = periods
+		}
+	}
+
+	config.EventLanguage = getEventLanguage(os.Getenv("EVENT_LANGUAGE"))
+	config.EventLabel = getEventLabel(config.EventLanguage, os.Getenv("EVENT_LABEL"))
+
+	config.EventMarker = os.Getenv("EVENT_MARKER")
+	switch config.EventMarker {
+	case "emoji":
+		config.EventMarker = "💰"
+	case "text":
+		config.EventMarker = "[TOTAL]"
+	}
+
+	config.GiftReminderDays = 14 // Default value
+	if reminderStr := os.Getenv("GIFT_REMINDER_DAYS"); reminderStr != "" {
+		reminderDays, err := strconv.Atoi(reminderStr)
+		if err != nil {
+			slog.Warn("Error converting GIFT_REMINDER_DAYS to int, using default value 14", "error", err)
+		} else {
+			config.GiftReminderDays = reminderDays
+		}
+	}
+
+	return config
+}
+
+// parseAmountFromSummary attempts to find and parse an amount in the configured currency from an event summary.
+func parseAmountFromSummary(summary string, currency Currency) (float64, bool) {
+	return currency.Parse(summary)
+}
+
+// localAmount parses the amount of an event in the configured currency. Amounts in other currencies
+// are left out rather than read as local ones, as the currency symbol is optional when parsing.
+func localAmount(item *calendar.Event, config Config) (float64, bool) {
+	if _, _, foreign := config.Currency.ParseForeign(item.Summary); foreign {
+		return 0, false
 	}
+	return parseAmountFromSummary(item.Summary, config.Currency)
+}
 
-	// Delete existing "Total Remaining" events
-	events, err := srv.Events.List("primary").
-		ShowDeleted(false).
-		SingleEvents(true).
-		Q("Total Remaining").Do()
+// countedAmount parses the amount a payment event adds to its period's total the way
+// calculateTotalPayments counts it, leaving out payments marked as paid and those in other currencies.
+func countedAmount(item *calendar.Event, config Config) (float64, bool) {
+	if isPaid(item, config) {
+		return 0, false
+	}
+	return localAmount(item, config)
+}
 
+// calculateTotalPayments goes through event items and sums up all payment amounts, by category.
+func calculateTotalPayments(ctx context.Context, srv CalendarProvider, startDate, endDate time.Time, config Config) (PeriodTotal, error) {
+	events, err := listUpcomingPaymentEvents(ctx, srv, startDate, endDate, config)
 	if err != nil {
-		log.Fatalf("Failed to retrieve events: %v", err)
+		return PeriodTotal{}, err
 	}
 
-	for _, item := range events.Items {
-		if strings.HasPrefix(item.Summary, "Total Remaining") {
-			err := srv.Events.Delete("primary", item.Id).Do()
+	total := PeriodTotal{Start: startDate, End: endDate, Events: events}
+	for _, item := range events {
+		if isPaid(item, config) {
+			config.Skipped.record(item, "marked as paid")
+			continue
+		}
+		if currency, amount, ok := config.Currency.ParseForeign(item.Summary); ok {
+			total.AddForeign(currency.Symbol, amount)
+			continue
+		}
+		if amount, ok := parseAmountFromSummary(item.Summary, config.Currency); ok {
+			total.Add(paymentCategory(item), amount)
+			total.Payments = append(total.Payments, item)
+		} else {
+			config.Skipped.record(item, "no amount found in the title")
+		}
+	}
+
+	return total, nil
+}
+
+// listUpcomingPaymentEvents returns the payment events in the period that have not happened yet.
+func listUpcomingPaymentEvents(ctx context.Context, srv CalendarProvider, startDate, endDate time.Time, config Config) ([]*calendar.Event, error) {
+	now := time.Now() // Get current time to compare with event dates
+
+	// Ensure start date is not before today
+	if startDate.Before(now) {
+		startDate = now
+	}
+
+	return listPaymentEvents(ctx, srv, startDate, endDate, config)
+}
+
+// listPaymentEvents returns the payment events between startDate and endDate across all payment calendars,
+// keeping those whose status and response count according to EVENT_STATUSES and RESPONSE_STATUSES.
+func listPaymentEvents(ctx context.Context, srv CalendarProvider, startDate, endDate time.Time, config Config) ([]*calendar.Event, error) {
+	return listCalendarEvents(ctx, srv, startDate, endDate, "Payment", config)
+}
+
+// listCalendarEvents returns the events matching query between startDate and endDate across all
+// payment calendars, with the same status rules as listPaymentEvents.
+func listCalendarEvents(ctx context.Context, srv CalendarProvider, startDate, endDate time.Time, query string, config Config) ([]*calendar.Event, error) {
+	var items []*calendar.Event
+	for _, calendarID := range config.PaymentCalendars {
+		zone, overridden := config.CalendarTimeZones[calendarID]
+		if config.EventCache != nil {
+			items = append(items, cachedEvents(config.EventCache, calendarID, query, startDate, endDate, config)...)
+			continue
+		}
+		if !overridden {
+			events, err := srv.ListEvents(ctx, calendarID, EventQuery{
+				Text:        query,
+				TimeMin:     startDate,
+				TimeMax:     endDate,
+				ShowDeleted: config.EventStatuses["cancelled"],
+			})
 			if err != nil {
-				return fmt.Errorf("unable to delete event: %v", err)
+				return nil, fmt.Errorf("unable to retrieve %s events from calendar %s: %v", strings.ToLower(query), calendarID, err)
 			}
+			items = append(items, filterPaymentEvents(events, config)...)
+			continue
 		}
+
+		// Events in a calendar with its own time zone are assigned to periods by their local date
+		// there, so widen the query by a day either side and filter on that date instead.
+		events, err := srv.ListEvents(ctx, calendarID, EventQuery{
+			Text:        query,
+			TimeMin:     startDate.AddDate(0, 0, -1),
+			TimeMax:     endDate.AddDate(0, 0, 1),
+			TimeZone:    zone,
+			ShowDeleted: config.EventStatuses["cancelled"],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve %s events from calendar %s: %v", strings.ToLower(query), calendarID, err)
+		}
+		calendarLoc, _ := time.LoadLocation(zone)
+		loc := startDate.Location()
+		firstDay := time.Date(startDate.Year(), startDate.Month(), startDate.Day(), 0, 0, 0, 0, loc)
+		for _, item := range filterPaymentEvents(events, config) {
+			if date := eventLocalDate(item, calendarLoc, loc); !date.Before(firstDay) && !date.After(endDate) {
+				items = append(items, item)
+			}
+		}
+	}
+
+	if len(config.PaymentCalendars) > 1 {
+		items = dropDuplicates(items, config)
+		sort.SliceStable(items, func(i, j int) bool {
+			return eventStartDate(items[i], time.UTC).Before(eventStartDate(items[j], time.UTC))
+		})
+	}
+	return items, nil
+}
+
+// buildTotalRemainingEvent returns the "Total Remaining" event the given pay period should have.
+func buildTotalRemainingEvent(total PeriodTotal, startDate, endDate time.Time, config Config) (desiredEvent, error) {
+	eventDate, err := getTotalRemainingEventDate(startDate, endDate, config)
+	if err != nil {
+		return desiredEvent{}, err
 	}
 
 	// Create the new "Total Remaining" event based on eventDate and TimeZone
-	event := &calendar.Event{
-		Summary: fmt.Sprintf("Total Remaining £%.2f", total),
+	return desiredEvent{Event: newTotalRemainingEvent(eventDate, total, config), Amount: total.Remaining(config), Period: total}, nil
+}
+
+// newTotalRemainingEvent builds the all-day "Total Remaining" event, marked with the configured
+// colour and/or text marker so it stays recognisable without relying on colour alone. When payments
+// are categorised the description lists the subtotal of each category. SUMMARY_TEMPLATE and
+// DESCRIPTION_TEMPLATE replace the built-in title and description.
+func newTotalRemainingEvent(eventDate time.Time, total PeriodTotal, config Config) *calendar.Event {
+	summary := defaultSummary(total, config)
+	if config.SummaryTemplate != nil {
+		summary = renderSummary(config.SummaryTemplate, summaryData(total, config), summary)
+	}
+	details := defaultDescription(total, config)
+	if config.DescriptionTemplate != nil {
+		details = renderSummary(config.DescriptionTemplate, summaryData(total, config), details)
+	}
+
+	title, description := truncateSummary(summary, config.MaxSummaryLength)
+	if details != "" {
+		description = strings.TrimPrefix(description+"\n\n"+details, "\n\n")
+	}
+
+	return &calendar.Event{
+		Summary:     title,
+		Description: description,
 		Start: &calendar.EventDateTime{
 			Date:     eventDate.Format("2006-01-02"),
-			TimeZone: config.TimeZone,
+			TimeZone: calendarTimeZone(config, config.TargetCalendar),
 		},
 		End: &calendar.EventDateTime{
 			Date:     eventDate.AddDate(0, 0, 1).Format("2006-01-02"),
-			TimeZone: config.TimeZone,
+			TimeZone: calendarTimeZone(config, config.TargetCalendar),
+		},
+		ColorId: config.EventColor,
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{
+				managedEventProperty: totalRemainingEventType,
+				amountProperty:       strconv.FormatFloat(total.Remaining(config), 'f', 2, 64),
+			},
 		},
-		ColorId: "11", // Assuming "11" is red; adjust based on your calendar settings
 	}
+}
+
+// truncateSummary shortens a summary to at most maxLength characters, cutting at a word boundary
+// where possible. When it had to shorten, the full text is returned as the description instead.
+func truncateSummary(summary string, maxLength int) (title, description string) {
+	runes := []rune(summary)
+	if maxLength <= 0 || len(runes) <= maxLength {
+		return summary, ""
+	}
+	if maxLength == 1 {
+		return "…", summary
+	}
+
+	cut := string(runes[:maxLength-1])
+	if i := strings.LastIndex(cut, " "); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,;:-") + "…", summary
+}
 
-	_, err = srv.Events.Insert("primary", event).Do()
+// fullSummary returns the summary of a generated event as it was before truncation, which is the
+// first paragraph of the description of a truncated event.
+func fullSummary(event *calendar.Event) string {
+	if strings.HasSuffix(event.Summary, "…") && event.Description != "" {
+		return strings.SplitN(event.Description, "\n\n", 2)[0]
+	}
+	return event.Summary
+}
+
+// loadTotalRemainingEvents fetches the existing "Total Remaining" events from the target calendar.
+// Events are recognised by their private property whatever language they were written in, and
+// by their English title for events created before the property was introduced.
+func loadTotalRemainingEvents(ctx context.Context, srv CalendarProvider, config Config) ([]*calendar.Event, error) {
+	managed, err := loadManagedEvents(ctx, srv, config, totalRemainingEventType)
 	if err != nil {
-		return fmt.Errorf("unable to create event: %v", err)
+		return nil, err
 	}
 
-	return nil
+	legacy, err := srv.ListEvents(ctx, config.TargetCalendar, EventQuery{Text: "Total Remaining"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve events: %v", err)
+	}
+
+	existing := managed
+	seen := make(map[string]bool)
+	for _, item := range managed {
+		seen[item.Id] = true
+	}
+	for _, item := range legacy {
+		if seen[item.Id] || item.Start == nil || isManagedEvent(item, totalRemainingEventType) || !strings.Contains(item.Summary, "Total Remaining") {
+			continue
+		}
+		seen[item.Id] = true
+		existing = append(existing, item)
+	}
+	return existing, nil
 }
 
-// Generates future "Total Remaining" events for the next 11 months
-func generateFutureTotalRemainingEvents(srv *calendar.Service, config Config) {
-	loc, err := time.LoadLocation(config.TimeZone)
+// loadManagedEvents fetches the events of one managed type from the target calendar. Every page is
+// read so that events beyond the forecast horizon, left behind when it shrinks, are found and
+// garbage collected along with the rest.
+func loadManagedEvents(ctx context.Context, srv CalendarProvider, config Config, eventType string) ([]*calendar.Event, error) {
+	events, err := srv.ListEvents(ctx, config.TargetCalendar, EventQuery{Property: managedEventProperty + "=" + eventType})
 	if err != nil {
-		log.Fatalf("Failed to load time zone '%s': %v", config.TimeZone, err)
+		return nil, fmt.Errorf("unable to retrieve events: %v", err)
 	}
-	now := time.Now().In(loc)
+	var items []*calendar.Event
+	for _, item := range events {
+		if item.Start != nil {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
 
-	for i := 1; i <= 11; i++ {
-		futureMonth := now.AddDate(0, i, 0)
-		year, month := futureMonth.Year(), futureMonth.Month()
+// isManagedEvent reports whether the event was created by the tracker as the given event type.
+func isManagedEvent(item *calendar.Event, eventType string) bool {
+	return item.ExtendedProperties != nil && item.ExtendedProperties.Private[managedEventProperty] == eventType
+}
 
-		startDate, endDate := getPaymentPeriodDates(year, int(month), config.PayDate, loc)
-		total := calculateTotalPayments(srv, startDate, endDate)
-		if err := manageTotalRemainingEventForMonth(srv, total, year, month, config, loc); err != nil {
-			log.Fatalf("Error managing the 'Total Remaining' event for %v %d: %v", month, year, err)
+// buildFutureTotalRemainingEvents returns the "Total Remaining" events for the ForecastPeriods pay periods following the one ending at endDate
+func buildFutureTotalRemainingEvents(ctx context.Context, srv CalendarProvider, endDate time.Time, config Config) ([]desiredEvent, error) {
+	var desired []desiredEvent
+	for i := 1; i <= config.ForecastPeriods; i++ {
+		var startDate time.Time
+		startDate, endDate = nextPeriod(config.Periods, endDate)
+
+		total, err := futurePeriodTotal(ctx, srv, startDate, endDate, config)
+		if err != nil {
+			return nil, err
+		}
+		event, err := buildTotalRemainingEvent(total, startDate, endDate, config)
+		if err != nil {
+			return nil, fmt.Errorf("error building the 'Total Remaining' event for the period starting %s: %v", startDate.Format("2006-01-02"), err)
 		}
+		desired = append(desired, event)
 	}
+	return desired, nil
 }
 
-// getPaymentPeriodDates calculates the start and end dates for payment calculations based on the given year, month, and PayDate.
-func getPaymentPeriodDates(year, month int, payDate int, loc *time.Location) (startDate, endDate time.Time) {
-	startDate = time.Date(year, time.Month(month), payDate, 0, 0, 0, 0, loc)
-	if month == 12 {
-		endDate = time.Date(year+1, time.Month(1), payDate, 0, 0, 0, 0, loc).Add(-time.Second)
-	} else {
-		endDate = time.Date(year, time.Month(month+1), payDate, 0, 0, 0, 0, loc).Add(-time.Second)
+// currentPeriodTotal totals the payments left in the current pay period as of now, including
+// planned gift spending and, when tracked, the period's income.
+func currentPeriodTotal(ctx context.Context, srv CalendarProvider, startDate, endDate time.Time, config Config, now time.Time) (PeriodTotal, error) {
+	total, err := calculateTotalPayments(ctx, srv, startDate, endDate, config)
+	if err != nil {
+		return PeriodTotal{}, err
+	}
+	if gifts := plannedGiftSpend(config.Occasions, startDate, endDate); gifts > 0 {
+		total.Add(giftsCategory, gifts)
+	}
+	if tracksIncome(config) {
+		if err := addIncome(ctx, srv, &total, config, now); err != nil {
+			return PeriodTotal{}, err
+		}
+	}
+	return total, nil
+}
+
+// futurePeriodTotal totals a future pay period: its payments, planned gift spending and estimates
+// for regular bills that have not been entered yet.
+func futurePeriodTotal(ctx context.Context, srv CalendarProvider, startDate, endDate time.Time, config Config) (PeriodTotal, error) {
+	total, err := calculateTotalPayments(ctx, srv, startDate, endDate, config)
+	if err != nil {
+		return PeriodTotal{}, err
+	}
+	if gifts := plannedGiftSpend(config.Occasions, startDate, endDate); gifts > 0 {
+		total.Add(giftsCategory, gifts)
 	}
-	return
+	if len(config.BillEstimates) > 0 {
+		// Every payment of a period still to come is upcoming, so total.Events already holds them
+		// all; only a period that has started since needs listing in full
+		events := total.Events
+		if !startDate.After(time.Now()) {
+			if events, err = listPaymentEvents(ctx, srv, startDate, endDate, config); err != nil {
+				return PeriodTotal{}, err
+			}
+		}
+		estimate, err := estimateMissingBills(ctx, srv, events, startDate, endDate, config)
+		if err != nil {
+			return PeriodTotal{}, err
+		}
+		if estimate > 0 {
+			total.Add(estimatedCategory, estimate)
+		}
+	}
+	if tracksIncome(config) {
+		if err := addIncome(ctx, srv, &total, config, time.Now()); err != nil {
+			return PeriodTotal{}, err
+		}
+	}
+	return total, nil
 }
 
-func manageTotalRemainingEventForMonth(srv *calendar.Service, total float64, year int, month time.Month, config Config, loc *time.Location) error {
-	var eventDate time.Time
+// Failed syncs are retried this many times, waiting retryDelay before the first retry and twice as
+// long before each one after that.
+const (
+	runRetries = 3
+	retryDelay = 10 * time.Second
+)
 
-	switch config.TotalRemainingOn {
-	case "Last Day of the Month":
-		// Calculate the last day of the given month
-		firstOfNextMonth := time.Date(year, month+1, 1, 0, 0, 0, 0, loc)
-		lastDayOfMonth := firstOfNextMonth.Add(-24 * time.Hour)
-		eventDate = lastDayOfMonth
-	case "First Day of the Month":
-		eventDate = time.Date(year, month, 1, 0, 0, 0, 0, loc)
-	case "Pay Date":
-		eventDate = time.Date(year, month, config.PayDate, 0, 0, 0, 0, loc)
-	default:
-		return fmt.Errorf("invalid TotalRemainingOn value: %v", config.TotalRemainingOn)
+// getRunTimeout returns how long a single sync may take, from RUN_TIMEOUT in minutes, so a hung
+// Calendar API call fails the run rather than holding up every one after it.
+func getRunTimeout() time.Duration {
+	timeout := 10 * time.Minute // Default value
+	if timeoutStr := os.Getenv("RUN_TIMEOUT"); timeoutStr != "" {
+		minutes, err := strconv.Atoi(timeoutStr)
+		if err != nil || minutes <= 0 {
+			slog.Warn("Invalid RUN_TIMEOUT value, using the default", "value", timeoutStr, "default", timeout)
+		} else {
+			timeout = time.Duration(minutes) * time.Minute
+		}
 	}
+	return timeout
+}
 
-	// Create and insert the event as done before
-	event := &calendar.Event{
-		Summary: fmt.Sprintf("Total Remaining £%.2f", total),
-		Start: &calendar.EventDateTime{
-			Date:     eventDate.Format("2006-01-02"),
-			TimeZone: loc.String(),
-		},
-		End: &calendar.EventDateTime{
-			Date:     eventDate.AddDate(0, 0, 1).Format("2006-01-02"),
-			TimeZone: loc.String(),
-		},
-		ColorId: "11",
+// taskToRun performs a sync, retrying with exponential backoff when it fails. A sync that still fails
+// returns its last error, leaving the next attempt to the next tick. Once ctx is cancelled no further
+// attempt is made.
+func taskToRun(ctx context.Context, conn *connection) error {
+	delay := retryDelay
+	err := syncCalendar(ctx, conn)
+	for attempt := 1; err != nil && ctx.Err() == nil && attempt <= runRetries; attempt++ {
+		slog.Warn("Sync failed, retrying", "delay", delay, "attempt", attempt, "retries", runRetries, "error", err)
+		if sleepContext(ctx, delay) != nil {
+			break
+		}
+		delay *= 2
+		err = syncCalendar(ctx, conn)
 	}
+	return err
+}
 
-	_, err := srv.Events.Insert("primary", event).Do()
+// syncCalendar performs a single sync: it totals the payments of the current and future pay periods
+// and brings the "Total Remaining" events in line with them. A sync whose context is cancelled while
+// it plans writes nothing; one that has started writing finishes. A sync that succeeds logs a summary
+// of its period, total and the calendar operations it took.
+func syncCalendar(ctx context.Context, conn *connection) error {
+	started := time.Now()
+	planCtx, applyCtx, cancel := runContexts(ctx)
+	defer cancel()
+	connected, config, err := conn.prepare(planCtx)
 	if err != nil {
-		return fmt.Errorf("unable to create event: %v", err)
+		return err
 	}
+	srv := &countingProvider{CalendarProvider: connected}
 
+	// Plan phase: work out which calendar changes are needed
+	plan, err := planRun(planCtx, srv, config, time.Now())
+	if err != nil {
+		conn.failed()
+		return err
+	}
+	if config.PlanPath != "" {
+		if err := savePlan(plan, config.PlanPath); err != nil {
+			slog.Error("Error saving plan artifact", "error", err)
+		}
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("stopping before applying %d planned changes: %v", len(plan.Changes), ctx.Err())
+	}
+	if err := applyRun(applyCtx, srv, plan, config); err != nil {
+		conn.failed()
+		return err
+	}
+	slog.Info("Sync finished",
+		"period", plan.Period,
+		"total", plan.Remaining,
+		"currency", config.Currency.Symbol,
+		"changes", len(plan.Changes),
+		"events_scanned", srv.events.Load(),
+		"api_calls", srv.calls.Load(),
+		"duration", time.Since(started).Round(time.Millisecond))
 	return nil
 }
 
-func taskToRun() {
+// applyRun is the apply phase of a sync: it writes the planned changes to the calendar and follows up
+// on them. Nothing outside the calendar is written until the changes are.
+func applyRun(ctx context.Context, srv CalendarProvider, plan *Plan, config Config) error {
+	if err := applyEventChanges(ctx, srv, plan.Changes, config); err != nil {
+		return fmt.Errorf("error reconciling 'Total Remaining' events: %v", err)
+	}
+	triggers := recordRun(plan, config)
+	notifyAppliedChanges(plan.Changes, config)
+	sendTriggers(triggers, config)
+	if config.MarkPaid {
+		if err := markPastPayments(ctx, srv, config, time.Now()); err != nil {
+			slog.Error("Error marking past payments as paid", "error", err)
+		}
+	}
+	return nil
+}
+
+// recordRun writes what a sync saw once its changes are applied: the payment history, the raw event
+// archive, the export of upcoming payments and the .ics feed, and reminds of upcoming occasions. It
+// returns the triggers to send. Failures are only logged.
+func recordRun(plan *Plan, config Config) []interface{} {
+	if len(plan.periods) == 0 {
+		return nil // A plan loaded from a file carries only its changes
+	}
+	loc := plan.CreatedAt.Location()
+	current := plan.periods[0]
+	remindUpcomingOccasions(config, plan.CreatedAt)
+
+	var newPayments []*calendar.Event
+	var err error
+	if config.HistoryPath != "" {
+		if newPayments, err = recordHistory(config.HistoryPath, plan.periods, config, plan.CreatedAt); err != nil {
+			slog.Error("Error recording payment history", "error", err)
+		}
+	}
+	if config.ArchivePath != "" {
+		if err := archiveRawEvents(config.ArchivePath, plan.periods, config, plan.CreatedAt); err != nil {
+			slog.Error("Error archiving raw events", "error", err)
+		}
+	}
+	if config.ExportPath != "" {
+		if err := exportScheduledPayments(current.Period.Events, config, loc); err != nil {
+			slog.Error("Error exporting scheduled payments", "error", err)
+		}
+	}
+	if config.ICSFeedPath != "" {
+		if err := writeICSFeed(config.ICSFeedPath, plan.periods, config); err != nil {
+			slog.Error("Error writing the .ics feed", "error", err)
+		}
+	}
+	return plannedTriggers(newPayments, current, plan.actual, config, loc)
+}
+
+// prepareRun loads the configuration, connects to the Calendar API and brings the event cache up to
+// date, for commands that run on their own.
+func prepareRun(ctx context.Context, monitor *quotaMonitor) (CalendarProvider, Config, error) {
+	srv, config, err := connectRun(ctx, monitor)
+	if err != nil {
+		return nil, config, err
+	}
+	if err := syncEventCache(ctx, srv, &config); err != nil {
+		return nil, config, err
+	}
+	return srv, config, nil
+}
+
+// connectRun loads the configuration and connects to the Calendar API, resolving configured calendar names to IDs.
+func connectRun(ctx context.Context, monitor *quotaMonitor) (CalendarProvider, Config, error) {
 	config := getConfig() // Get configuration from environment variables
 
-	// Initialize Google Calendar service with OAuth2 client
-	srv, err := initializeCalendarService()
+	// Initialize the calendar service with OAuth2 client
+	srv, err := initializeCalendarService(ctx, monitor, config)
+	if err != nil {
+		return nil, config, fmt.Errorf("error initializing calendar service: %v", err)
+	}
+
+	// Resolve calendar names to IDs
+	if config.PaymentCalendars, err = resolveCalendarIDs(ctx, srv, config.PaymentCalendars); err != nil {
+		return nil, config, fmt.Errorf("error resolving PAYMENT_CALENDARS: %v", err)
+	}
+	targetCalendars, err := resolveCalendarIDs(ctx, srv, []string{config.TargetCalendar})
+	if err != nil {
+		return nil, config, fmt.Errorf("error resolving TARGET_CALENDAR: %v", err)
+	}
+	config.TargetCalendar = targetCalendars[0]
+	if config.CalendarTimeZones, err = resolveCalendarTimeZones(ctx, srv, config.CalendarTimeZones); err != nil {
+		return nil, config, fmt.Errorf("error resolving CALENDAR_TIMEZONES: %v", err)
+	}
+
+	// Work around calendars shared with the user without full access
+	config.CalendarAccess = loadCalendarAccess(ctx, srv)
+	applyCalendarAccess(&config)
+	return srv, config, nil
+}
+
+// syncEventCache brings the local copy of the payment calendars up to date, when one is kept, and
+// sets it on config. Sync tokens are particular to Google Calendar.
+func syncEventCache(ctx context.Context, srv CalendarProvider, config *Config) error {
+	google, ok := srv.(*googleProvider)
+	if !ok || config.SyncStatePath == "" {
+		return nil
+	}
+	cache, err := loadEventCache(config.SyncStatePath)
 	if err != nil {
-		log.Fatalf("Error initializing Google Calendar service: %v", err)
+		return err
 	}
+	for _, calendarID := range config.PaymentCalendars {
+		if err := cache.sync(ctx, google, calendarID); err != nil {
+			return fmt.Errorf("error syncing calendar %s: %v", calendarID, err)
+		}
+	}
+	if err := cache.save(config.SyncStatePath); err != nil {
+		return err
+	}
+	config.EventCache = cache
+	return nil
+}
 
+// planRun computes the totals for the current and future pay periods as of now and the calendar
+// changes needed to bring the "Total Remaining" events in line with them, without writing anything.
+func planRun(ctx context.Context, srv CalendarProvider, config Config, now time.Time) (*Plan, error) {
 	loc, err := time.LoadLocation(config.TimeZone)
 	if err != nil {
-		log.Fatalf("Failed to load time zone '%s': %v", config.TimeZone, err)
+		return nil, fmt.Errorf("failed to load time zone '%s': %v", config.TimeZone, err)
 	}
-	now := time.Now().In(loc)
+	now = now.In(loc)
 
-	// Determine the current payment period based on today's date and PayDate
-	var startDate, endDate time.Time
-	if now.Day() >= config.PayDate {
-		startDate = time.Date(now.Year(), now.Month(), config.PayDate, 0, 0, 0, 0, loc)
-		endDate = startDate.AddDate(0, 1, 0).Add(-time.Second)
-	} else {
-		startDate = time.Date(now.Year(), now.Month()-1, config.PayDate, 0, 0, 0, 0, loc)
-		endDate = startDate.AddDate(0, 1, 0).Add(-time.Second)
+	// Determine the current payment period based on today's date and the pay schedule
+	startDate, endDate := config.Periods.Period(now)
+
+	// Calculate total payments for the current period, including planned gift spending
+	total, err := currentPeriodTotal(ctx, srv, startDate, endDate, config, now)
+	if err != nil {
+		return nil, err
 	}
 
-	// Calculate total payments for the current period
-	total := calculateTotalPayments(srv, startDate, endDate)
+	// Build the "Total Remaining" event for the current period
+	current, err := buildTotalRemainingEvent(total, startDate, endDate, config)
+	if err != nil {
+		return nil, fmt.Errorf("error building the 'Total Remaining' event: %v", err)
+	}
 
-	// Manage "Total Remaining" event for the current period
-	if err := manageTotalRemainingEvent(srv, total, config); err != nil {
-		log.Fatalf("Error managing the 'Total Remaining' event: %v", err)
+	// Build future "Total Remaining" events based on the configuration
+	future, err := buildFutureTotalRemainingEvents(ctx, srv, endDate, config)
+	if err != nil {
+		return nil, err
 	}
+	desired := append([]desiredEvent{current}, future...)
+	periods := desired
 
-	// Generate future "Total Remaining" events based on the configuration
-	generateFutureTotalRemainingEvents(srv, config)
+	// Diff the calendar against the desired events so only what differs gets written. Optional event
+	// types are always loaded so their events are removed once they are turned off.
+	actual, err := loadTotalRemainingEvents(ctx, srv, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve events: %v", err)
+	}
+	for _, eventType := range []string{periodBandEventType, paydaySummaryEventType, breakdownEventType, budgetAlertEventType} {
+		items, err := loadManagedEvents(ctx, srv, config, eventType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve events: %v", err)
+		}
+		actual = append(actual, items...)
+	}
+	var extra []desiredEvent
+	if config.PeriodBands {
+		extra = append(extra, periodBandEvents(desired, config)...)
+	}
+	if config.PaydaySummary {
+		extra = append(extra, paydaySummaryEvents(desired, config)...)
+	}
+	if config.Breakdown != "" {
+		extra = append(extra, breakdownEvents(desired, config)...)
+	}
+	for _, alert := range budgetAlertEvents(desired, config) {
+		slog.Warn("Budget alert", "date", alert.Event.Start.Date, "details", strings.ReplaceAll(alert.Event.Description, "\n", ", "))
+		extra = append(extra, alert)
+	}
+	desired = append(desired, extra...)
+	addChangelogs(desired, actual, config, now)
+	changes, drift := planEventChanges(desired, actual, config)
+	if config.DriftPolicy != driftPreserve {
+		for _, d := range drift {
+			slog.Warn("Event edited by hand", "drift", describeDrift(d, config))
+		}
+	}
+	return &Plan{
+		CreatedAt:      now,
+		TargetCalendar: config.TargetCalendar,
+		Changes:        changes,
+		Drift:          drift,
+		Period:         startDate.Format("2006-01-02") + "/" + endDate.Format("2006-01-02"),
+		Remaining:      roundAmount(total.Remaining(config)),
+		periods:        periods,
+		actual:         actual,
+	}, nil
 }
 
+const usage = `Usage: paymentTracker [command] [flags]
+
+Commands:
+  run          sync on the RUN_TIMER interval until stopped (default)
+  once         sync once and exit, -plan-only saves the changes to review instead
+  apply        apply a plan saved by -plan-only, -plan <file>
+  auth         authorize access to the calendar service and save the token,
+               -status shows when the saved token was authorized and expires,
+               -account <name> picks one of the GOOGLE_ACCOUNTS
+  report       print the payments and total of the current pay period
+  forecast     print the totals of the coming pay periods, -periods <n>
+  selftest     check the OAuth setup and configuration end to end on a throwaway calendar
+  backtest     re-parse the archived events and report differences, -archive <dir> -latest
+  explain      explain whether and how a payment event counts, explain <event ID>
+               or break down the totals of a month, explain -month YYYY-MM
+  maintenance  pause or resume calendar writes, on|off|status
+  timezones    list the accepted TIME_ZONE values, with an optional filter
+`
+
 func main() {
+	setupLogging()
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "run":
+		err = runLoopCommand(args)
+	case "once":
+		err = runOnceCommand(args)
+	case "apply":
+		err = runApplyCommand(args)
+	case "auth":
+		err = runAuthCommand(args)
+	case "report":
+		err = runReportCommand(args)
+	case "forecast":
+		err = runForecastCommand(args)
+	case "selftest":
+		err = runSelfTestCommand(args)
+	case "backtest":
+		err = runBacktestCommand(args)
+	case "explain":
+		err = runExplainCommand(args)
+	case "maintenance":
+		err = runMaintenanceCommand(args)
+	case "timezones":
+		runTimeZonesCommand(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		err = fmt.Errorf("unknown command %q", command)
+	}
+	if err != nil {
+		slog.Error("Command failed", "command", command, "error", err)
+		os.Exit(1)
+	}
+}
+
+// parsePlanFlags registers the flags shared by run and once for saving or logging a plan instead of
+// applying it. -dry-run is passed on as DRY_RUN, since the configuration is read from the environment.
+func parsePlanFlags(fs *flag.FlagSet, args []string) (planOnly bool, planOut string) {
+	fs.BoolVar(&planOnly, "plan-only", false, "compute the calendar changes, save them to -plan-out and exit without writing")
+	fs.StringVar(&planOut, "plan-out", "plan.json", "file the plan is written to with -plan-only")
+	dryRun := fs.Bool("dry-run", false, "log the calendar changes each run would make instead of making them")
+	fs.Parse(args)
+	if *dryRun {
+		os.Setenv("DRY_RUN", "true")
+	}
+	return planOnly, planOut
+}
+
+// runOnceCommand handles "once": a single sync, for cron jobs and debugging.
+func runOnceCommand(args []string) error {
+	planOnly, planOut := parsePlanFlags(flag.NewFlagSet("once", flag.ExitOnError), args)
+	if planOnly {
+		return runPlanOnly(planOut)
+	}
+	if warning := tokenExpiryWarning(getConfig(), time.Now()); warning != "" {
+		slog.Warn(warning)
+	}
+	err := taskToRun(shutdownContext(), newConnection(nil))
+	if err != nil {
+		notify(getConfig(), notifyFailures, "Sync failed", err.Error())
+	}
+	return err
+}
+
+// runLoopCommand handles "run": a sync every RUN_TIMER minutes until the process is stopped.
+func runLoopCommand(args []string) error {
+	planOnly, planOut := parsePlanFlags(flag.NewFlagSet("run", flag.ExitOnError), args)
+	if planOnly {
+		return runPlanOnly(planOut)
+	}
+
 	config := getConfig() // Get configuration from environment variables
+	ctx := shutdownContext()
+
+	// The run loop connects once and shares the connection with the dashboard and API
+	monitor := &quotaMonitor{}
+	conn := newConnection(monitor)
+
+	health := &healthState{config: config, monitor: monitor}
+	if config.HealthAddr != "" {
+		startHealthServer(config.HealthAddr, health)
+	}
+	if config.DashboardAddr != "" {
+		startDashboardServer(config.DashboardAddr, health, conn)
+	}
+	recalculate := make(chan struct{}, 1)
+	if config.APIAddr != "" {
+		startAPIServer(config, conn, recalculate)
+	}
 
-	ticker := time.NewTicker(config.TickInterval)
+	// With a public endpoint, payment calendar changes trigger a run straight away and polling
+	// only catches what the notifications miss
+	var watcher *calendarWatcher
+	if config.WebhookURL != "" {
+		var err error
+		if watcher, err = newCalendarWatcher(config.WebhookURL); err != nil {
+			return err
+		}
+		startWebhookServer(config.WebhookAddr, watcher)
+	}
+
+	tokens := &tokenWatch{}
+	interval := config.TickInterval
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for ; true; <-ticker.C {
-		taskToRun()
+	failing := false
+	var prefetch *prefetchedRun
+	atBoundary := false
+	for {
+		var err error
+		if atBoundary {
+			err = runAtBoundary(ctx, conn, prefetch)
+		} else {
+			err = taskToRun(ctx, conn)
+		}
+		atBoundary = false
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			slog.Error("Run failed", "error", err)
+		}
+		// Notify when runs start failing and when they recover, not on every failed run
+		switch {
+		case err != nil && !failing:
+			notify(config, notifyFailures, "Sync failed", err.Error())
+		case err == nil && failing:
+			notify(config, notifyFailures, "Sync recovered", "Syncs are succeeding again.")
+		}
+		failing = err != nil
+
+		// Back off while the Calendar API reports quota pressure, recover gradually once it doesn't
+		if next := adaptTickInterval(interval, monitor.take(), config); next != interval {
+			slog.Info("Adjusting run interval", "from", interval, "to", next)
+			interval = next
+			ticker.Reset(interval)
+		}
+		health.recordRun(err, interval)
+		tokens.check(config, time.Now())
+
+		// When the pay period ends before the next tick, the next period's plan is computed ahead of
+		// time and written right at the boundary rather than up to an interval later
+		var boundary *time.Timer
+		var reached <-chan time.Time
+		if next := nextPeriodStart(config, time.Now()); time.Until(next) < interval {
+			if prefetch == nil || !prefetch.boundary.Equal(next) {
+				prefetch = startPrefetch(ctx, conn, next)
+			}
+			boundary = time.NewTimer(time.Until(next))
+			reached = boundary.C
+		}
+
+		var trigger <-chan struct{}
+		if watcher != nil {
+			if err := watcher.renew(ctx, conn, 2*interval); err != nil {
+				slog.Warn("Error watching payment calendars, relying on polling", "error", err)
+			}
+			trigger = watcher.trigger
+		}
+		select {
+		case <-ticker.C:
+		case <-trigger:
+			slog.Info("Payment calendar changed, running now")
+			watcher.settle()
+		case <-recalculate:
+			slog.Info("Recalculation requested through the API, running now")
+		case <-reached:
+			atBoundary = true
+		case <-ctx.Done():
+		}
+		if boundary != nil {
+			boundary.Stop()
+		}
+		if ctx.Err() != nil {
+			break
+		}
 	}
+
+	if watcher != nil {
+		watcher.stop()
+	}
+	slog.Info("Stopped")
+	return nil
 }
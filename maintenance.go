@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaintenanceWindow is a period during which the tracker must not write to the calendar.
+type MaintenanceWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// parseMaintenanceWindows reads windows in the format "2024-08-01T09:00:00Z/2024-08-01T18:00:00Z,...".
+func parseMaintenanceWindows(value string) []MaintenanceWindow {
+	var windows []MaintenanceWindow
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		startStr, endStr, found := strings.Cut(entry, "/")
+		start, startErr := time.Parse(time.RFC3339, startStr)
+		end, endErr := time.Parse(time.RFC3339, endStr)
+		if !found || startErr != nil || endErr != nil || !end.After(start) {
+			slog.Warn("Ignoring invalid MAINTENANCE_WINDOWS entry, expected RFC3339 start/end", "entry", entry)
+			continue
+		}
+		windows = append(windows, MaintenanceWindow{Start: start, End: end})
+	}
+	return windows
+}
+
+func getMaintenanceFlagPath() string {
+	if path, exists := os.LookupEnv("MAINTENANCE_FLAG_PATH"); exists {
+		return path
+	}
+	return "maintenance.flag" // Default flag file location
+}
+
+// writesPaused reports whether calendar writes are currently suspended, either by a
+// configured maintenance window or by the maintenance flag file being present.
+func writesPaused(config Config) bool {
+	if _, err := os.Stat(getMaintenanceFlagPath()); err == nil {
+		return true
+	}
+	now := time.Now()
+	for _, window := range config.MaintenanceWindows {
+		if !now.Before(window.Start) && now.Before(window.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// setMaintenanceFlag creates the maintenance flag file at path to pause calendar writes, or removes it
+// to resume them. Maintenance windows still pause writes without it.
+func setMaintenanceFlag(path string, on bool) error {
+	if !on {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove maintenance flag: %v", err)
+		}
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create maintenance flag: %v", err)
+	}
+	return f.Close()
+}
+
+// pausedAnnouncements holds what was announced while writes are paused. The calendar keeps showing
+// the amounts from before the pause, so without it every run would announce the same changes again.
+var pausedAnnouncements = struct {
+	sync.Mutex
+	keys map[string]bool
+}{keys: make(map[string]bool)}
+
+// firstAnnouncement reports whether the notification or trigger identified by key should go out:
+// always while writes go ahead, and only the first time while they are paused.
+func firstAnnouncement(config Config, key string) bool {
+	pausedAnnouncements.Lock()
+	defer pausedAnnouncements.Unlock()
+	if !writesPaused(config) {
+		clear(pausedAnnouncements.keys)
+		return true
+	}
+	if pausedAnnouncements.keys[key] {
+		return false
+	}
+	pausedAnnouncements.keys[key] = true
+	return true
+}
+
+// runMaintenanceCommand handles "maintenance on|off|status" by creating or removing the flag file.
+func runMaintenanceCommand(args []string) error {
+	path := getMaintenanceFlagPath()
+	if len(args) != 1 {
+		return fmt.Errorf("usage: paymentTracker maintenance on|off|status")
+	}
+
+	switch args[0] {
+	case "on", "off":
+		if err := setMaintenanceFlag(path, args[0] == "on"); err != nil {
+			return err
+		}
+		if args[0] == "on" {
+			fmt.Println("Maintenance mode enabled, calendar writes are paused")
+		} else {
+			fmt.Println("Maintenance mode disabled")
+		}
+	case "status":
+		if writesPaused(getConfig()) {
+			fmt.Println("Calendar writes are paused")
+		} else {
+			fmt.Println("Calendar writes are enabled")
+		}
+	default:
+		return fmt.Errorf("unknown maintenance action %q, expected on, off or status", args[0])
+	}
+	return nil
+}
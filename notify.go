@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Occasions a notification is sent on, chosen with NOTIFY_ON.
+const (
+	notifyChanges  = "changes"  // A "Total Remaining" amount changed
+	notifyBudget   = "budget"   // A pay period went over budget
+	notifyFailures = "failures" // Runs started failing, or recovered
+	notifyToken    = "token"    // The calendar token expires soon
+	notifyGifts    = "gifts"    // A gift occasion is coming up
+)
+
+var notifyKinds = []string{notifyChanges, notifyBudget, notifyFailures, notifyToken, notifyGifts}
+
+// notifyTimeout bounds each notification, so an unreachable service does not hold up a run.
+const notifyTimeout = 10 * time.Second
+
+// Notifier delivers a short message to the user through some outside service.
+type Notifier interface {
+	Notify(subject, message string) error
+}
+
+// loadNotifiers returns a notifier for each service configured with NOTIFY_* variables.
+func loadNotifiers() []Notifier {
+	var notifiers []Notifier
+	if to := os.Getenv("NOTIFY_EMAIL_TO"); to != "" {
+		addr := os.Getenv("NOTIFY_SMTP_ADDR")
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			slog.Warn("Invalid NOTIFY_SMTP_ADDR value, expected host:port, email notifications are off", "value", addr)
+		} else {
+			recipients := strings.Split(to, ",")
+			for i := range recipients {
+				recipients[i] = strings.TrimSpace(recipients[i])
+			}
+			notifiers = append(notifiers, emailNotifier{
+				addr:     addr,
+				from:     os.Getenv("NOTIFY_EMAIL_FROM"),
+				to:       recipients,
+				user:     os.Getenv("NOTIFY_SMTP_USER"),
+				password: os.Getenv("NOTIFY_SMTP_PASSWORD"),
+			})
+		}
+	}
+	if webhook := os.Getenv("NOTIFY_SLACK_WEBHOOK"); webhook != "" {
+		notifiers = append(notifiers, slackNotifier{webhook: webhook})
+	}
+	if token := os.Getenv("NOTIFY_TELEGRAM_TOKEN"); token != "" {
+		chatID := os.Getenv("NOTIFY_TELEGRAM_CHAT_ID")
+		if chatID == "" {
+			slog.Warn("NOTIFY_TELEGRAM_CHAT_ID is not set, Telegram notifications are off")
+		} else {
+			notifiers = append(notifiers, telegramNotifier{token: token, chatID: chatID})
+		}
+	}
+	if topic := os.Getenv("NOTIFY_NTFY_TOPIC"); topic != "" {
+		if !strings.Contains(topic, "://") {
+			topic = "https://ntfy.sh/" + topic
+		}
+		notifiers = append(notifiers, ntfyNotifier{url: topic, token: os.Getenv("NOTIFY_NTFY_TOKEN")})
+	}
+	return notifiers
+}
+
+// parseNotifyOn reads the comma separated occasions to notify on, all of them when empty.
+func parseNotifyOn(value string) map[string]bool {
+	on := make(map[string]bool)
+	if strings.TrimSpace(value) == "" {
+		value = strings.Join(notifyKinds, ",")
+	}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch entry {
+		case "":
+		case notifyChanges, notifyBudget, notifyFailures, notifyToken, notifyGifts:
+			on[entry] = true
+		default:
+			slog.Warn("Invalid NOTIFY_ON entry, expected one of "+strings.Join(notifyKinds, ", "), "entry", entry)
+		}
+	}
+	return on
+}
+
+// notify sends a message through every configured notifier when notifications are on for its
+// kind. Failures are logged rather than returned, so they never fail a run.
+func notify(config Config, kind, subject, message string) {
+	if !config.NotifyOn[kind] {
+		return
+	}
+	for _, notifier := range config.Notifiers {
+		if err := notifier.Notify(subject, message); err != nil {
+			slog.Error("Error sending notification", "notifier", fmt.Sprintf("%T", notifier), "error", err)
+		}
+	}
+}
+
+// notifyAppliedChanges reports the "Total Remaining" amounts and budget alerts a sync wrote. While
+// writes are paused they are still reported, once each, though the calendar only catches up after.
+func notifyAppliedChanges(changes []eventChange, config Config) {
+	if len(config.Notifiers) == 0 || config.DryRun {
+		return
+	}
+	var totals, alerts []string
+	for _, change := range changes {
+		if change.Event == nil {
+			continue
+		}
+		amount, ok := eventAmount(change.Event, config)
+		if !ok {
+			continue
+		}
+		date := change.Event.Start.Date
+		if change.Existing != nil {
+			date = change.Existing.Start.Date
+		}
+		switch {
+		case isManagedEvent(change.Event, budgetAlertEventType):
+			alerts = append(alerts, fmt.Sprintf("%s: over budget by %s\n%s", date, config.Currency.Format(amount), change.Event.Description))
+		case change.Existing == nil && isManagedEvent(change.Event, totalRemainingEventType):
+			totals = append(totals, fmt.Sprintf("%s: %s", date, config.Currency.Format(amount)))
+		case isManagedEvent(change.Event, totalRemainingEventType):
+			if previous, ok := eventAmount(change.Existing, config); !ok || math.Abs(previous-amount) > config.WriteThreshold {
+				totals = append(totals, fmt.Sprintf("%s: %s, was %s", date, config.Currency.Format(amount), config.Currency.Format(previous)))
+			}
+		}
+	}
+	totals, alerts = firstAnnouncements(config, totals), firstAnnouncements(config, alerts)
+	if len(totals) > 0 {
+		notify(config, notifyChanges, config.EventLabel+" changed", strings.Join(totals, "\n"))
+	}
+	if len(alerts) > 0 {
+		notify(config, notifyBudget, "Over budget", strings.Join(alerts, "\n\n"))
+	}
+}
+
+// firstAnnouncements returns the lines not yet announced while writes are paused.
+func firstAnnouncements(config Config, lines []string) []string {
+	var first []string
+	for _, line := range lines {
+		if firstAnnouncement(config, "notify:"+line) {
+			first = append(first, line)
+		}
+	}
+	return first
+}
+
+// emailNotifier sends notifications by email through an SMTP server.
+type emailNotifier struct {
+	addr     string // host:port of the SMTP server
+	from     string
+	to       []string
+	user     string // Empty to send without authenticating
+	password string
+}
+
+func (e emailNotifier) Notify(subject, message string) error {
+	var auth smtp.Auth
+	if e.user != "" {
+		host, _, _ := net.SplitHostPort(e.addr)
+		auth = smtp.PlainAuth("", e.user, e.password, host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(message, "\n", "\r\n"))
+	return e.send(auth, msg.Bytes())
+}
+
+// send delivers msg the way smtp.SendMail does, but within notifyTimeout, so a server that accepts
+// the connection and then stalls cannot hold up a run.
+func (e emailNotifier) send(auth smtp.Auth, msg []byte) error {
+	conn, err := (&net.Dialer{Timeout: notifyTimeout}).Dial("tcp", e.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(notifyTimeout)); err != nil {
+		return err
+	}
+	host, _, _ := net.SplitHostPort(e.addr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("the SMTP server does not support authentication")
+		}
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(e.from); err != nil {
+		return err
+	}
+	for _, to := range e.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// slackNotifier posts notifications to a Slack incoming webhook.
+type slackNotifier struct {
+	webhook string
+}
+
+func (s slackNotifier) Notify(subject, message string) error {
+	body, err := json.Marshal(map[string]string{"text": "*" + subject + "*\n" + message})
+	if err != nil {
+		return err
+	}
+	return postNotification(s.webhook, "application/json", bytes.NewReader(body), nil)
+}
+
+// telegramNotifier sends notifications to a Telegram chat through a bot.
+type telegramNotifier struct {
+	token  string
+	chatID string
+}
+
+func (t telegramNotifier) Notify(subject, message string) error {
+	form := url.Values{"chat_id": {t.chatID}, "text": {subject + "\n" + message}}
+	endpoint := "https://api.telegram.org/bot" + t.token + "/sendMessage"
+	return postNotification(endpoint, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()), nil)
+}
+
+// ntfyNotifier publishes notifications to an ntfy topic, on ntfy.sh or a self-hosted server.
+type ntfyNotifier struct {
+	url   string
+	token string // Access token for protected topics, empty for public ones
+}
+
+func (n ntfyNotifier) Notify(subject, message string) error {
+	header := http.Header{"Title": {mime.QEncoding.Encode("utf-8", subject)}}
+	if n.token != "" {
+		header.Set("Authorization", "Bearer "+n.token)
+	}
+	return postNotification(n.url, "text/plain; charset=utf-8", strings.NewReader(message), header)
+}
+
+// postNotification posts a notification body to a service, treating any non-2xx reply as a failure.
+func postNotification(endpoint, contentType string, body io.Reader, header http.Header) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, body)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		// Leave the URL out of the error, webhook URLs and bot tokens are secrets
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
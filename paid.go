@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Defaults for recognising payments that have been made. Colour "10" is basil, the green of the
+// default Google palette.
+const (
+	defaultPaidColor  = "10"
+	defaultPaidMarker = "✅"
+)
+
+// isPaid reports whether a payment event has been marked as paid, either with PAID_COLOR or by
+// PAID_MARKER appearing in its title.
+func isPaid(item *calendar.Event, config Config) bool {
+	if config.PaidColor != "" && item.ColorId == config.PaidColor {
+		return true
+	}
+	return config.PaidMarker != "" && strings.Contains(item.Summary, config.PaidMarker)
+}
+
+// markedPaid returns the patch marking a payment event as paid: the paid colour when one is
+// configured, or otherwise the paid marker appended to its title.
+func markedPaid(item *calendar.Event, config Config) *calendar.Event {
+	if config.PaidColor != "" {
+		return &calendar.Event{ColorId: config.PaidColor}
+	}
+	return &calendar.Event{Summary: strings.TrimSpace(item.Summary) + " " + config.PaidMarker}
+}
+
+// markPastPayments marks the payment events of the current pay period that have already happened
+// as paid. Earlier periods are left alone, so enabling MARK_PAID does not rewrite old history.
+func markPastPayments(ctx context.Context, srv CalendarProvider, config Config, now time.Time) error {
+	startDate, _ := config.Periods.Period(now)
+	for _, calendarID := range config.PaymentCalendars {
+		if !canWriteEvents(config, calendarID) {
+			continue
+		}
+		events, err := srv.ListEvents(ctx, calendarID, EventQuery{Text: "Payment", TimeMin: startDate, TimeMax: now})
+		if err != nil {
+			return fmt.Errorf("unable to list past payments in calendar %s: %v", calendarID, err)
+		}
+		var due []*calendar.Event
+		for _, item := range events {
+			if _, end := eventBounds(item, now.Location()); end.After(now) {
+				continue
+			}
+			if _, ok := parseAmountFromSummary(item.Summary, config.Currency); !ok {
+				continue
+			}
+			if statusSkipReason(item, config) == "" && !isPaid(item, config) {
+				due = append(due, item)
+			}
+		}
+
+		for _, item := range due {
+			switch {
+			case config.DryRun:
+				slog.Info("Dry run, would mark payment as paid", "summary", item.Summary, "event", item.Id)
+				continue
+			case writesPaused(config):
+				slog.Info("Maintenance active, not marking payment as paid", "summary", item.Summary, "event", item.Id)
+				continue
+			}
+			err := srv.PatchEvent(ctx, calendarID, item.Id, item.Etag, markedPaid(item, config))
+			if isAccessDenied(err) {
+				denyWrites(config, calendarID)
+				slog.Warn("Calendar is read only, its payments are counted but not marked as paid", "calendar", calendarID)
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("unable to mark %q (event %s) as paid: %v", item.Summary, item.Id, err)
+			}
+			slog.Info("Marked payment as paid", "summary", item.Summary, "event", item.Id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// paydaySummaryEvents returns a payday summary for the pay period of each "Total Remaining" event.
+func paydaySummaryEvents(desired []desiredEvent, config Config) []desiredEvent {
+	var summaries []desiredEvent
+	for _, d := range desired {
+		if d.Period.Start.IsZero() || !isManagedEvent(d.Event, totalRemainingEventType) {
+			continue
+		}
+		event, safeToSpend := newPaydaySummaryEvent(d.Period, config)
+		summaries = append(summaries, desiredEvent{Event: event, Amount: safeToSpend})
+	}
+	return summaries
+}
+
+// newPaydaySummaryEvent builds the all-day event on the first day of a pay period that sums it up:
+// the income, every bill scheduled in it, the planned savings and what is safe to spend after them.
+func newPaydaySummaryEvent(period PeriodTotal, config Config) (*calendar.Event, float64) {
+	bills := period.Paid + period.Amount
+	safeToSpend := period.Income - bills - config.PlannedSavings
+
+	text := func(english string, amount float64) string {
+		return fmt.Sprintf(eventText(config.EventLanguage, english), config.Currency.Format(amount))
+	}
+	lines := []string{
+		text("Income %s", period.Income),
+		text("Bills scheduled %s", bills),
+	}
+	if config.PlannedSavings > 0 {
+		lines = append(lines, text("Planned savings %s", config.PlannedSavings))
+	}
+	lines = append(lines, text("Safe to spend %s", safeToSpend))
+	if breakdown := categoryBreakdown(period, config.Currency, config.EventLanguage); breakdown != "" {
+		lines = append(lines, "", breakdown)
+	}
+
+	zone := calendarTimeZone(config, config.TargetCalendar)
+	return &calendar.Event{
+		Summary:     text("Payday: safe to spend %s", safeToSpend),
+		Description: strings.Join(lines, "\n"),
+		Start: &calendar.EventDateTime{
+			Date:     period.Start.Format("2006-01-02"),
+			TimeZone: zone,
+		},
+		End: &calendar.EventDateTime{
+			Date:     period.Start.AddDate(0, 0, 1).Format("2006-01-02"),
+			TimeZone: zone,
+		},
+		ColorId: config.EventColor,
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{
+				managedEventProperty: paydaySummaryEventType,
+				amountProperty:       strconv.FormatFloat(safeToSpend, 'f', 2, 64),
+			},
+		},
+	}, safeToSpend
+}
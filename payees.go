@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// paymentPayees returns the people a payment is shared with: the event's attendees other than the
+// calendar owner and meeting rooms, by display name where they have one.
+func paymentPayees(item *calendar.Event) []string {
+	var payees []string
+	for _, attendee := range item.Attendees {
+		if attendee.Self || attendee.Resource {
+			continue
+		}
+		name := attendee.DisplayName
+		if name == "" {
+			name = attendee.Email
+		}
+		payees = append(payees, name)
+	}
+	return payees
+}
+
+// payeeShares splits each shared payment equally between the calendar owner and its payees and
+// returns what each payee's shares add up to.
+func payeeShares(events []*calendar.Event, currency Currency) map[string]float64 {
+	shares := make(map[string]float64)
+	for _, item := range events {
+		payees := paymentPayees(item)
+		if len(payees) == 0 {
+			continue
+		}
+		amount, ok := parseAmountFromSummary(item.Summary, currency)
+		if !ok {
+			continue
+		}
+		share := amount / float64(len(payees)+1)
+		for _, payee := range payees {
+			shares[payee] += share
+		}
+	}
+	return shares
+}
+
+// payeeBreakdown renders each payee's share one per line, e.g. "Alex's share £400.00", sorted by name.
+func payeeBreakdown(shares map[string]float64, currency Currency) []string {
+	names := make([]string, 0, len(shares))
+	for name := range shares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%s's share %s", name, currency.Format(shares[name])))
+	}
+	return lines
+}
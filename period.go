@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// daysInMonth returns the number of days in the given month, accounting for leap years.
+func daysInMonth(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// addMonths returns the year and month n months after the given one, wrapping across years.
+func addMonths(year int, month time.Month, n int) (int, time.Month) {
+	t := time.Date(year, month+time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+	return t.Year(), t.Month()
+}
+
+// dateInMonth returns midnight on the given day of the month. The day is clamped to the month's
+// length, so a pay date of 31 falls on 30 April and on 28 or 29 February instead of rolling over
+// into the next month. Months outside 1-12 are normalised first.
+func dateInMonth(year int, month time.Month, day int, loc *time.Location) time.Time {
+	year, month = addMonths(year, month, 0)
+	if n := daysInMonth(year, month); day > n {
+		day = n
+	}
+	if day < 1 {
+		day = 1
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// lastDayOfMonth returns midnight on the last day of the given month.
+func lastDayOfMonth(year int, month time.Month, loc *time.Location) time.Time {
+	return dateInMonth(year, month, daysInMonth(year, month), loc)
+}
+
+// getPaymentPeriodDates calculates the start and end dates for payment calculations based on the given year, month, and PayDate.
+func getPaymentPeriodDates(year int, month time.Month, payDate int, loc *time.Location) (startDate, endDate time.Time) {
+	startDate = dateInMonth(year, month, payDate, loc)
+	nextYear, nextMonth := addMonths(year, month, 1)
+	endDate = dateInMonth(nextYear, nextMonth, payDate, loc).Add(-time.Second)
+	return
+}
+
+// PeriodCalculator computes the pay period boundaries for a pay schedule.
+type PeriodCalculator interface {
+	// Period returns the pay period containing t, ending one second before the next period starts.
+	Period(t time.Time) (startDate, endDate time.Time)
+}
+
+// nextPeriod returns the pay period following the one ending at endDate.
+func nextPeriod(calc PeriodCalculator, endDate time.Time) (startDate, nextEndDate time.Time) {
+	return calc.Period(endDate.Add(time.Second))
+}
+
+// monthlyPeriods starts a new period on the same day every month.
+type monthlyPeriods struct {
+	payDate int
+	loc     *time.Location
+}
+
+func (m monthlyPeriods) Period(t time.Time) (startDate, endDate time.Time) {
+	t = t.In(m.loc)
+	year, month := t.Year(), t.Month()
+	if t.Before(dateInMonth(year, month, m.payDate, m.loc)) {
+		year, month = addMonths(year, month, -1)
+	}
+	return getPaymentPeriodDates(year, month, m.payDate, m.loc)
+}
+
+// fixedPeriods starts a new period every given number of days, counted from a known pay day.
+type fixedPeriods struct {
+	anchor time.Time
+	days   int
+}
+
+func (f fixedPeriods) Period(t time.Time) (startDate, endDate time.Time) {
+	periods := floorDiv(daysSince(f.anchor, t), f.days)
+	startDate = f.anchor.AddDate(0, 0, periods*f.days)
+	endDate = startDate.AddDate(0, 0, f.days).Add(-time.Second)
+	return
+}
+
+// daysSince returns the number of calendar days from anchor to t, negative when t is earlier. Whole
+// days are counted rather than hours so DST changes don't shift boundaries.
+func daysSince(anchor, t time.Time) int {
+	t = t.In(anchor.Location())
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	start := time.Date(anchor.Year(), anchor.Month(), anchor.Day(), 0, 0, 0, 0, time.UTC)
+	return int(day.Sub(start).Hours() / 24)
+}
+
+// floorDiv divides a by b, rounding towards negative infinity.
+func floorDiv(a, b int) int {
+	if a < 0 && a%b != 0 {
+		return a/b - 1
+	}
+	return a / b
+}
+
+// PeriodNamer is implemented by calculators whose periods have names, such as "P3" for the third
+// period of a 4-4-5 year.
+type PeriodNamer interface {
+	// PeriodName returns the name of the period starting at startDate, or "" when it has none.
+	PeriodName(startDate time.Time) string
+}
+
+// periodName returns the name of the period starting at startDate, or "" when the schedule does not
+// name its periods.
+func periodName(calc PeriodCalculator, startDate time.Time) string {
+	if namer, ok := calc.(PeriodNamer); ok {
+		return namer.PeriodName(startDate)
+	}
+	return ""
+}
+
+// formatPeriod describes a pay period for output, e.g. "P3, 2024-03-25 to 2024-04-28".
+func formatPeriod(calc PeriodCalculator, startDate, endDate time.Time) string {
+	dates := startDate.Format("2006-01-02") + " to " + endDate.Format("2006-01-02")
+	if name := periodName(calc, startDate); name != "" {
+		return name + ", " + dates
+	}
+	return dates
+}
+
+// weekPattern matches PAY_FREQUENCY values giving a repeating pattern of period lengths in weeks,
+// such as 4-4-5.
+var weekPattern = regexp.MustCompile(`^[0-9]+(-[0-9]+)+$`)
+
+// patternPeriods repeats a pattern of period lengths in weeks, such as the 4-4-5 quarters some
+// employers pay on, counted from a day on which the pattern starts. Periods are named P1, P2 and so
+// on through a 52 week year when the pattern fits it evenly, or through the pattern otherwise.
+type patternPeriods struct {
+	anchor time.Time
+	weeks  []int
+}
+
+// locate returns the period containing t and its position counted from the anchor.
+func (p patternPeriods) locate(t time.Time) (startDate, endDate time.Time, index int) {
+	cycleDays := 0
+	for _, w := range p.weeks {
+		cycleDays += w * 7
+	}
+	elapsed := daysSince(p.anchor, t)
+	cycles := floorDiv(elapsed, cycleDays)
+	offset := elapsed - cycles*cycleDays
+	days := cycles * cycleDays
+	for i, w := range p.weeks {
+		if offset < w*7 || i == len(p.weeks)-1 {
+			startDate = p.anchor.AddDate(0, 0, days)
+			endDate = startDate.AddDate(0, 0, w*7).Add(-time.Second)
+			return startDate, endDate, cycles*len(p.weeks) + i
+		}
+		offset -= w * 7
+		days += w * 7
+	}
+	return
+}
+
+func (p patternPeriods) Period(t time.Time) (startDate, endDate time.Time) {
+	startDate, endDate, _ = p.locate(t)
+	return
+}
+
+func (p patternPeriods) PeriodName(startDate time.Time) string {
+	_, _, index := p.locate(startDate)
+	perYear := len(p.weeks)
+	cycleWeeks := 0
+	for _, w := range p.weeks {
+		cycleWeeks += w
+	}
+	if 52%cycleWeeks == 0 {
+		perYear *= 52 / cycleWeeks
+	}
+	return fmt.Sprintf("P%d", (index%perYear+perYear)%perYear+1)
+}
+
+// customPeriods starts a period on each of a list of dates, optionally named. Before the first date
+// and after the last, periods repeat the length of the first and last listed period respectively,
+// unnamed, so the forecast keeps working while the list is extended.
+type customPeriods struct {
+	starts []time.Time // Sorted, at least two
+	names  []string    // Name of the period at the same index, "" for none
+}
+
+func (c customPeriods) locate(t time.Time) (startDate, endDate time.Time, index int) {
+	index = sort.Search(len(c.starts), func(i int) bool { return c.starts[i].After(t) }) - 1
+	last := len(c.starts) - 1
+	switch {
+	case index < 0:
+		startDate, endDate = fixedPeriods{anchor: c.starts[0], days: daysSince(c.starts[0], c.starts[1])}.Period(t)
+	case index == last:
+		startDate, endDate = fixedPeriods{anchor: c.starts[last], days: daysSince(c.starts[last-1], c.starts[last])}.Period(t)
+		if !startDate.Equal(c.starts[last]) {
+			index = -1
+		}
+	default:
+		startDate, endDate = c.starts[index], c.starts[index+1].Add(-time.Second)
+	}
+	return
+}
+
+func (c customPeriods) Period(t time.Time) (startDate, endDate time.Time) {
+	startDate, endDate, _ = c.locate(t)
+	return
+}
+
+func (c customPeriods) PeriodName(startDate time.Time) string {
+	if _, _, index := c.locate(startDate); index >= 0 {
+		return c.names[index]
+	}
+	return ""
+}
+
+// parsePeriodDates reads PAY_PERIOD_DATES, a comma separated list of the dates periods start on in
+// YYYY-MM-DD format, each optionally followed by "=name", e.g. "2024-01-01=P1,2024-01-29=P2".
+func parsePeriodDates(value string, loc *time.Location) (customPeriods, error) {
+	var periods customPeriods
+	type entry struct {
+		start time.Time
+		name  string
+	}
+	var entries []entry
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		date, name, _ := strings.Cut(field, "=")
+		start, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(date), loc)
+		if err != nil {
+			return periods, fmt.Errorf("invalid PAY_PERIOD_DATES entry %q, expected YYYY-MM-DD or YYYY-MM-DD=name", field)
+		}
+		entries = append(entries, entry{start: start, name: strings.TrimSpace(name)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].start.Before(entries[j].start) })
+	for i, e := range entries {
+		if i > 0 && e.start.Equal(entries[i-1].start) {
+			return periods, fmt.Errorf("PAY_PERIOD_DATES lists %s twice", e.start.Format("2006-01-02"))
+		}
+		periods.starts = append(periods.starts, e.start)
+		periods.names = append(periods.names, e.name)
+	}
+	if len(periods.starts) < 2 {
+		return periods, fmt.Errorf("PAY_PERIOD_DATES must list at least two dates for custom pay periods")
+	}
+	return periods, nil
+}
+
+// newPeriodCalculator returns the calculator for PAY_FREQUENCY. Weekly schedules and week patterns
+// such as 4-4-5 are counted from PAY_ANCHOR_DATE, a date on which the user was paid or, for a
+// pattern, on which its first period starts. Custom periods start on the PAY_PERIOD_DATES.
+func newPeriodCalculator(frequency string, payDate int, anchorDate, periodDates string, loc *time.Location) (PeriodCalculator, error) {
+	days := map[string]int{"weekly": 7, "biweekly": 14, "4-weekly": 28}
+	switch {
+	case frequency == "" || frequency == "monthly":
+		return monthlyPeriods{payDate: payDate, loc: loc}, nil
+	case frequency == "custom":
+		return parsePeriodDates(periodDates, loc)
+	case days[frequency] > 0 || weekPattern.MatchString(frequency):
+		anchor, err := time.ParseInLocation("2006-01-02", anchorDate, loc)
+		if err != nil {
+			return nil, fmt.Errorf("PAY_ANCHOR_DATE must be a pay day in YYYY-MM-DD format for %s pay: %v", frequency, err)
+		}
+		if days[frequency] > 0 {
+			return fixedPeriods{anchor: anchor, days: days[frequency]}, nil
+		}
+		var weeks []int
+		for _, field := range strings.Split(frequency, "-") {
+			n, err := strconv.Atoi(field)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid PAY_FREQUENCY value: %v, pattern lengths are whole weeks", frequency)
+			}
+			weeks = append(weeks, n)
+		}
+		return patternPeriods{anchor: anchor, weeks: weeks}, nil
+	default:
+		return nil, fmt.Errorf("invalid PAY_FREQUENCY value: %v", frequency)
+	}
+}
+
+// getTotalRemainingEventDate returns the day within the period that its "Total Remaining" event is placed on.
+// When the period contains no first or last day of a month (weekly pay), the period's own first or last day is used.
+func getTotalRemainingEventDate(startDate, endDate time.Time, config Config) (time.Time, error) {
+	loc := startDate.Location()
+	lastDay := time.Date(endDate.Year(), endDate.Month(), endDate.Day(), 0, 0, 0, 0, loc)
+
+	switch config.TotalRemainingOn {
+	case "Last Day of the Month":
+		eventDate := lastDayOfMonth(endDate.Year(), endDate.Month(), loc)
+		if eventDate.After(lastDay) {
+			year, month := addMonths(endDate.Year(), endDate.Month(), -1)
+			eventDate = lastDayOfMonth(year, month, loc)
+		}
+		if eventDate.Before(startDate) {
+			eventDate = lastDay
+		}
+		return eventDate, nil
+	case "First Day of the Month":
+		eventDate := dateInMonth(startDate.Year(), startDate.Month(), 1, loc)
+		if eventDate.Before(startDate) {
+			year, month := addMonths(startDate.Year(), startDate.Month(), 1)
+			eventDate = dateInMonth(year, month, 1, loc)
+		}
+		if eventDate.After(lastDay) {
+			eventDate = startDate
+		}
+		return eventDate, nil
+	case "Pay Date":
+		return startDate, nil
+	default:
+		return time.Time{}, fmt.Errorf("invalid TotalRemainingOn value: %v", config.TotalRemainingOn)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// mustLoadLocation loads a time zone, failing the test when the zone database lacks it.
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("loading time zone %s: %v", name, err)
+	}
+	return loc
+}
+
+// date returns midnight on the given day in loc.
+func date(year int, month time.Month, day int, loc *time.Location) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// periodEnd returns the last second of the given day in loc, where pay periods end.
+func periodEnd(year int, month time.Month, day int, loc *time.Location) time.Time {
+	return time.Date(year, month, day, 23, 59, 59, 0, loc)
+}
+
+func TestDateInMonth(t *testing.T) {
+	tests := []struct {
+		name  string
+		year  int
+		month time.Month
+		day   int
+		want  time.Time
+	}{
+		{"ordinary day", 2024, time.March, 15, date(2024, time.March, 15, time.UTC)},
+		{"31st of a 31 day month", 2024, time.January, 31, date(2024, time.January, 31, time.UTC)},
+		{"31st of a 30 day month", 2024, time.April, 31, date(2024, time.April, 30, time.UTC)},
+		{"31st of February in a leap year", 2024, time.February, 31, date(2024, time.February, 29, time.UTC)},
+		{"29th of February in a leap year", 2024, time.February, 29, date(2024, time.February, 29, time.UTC)},
+		{"29th of February in a common year", 2023, time.February, 29, date(2023, time.February, 28, time.UTC)},
+		{"30th of February in a common year", 2023, time.February, 30, date(2023, time.February, 28, time.UTC)},
+		{"day below 1", 2024, time.June, 0, date(2024, time.June, 1, time.UTC)},
+		{"month after December", 2024, 13, 31, date(2025, time.January, 31, time.UTC)},
+		{"month before January", 2024, 0, 31, date(2023, time.December, 31, time.UTC)},
+		{"February of the following year", 2023, 14, 30, date(2024, time.February, 29, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dateInMonth(tt.year, tt.month, tt.day, time.UTC); !got.Equal(tt.want) {
+				t.Errorf("dateInMonth(%d, %d, %d) = %v, want %v", tt.year, tt.month, tt.day, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMonthlyPeriods(t *testing.T) {
+	london := mustLoadLocation(t, "Europe/London")
+	tests := []struct {
+		name      string
+		payDate   int
+		at        time.Time
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "after the pay date",
+			payDate:   25,
+			at:        time.Date(2024, time.June, 28, 12, 0, 0, 0, london),
+			wantStart: date(2024, time.June, 25, london),
+			wantEnd:   periodEnd(2024, time.July, 24, london),
+		},
+		{
+			name:      "before the pay date",
+			payDate:   25,
+			at:        time.Date(2024, time.June, 10, 12, 0, 0, 0, london),
+			wantStart: date(2024, time.May, 25, london),
+			wantEnd:   periodEnd(2024, time.June, 24, london),
+		},
+		{
+			name:      "on the pay date",
+			payDate:   25,
+			at:        date(2024, time.June, 25, london),
+			wantStart: date(2024, time.June, 25, london),
+			wantEnd:   periodEnd(2024, time.July, 24, london),
+		},
+		{
+			name:      "last second of a period",
+			payDate:   25,
+			at:        periodEnd(2024, time.June, 24, london),
+			wantStart: date(2024, time.May, 25, london),
+			wantEnd:   periodEnd(2024, time.June, 24, london),
+		},
+		{
+			name:      "December into January",
+			payDate:   25,
+			at:        time.Date(2024, time.December, 30, 12, 0, 0, 0, london),
+			wantStart: date(2024, time.December, 25, london),
+			wantEnd:   periodEnd(2025, time.January, 24, london),
+		},
+		{
+			name:      "January back into December",
+			payDate:   25,
+			at:        time.Date(2025, time.January, 10, 12, 0, 0, 0, london),
+			wantStart: date(2024, time.December, 25, london),
+			wantEnd:   periodEnd(2025, time.January, 24, london),
+		},
+		{
+			name:      "pay date 31 ending in a leap February",
+			payDate:   31,
+			at:        time.Date(2024, time.February, 15, 12, 0, 0, 0, london),
+			wantStart: date(2024, time.January, 31, london),
+			wantEnd:   periodEnd(2024, time.February, 28, london),
+		},
+		{
+			name:      "pay date 31 clamped to the 29th of February",
+			payDate:   31,
+			at:        time.Date(2024, time.February, 29, 12, 0, 0, 0, london),
+			wantStart: date(2024, time.February, 29, london),
+			wantEnd:   periodEnd(2024, time.March, 30, london),
+		},
+		{
+			name:      "pay date 31 clamped to the 30th of April",
+			payDate:   31,
+			at:        time.Date(2024, time.May, 5, 12, 0, 0, 0, london),
+			wantStart: date(2024, time.April, 30, london),
+			wantEnd:   periodEnd(2024, time.May, 30, london),
+		},
+		{
+			name:      "period spanning the start of summer time",
+			payDate:   25,
+			at:        time.Date(2024, time.April, 2, 12, 0, 0, 0, london),
+			wantStart: date(2024, time.March, 25, london),
+			wantEnd:   periodEnd(2024, time.April, 24, london),
+		},
+		{
+			name:      "UTC time already on the pay date in summer time",
+			payDate:   25,
+			at:        time.Date(2024, time.October, 24, 23, 30, 0, 0, time.UTC),
+			wantStart: date(2024, time.October, 25, london),
+			wantEnd:   periodEnd(2024, time.November, 24, london),
+		},
+		{
+			name:      "UTC time still before the pay date in winter time",
+			payDate:   25,
+			at:        time.Date(2024, time.March, 24, 23, 30, 0, 0, time.UTC),
+			wantStart: date(2024, time.February, 25, london),
+			wantEnd:   periodEnd(2024, time.March, 24, london),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := monthlyPeriods{payDate: tt.payDate, loc: london}.Period(tt.at)
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Errorf("Period(%v) = %v to %v, want %v to %v", tt.at, start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestFixedPeriods(t *testing.T) {
+	london := mustLoadLocation(t, "Europe/London")
+	biweekly := fixedPeriods{anchor: date(2024, time.January, 5, london), days: 14}
+	tests := []struct {
+		name      string
+		at        time.Time
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{"on the anchor", date(2024, time.January, 5, london), date(2024, time.January, 5, london), periodEnd(2024, time.January, 18, london)},
+		{"last day of the first period", periodEnd(2024, time.January, 18, london), date(2024, time.January, 5, london), periodEnd(2024, time.January, 18, london)},
+		{"before the anchor", date(2024, time.January, 4, london), date(2023, time.December, 22, london), periodEnd(2024, time.January, 4, london)},
+		{"across the year end", date(2023, time.December, 31, london), date(2023, time.December, 22, london), periodEnd(2024, time.January, 4, london)},
+		{"after the start of summer time", time.Date(2024, time.April, 1, 12, 0, 0, 0, london), date(2024, time.March, 29, london), periodEnd(2024, time.April, 11, london)},
+		{"after the end of summer time", time.Date(2024, time.October, 28, 0, 30, 0, 0, london), date(2024, time.October, 25, london), periodEnd(2024, time.November, 7, london)},
+		{"UTC time on the next day locally", time.Date(2024, time.April, 11, 23, 30, 0, 0, time.UTC), date(2024, time.April, 12, london), periodEnd(2024, time.April, 25, london)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := biweekly.Period(tt.at)
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Errorf("Period(%v) = %v to %v, want %v to %v", tt.at, start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestPatternPeriods(t *testing.T) {
+	quarters := patternPeriods{anchor: date(2024, time.January, 1, time.UTC), weeks: []int{4, 4, 5}}
+	tests := []struct {
+		name      string
+		at        time.Time
+		wantStart time.Time
+		wantEnd   time.Time
+		wantName  string
+	}{
+		{"first period", date(2024, time.January, 1, time.UTC), date(2024, time.January, 1, time.UTC), periodEnd(2024, time.January, 28, time.UTC), "P1"},
+		{"second period", date(2024, time.February, 10, time.UTC), date(2024, time.January, 29, time.UTC), periodEnd(2024, time.February, 25, time.UTC), "P2"},
+		{"five week period", date(2024, time.March, 31, time.UTC), date(2024, time.February, 26, time.UTC), periodEnd(2024, time.March, 31, time.UTC), "P3"},
+		{"second quarter", date(2024, time.April, 1, time.UTC), date(2024, time.April, 1, time.UTC), periodEnd(2024, time.April, 28, time.UTC), "P4"},
+		{"before the anchor", date(2023, time.December, 31, time.UTC), date(2023, time.November, 27, time.UTC), periodEnd(2023, time.December, 31, time.UTC), "P12"},
+		{"next year", date(2024, time.December, 31, time.UTC), date(2024, time.December, 30, time.UTC), periodEnd(2025, time.January, 26, time.UTC), "P1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := quarters.Period(tt.at)
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Errorf("Period(%v) = %v to %v, want %v to %v", tt.at, start, end, tt.wantStart, tt.wantEnd)
+			}
+			if name := quarters.PeriodName(start); name != tt.wantName {
+				t.Errorf("PeriodName(%v) = %q, want %q", start, name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestCustomPeriods(t *testing.T) {
+	periods, err := parsePeriodDates("2024-03-04=C, 2024-01-01=A, 2024-01-29=B", time.UTC)
+	if err != nil {
+		t.Fatalf("parsePeriodDates: %v", err)
+	}
+	tests := []struct {
+		name      string
+		at        time.Time
+		wantStart time.Time
+		wantEnd   time.Time
+		wantName  string
+	}{
+		{"first listed period", date(2024, time.January, 15, time.UTC), date(2024, time.January, 1, time.UTC), periodEnd(2024, time.January, 28, time.UTC), "A"},
+		{"middle period", date(2024, time.February, 29, time.UTC), date(2024, time.January, 29, time.UTC), periodEnd(2024, time.March, 3, time.UTC), "B"},
+		{"last listed date", date(2024, time.March, 10, time.UTC), date(2024, time.March, 4, time.UTC), periodEnd(2024, time.April, 7, time.UTC), "C"},
+		{"after the list", date(2024, time.April, 10, time.UTC), date(2024, time.April, 8, time.UTC), periodEnd(2024, time.May, 12, time.UTC), ""},
+		{"before the list", date(2023, time.December, 20, time.UTC), date(2023, time.December, 4, time.UTC), periodEnd(2023, time.December, 31, time.UTC), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := periods.Period(tt.at)
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Errorf("Period(%v) = %v to %v, want %v to %v", tt.at, start, end, tt.wantStart, tt.wantEnd)
+			}
+			if name := periods.PeriodName(start); name != tt.wantName {
+				t.Errorf("PeriodName(%v) = %q, want %q", start, name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestParsePeriodDatesErrors(t *testing.T) {
+	for _, value := range []string{"", "2024-01-01", "2024-01-01,2024-01-01", "2024-01-01,01/02/2024"} {
+		if _, err := parsePeriodDates(value, time.UTC); err == nil {
+			t.Errorf("parsePeriodDates(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestGetTotalRemainingEventDate(t *testing.T) {
+	london := mustLoadLocation(t, "Europe/London")
+	tests := []struct {
+		name    string
+		on      string
+		start   time.Time
+		end     time.Time
+		want    time.Time
+		wantErr bool
+	}{
+		{"last day, monthly", "Last Day of the Month", date(2024, time.January, 25, london), periodEnd(2024, time.February, 24, london), date(2024, time.January, 31, london), false},
+		{"first day, monthly", "First Day of the Month", date(2024, time.January, 25, london), periodEnd(2024, time.February, 24, london), date(2024, time.February, 1, london), false},
+		{"pay date, monthly", "Pay Date", date(2024, time.January, 25, london), periodEnd(2024, time.February, 24, london), date(2024, time.January, 25, london), false},
+		{"last day, period ending on it", "Last Day of the Month", date(2024, time.February, 1, london), periodEnd(2024, time.February, 29, london), date(2024, time.February, 29, london), false},
+		{"first day, period starting on it", "First Day of the Month", date(2024, time.February, 1, london), periodEnd(2024, time.February, 29, london), date(2024, time.February, 1, london), false},
+		{"last day, December into January", "Last Day of the Month", date(2024, time.December, 25, london), periodEnd(2025, time.January, 24, london), date(2024, time.December, 31, london), false},
+		{"first day, December into January", "First Day of the Month", date(2024, time.December, 25, london), periodEnd(2025, time.January, 24, london), date(2025, time.January, 1, london), false},
+		{"last day, week without one", "Last Day of the Month", date(2024, time.January, 8, london), periodEnd(2024, time.January, 14, london), date(2024, time.January, 14, london), false},
+		{"first day, week without one", "First Day of the Month", date(2024, time.January, 8, london), periodEnd(2024, time.January, 14, london), date(2024, time.January, 8, london), false},
+		{"last day, summer time starting in the period", "Last Day of the Month", date(2024, time.March, 25, london), periodEnd(2024, time.April, 24, london), date(2024, time.March, 31, london), false},
+		{"unknown placement", "Sometime", date(2024, time.January, 25, london), periodEnd(2024, time.February, 24, london), time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getTotalRemainingEventDate(tt.start, tt.end, Config{TotalRemainingOn: tt.on})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getTotalRemainingEventDate() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("getTotalRemainingEventDate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
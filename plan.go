@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Plan is the set of calendar changes computed by a run, saved so it can be reviewed before it is applied.
+type Plan struct {
+	CreatedAt      time.Time     `json:"createdAt"`
+	TargetCalendar string        `json:"targetCalendar"`
+	Changes        []eventChange `json:"changes"`
+	Drift          []eventDrift  `json:"drift,omitempty"`
+	Period         string        `json:"period,omitempty"`    // The current pay period, start/end
+	Remaining      float64       `json:"remaining,omitempty"` // Its total as the "Total Remaining" event shows it
+
+	// What the apply phase records once the changes are written, not saved with the plan
+	periods []desiredEvent    // The "Total Remaining" events, current period first
+	actual  []*calendar.Event // The managed events on the calendar when the plan was made
+}
+
+func savePlan(plan *Plan, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create plan file: %v", err)
+	}
+	defer f.Close()
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plan)
+}
+
+func loadPlan(path string) (*Plan, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open plan file: %v", err)
+	}
+	defer f.Close()
+	plan := &Plan{}
+	if err := json.NewDecoder(f).Decode(plan); err != nil {
+		return nil, fmt.Errorf("unable to read plan file: %v", err)
+	}
+	return plan, nil
+}
+
+// printPlan writes a human readable summary of the planned changes to stdout.
+func printPlan(plan *Plan, config Config) {
+	if len(plan.Drift) > 0 {
+		fmt.Println("Events edited by hand:")
+		for _, d := range plan.Drift {
+			fmt.Printf("  %s\n", describeDrift(d, config))
+		}
+	}
+	if len(plan.Changes) == 0 {
+		fmt.Println("No changes, the calendar is up to date.")
+		return
+	}
+	fmt.Printf("Planned changes to calendar %s:\n", plan.TargetCalendar)
+	for _, change := range plan.Changes {
+		fmt.Printf("  %s\n", describeChange(change))
+	}
+}
+
+// runPlanOnly computes the changes a run would make and saves them to path without touching the calendar.
+func runPlanOnly(path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), getRunTimeout())
+	defer cancel()
+	srv, config, err := prepareRun(ctx, nil)
+	if err != nil {
+		return err
+	}
+	plan, err := planRun(ctx, srv, config, time.Now())
+	if err != nil {
+		return err
+	}
+	printPlan(plan, config)
+	if err := savePlan(plan, path); err != nil {
+		return err
+	}
+	fmt.Printf("Plan saved to %s, apply it with: paymentTracker apply -plan %s\n", path, path)
+	return nil
+}
+
+// runApplyCommand handles "apply -plan <file>", writing a previously saved plan to the calendar.
+func runApplyCommand(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	path := fs.String("plan", "plan.json", "plan file produced by -plan-only")
+	fs.Parse(args)
+
+	plan, err := loadPlan(*path)
+	if err != nil {
+		return err
+	}
+
+	planCtx, applyCtx, cancel := runContexts(shutdownContext())
+	defer cancel()
+	srv, config, err := prepareRun(planCtx, nil)
+	if err != nil {
+		return err
+	}
+	printPlan(plan, config)
+	config.TargetCalendar = plan.TargetCalendar
+	return applyEventChanges(applyCtx, srv, plan.Changes, config)
+}
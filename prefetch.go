@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// prefetchLead is how long before a pay period ends the plan for the next one is computed, so the
+// rollover only has to write it.
+const prefetchLead = 10 * time.Minute
+
+// prefetchedRun is the plan for the pay period starting at boundary, computed in the background
+// ahead of time. It is ready once done is closed.
+type prefetchedRun struct {
+	boundary time.Time
+	srv      CalendarProvider
+	config   Config
+	plan     *Plan
+	err      error
+	done     chan struct{}
+}
+
+// nextPeriodStart returns when the pay period after the current one starts.
+func nextPeriodStart(config Config, now time.Time) time.Time {
+	loc, _ := time.LoadLocation(config.TimeZone)
+	_, endDate := config.Periods.Period(now.In(loc))
+	return endDate.Add(time.Second)
+}
+
+// startPrefetch computes the plan for the pay period starting at boundary, prefetchLead before it.
+// The work runs in its own goroutine and is never retried: if it fails, the rollover falls back to
+// a full sync. History, archives, exports and the .ics feed are only written once the plan is applied,
+// at the boundary.
+func startPrefetch(ctx context.Context, conn *connection, boundary time.Time) *prefetchedRun {
+	p := &prefetchedRun{boundary: boundary, done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		if p.err = sleepContext(ctx, time.Until(boundary.Add(-prefetchLead))); p.err != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, getRunTimeout())
+		defer cancel()
+		p.srv, p.config, p.err = conn.prepare(ctx)
+		if p.err != nil {
+			return
+		}
+		p.plan, p.err = planRun(ctx, p.srv, p.config, boundary)
+	}()
+	return p
+}
+
+// apply writes the prefetched plan to the calendar. It fails without writing anything when the plan
+// is not ready yet or could not be computed.
+func (p *prefetchedRun) apply(ctx context.Context) error {
+	select {
+	case <-p.done:
+	default:
+		return fmt.Errorf("the plan is still being computed")
+	}
+	if p.err != nil {
+		return p.err
+	}
+	slog.Info("Applying the prefetched plan", "period_start", p.boundary.Format("2006-01-02"))
+	return applyRun(ctx, p.srv, p.plan, p.config)
+}
+
+// runAtBoundary is the run at the start of a pay period. It applies the prefetched plan, falling back
+// to a full sync when there is none or it no longer applies cleanly, such as after an event it
+// changes was edited in the meantime.
+func runAtBoundary(ctx context.Context, conn *connection, prefetch *prefetchedRun) error {
+	_, applyCtx, cancel := runContexts(ctx)
+	defer cancel()
+	if err := prefetch.apply(applyCtx); err != nil {
+		slog.Warn("Prefetched plan not used, syncing instead", "error", err)
+		return taskToRun(ctx, conn)
+	}
+	return nil
+}
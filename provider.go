@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Calendar services the tracker works with, chosen with PROVIDER.
+const (
+	providerGoogle  = "google"
+	providerOutlook = "outlook"
+	providerCaldav  = "caldav"
+	providerICS     = "ics"
+)
+
+// getProvider returns the calendar service set with PROVIDER, Google Calendar by default.
+func getProvider() string {
+	switch provider := strings.ToLower(os.Getenv("PROVIDER")); provider {
+	case "", providerGoogle:
+		return providerGoogle
+	case providerOutlook, providerCaldav, providerICS:
+		return provider
+	default:
+		slog.Warn("Invalid PROVIDER value, expected google, outlook, caldav or ics, using google", "value", provider)
+		return providerGoogle
+	}
+}
+
+// CalendarProvider is the calendar service payments are read from and the tracker's events are
+// written to. Events are exchanged as Google Calendar events whatever the service, and errors from
+// the service are reported as *googleapi.Error so status codes can be checked the same way.
+type CalendarProvider interface {
+	// ListEvents returns every event in the calendar matching the query, with recurring events
+	// expanded into their instances.
+	ListEvents(ctx context.Context, calendarID string, query EventQuery) ([]*calendar.Event, error)
+	GetEvent(ctx context.Context, calendarID, eventID string) (*calendar.Event, error)
+	// InsertEvent creates an event. The event's ID, when set, makes the insert idempotent.
+	InsertEvent(ctx context.Context, calendarID string, event *calendar.Event) (*calendar.Event, error)
+	// PatchEvent changes the fields set in event, UpdateEvent replaces the event as a whole. Both,
+	// like DeleteEvent, only go ahead while the event still has the given ETag, unless it is empty.
+	PatchEvent(ctx context.Context, calendarID, eventID, etag string, event *calendar.Event) error
+	UpdateEvent(ctx context.Context, calendarID, eventID, etag string, event *calendar.Event) error
+	DeleteEvent(ctx context.Context, calendarID, eventID, etag string) error
+	// ListCalendars returns the user's calendars, for resolving calendar names to IDs.
+	ListCalendars(ctx context.Context) ([]*calendar.CalendarListEntry, error)
+	CreateCalendar(ctx context.Context, name, timeZone string) (string, error)
+	DeleteCalendar(ctx context.Context, calendarID string) error
+}
+
+// EventQuery selects the events ListEvents returns. Zero fields do not restrict the result.
+type EventQuery struct {
+	Text        string    // Text the events mention
+	ICalUID     string    // iCalendar UID shared by copies of the event
+	Property    string    // Private extended property the events carry, as "name=value"
+	TimeMin     time.Time // Events ending after this time
+	TimeMax     time.Time // Events starting before this time
+	TimeZone    string    // Time zone the event times are given in, the calendar's when empty
+	ShowDeleted bool      // Include cancelled events
+}
+
+// maxEventsPageSize is the most events Google Calendar returns in one page of a list.
+const maxEventsPageSize = 2500
+
+// googleProvider is the Google Calendar API.
+type googleProvider struct {
+	service     *calendar.Service
+	sendUpdates string // Who is told about event writes: all, externalOnly or none
+	pageSize    int64  // Events per page of a list, 0 for the API's default
+}
+
+func (g *googleProvider) ListEvents(ctx context.Context, calendarID string, query EventQuery) ([]*calendar.Event, error) {
+	call := g.service.Events.List(calendarID).ShowDeleted(query.ShowDeleted).SingleEvents(true)
+	if query.Text != "" {
+		call = call.Q(query.Text)
+	}
+	if query.ICalUID != "" {
+		call = call.ICalUID(query.ICalUID)
+	}
+	if query.Property != "" {
+		call = call.PrivateExtendedProperty(query.Property)
+	}
+	if !query.TimeMin.IsZero() {
+		call = call.TimeMin(query.TimeMin.Format(time.RFC3339)).OrderBy("startTime")
+	}
+	if !query.TimeMax.IsZero() {
+		call = call.TimeMax(query.TimeMax.Format(time.RFC3339))
+	}
+	if query.TimeZone != "" {
+		call = call.TimeZone(query.TimeZone)
+	}
+	if g.pageSize > 0 {
+		call = call.MaxResults(g.pageSize)
+	}
+
+	// Results are paged; every page is read so large calendars are counted in full
+	var items []*calendar.Event
+	err := call.Pages(ctx, func(events *calendar.Events) error {
+		items = append(items, events.Items...)
+		return nil
+	})
+	return items, err
+}
+
+func (g *googleProvider) GetEvent(ctx context.Context, calendarID, eventID string) (*calendar.Event, error) {
+	return g.service.Events.Get(calendarID, eventID).Context(ctx).Do()
+}
+
+func (g *googleProvider) InsertEvent(ctx context.Context, calendarID string, event *calendar.Event) (*calendar.Event, error) {
+	return g.service.Events.Insert(calendarID, event).SendUpdates(g.sendUpdates).Context(ctx).Do()
+}
+
+func (g *googleProvider) PatchEvent(ctx context.Context, calendarID, eventID, etag string, event *calendar.Event) error {
+	call := g.service.Events.Patch(calendarID, eventID, event).SendUpdates(g.sendUpdates)
+	if etag != "" {
+		call.Header().Set("If-Match", etag)
+	}
+	_, err := call.Context(ctx).Do()
+	return err
+}
+
+func (g *googleProvider) UpdateEvent(ctx context.Context, calendarID, eventID, etag string, event *calendar.Event) error {
+	call := g.service.Events.Update(calendarID, eventID, event).SendUpdates(g.sendUpdates)
+	if etag != "" {
+		call.Header().Set("If-Match", etag)
+	}
+	_, err := call.Context(ctx).Do()
+	return err
+}
+
+func (g *googleProvider) DeleteEvent(ctx context.Context, calendarID, eventID, etag string) error {
+	call := g.service.Events.Delete(calendarID, eventID).SendUpdates(g.sendUpdates)
+	if etag != "" {
+		call.Header().Set("If-Match", etag)
+	}
+	return call.Context(ctx).Do()
+}
+
+func (g *googleProvider) ListCalendars(ctx context.Context) ([]*calendar.CalendarListEntry, error) {
+	var entries []*calendar.CalendarListEntry
+	err := g.service.CalendarList.List().Pages(ctx, func(list *calendar.CalendarList) error {
+		entries = append(entries, list.Items...)
+		return nil
+	})
+	return entries, err
+}
+
+func (g *googleProvider) CreateCalendar(ctx context.Context, name, timeZone string) (string, error) {
+	created, err := g.service.Calendars.Insert(&calendar.Calendar{Summary: name, TimeZone: timeZone}).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return created.Id, nil
+}
+
+func (g *googleProvider) DeleteCalendar(ctx context.Context, calendarID string) error {
+	return g.service.Calendars.Delete(calendarID).Context(ctx).Do()
+}
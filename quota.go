@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// quotaMonitor counts Calendar API responses signalling rate limiting or an exhausted quota, so the
+// run interval can be adapted to them.
+type quotaMonitor struct {
+	limited atomic.Int32
+}
+
+// quotaTransport is an http.RoundTripper that counts the quota responses of one client towards its
+// monitor, so the clients of several accounts can share one.
+type quotaTransport struct {
+	monitor *quotaMonitor
+	base    http.RoundTripper
+}
+
+// wrap returns a transport sending requests through base, http.DefaultTransport when nil, and
+// counting its quota responses.
+func (m *quotaMonitor) wrap(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &quotaTransport{monitor: m, base: base}
+}
+
+func (t *quotaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	m := t.monitor
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		m.limited.Add(1)
+	case http.StatusForbidden:
+		if isQuotaResponse(resp) {
+			m.limited.Add(1)
+		}
+	}
+	return resp, nil
+}
+
+// isQuotaResponse reports whether a 403 response is Google reporting a quota error, which it does
+// with a rate limit reason in the body. The body is left in place for the caller to read.
+func isQuotaResponse(resp *http.Response) bool {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return err == nil && isQuotaError(string(body))
+}
+
+// take returns the number of quota responses seen since the last call and resets the count.
+func (m *quotaMonitor) take() int {
+	return int(m.limited.Swap(0))
+}
+
+func isQuotaError(body string) bool {
+	for _, reason := range []string{"rateLimitExceeded", "userRateLimitExceeded", "quotaExceeded"} {
+		if strings.Contains(body, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// adaptTickInterval doubles the interval (up to MaxTickInterval) after a run that hit quota
+// limits and halves it back towards TickInterval after a healthy run.
+func adaptTickInterval(current time.Duration, quotaHits int, config Config) time.Duration {
+	if quotaHits > 0 {
+		return min(current*2, config.MaxTickInterval)
+	}
+	return max(current/2, config.TickInterval)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Calendar API requests that are rate limited or fail on the server are retried this many times,
+// waiting apiRetryDelay before the first retry and twice as long before each one after that, unless
+// the response's Retry-After asks for a different wait. No wait is longer than apiMaxRetryDelay.
+const (
+	apiRetries       = 5
+	apiRetryDelay    = time.Second
+	apiMaxRetryDelay = time.Minute
+)
+
+// apiLimiter spaces out the requests of every calendar client in the process, those of all accounts
+// and of the dashboard and API included, so bursts such as listing the payments of every future
+// period stay within the per-user quota.
+var apiLimiter = &rateLimiter{}
+
+// rateLimiter lets requests through at a steady rate, making each one wait for its turn.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration // Least time between requests, 0 for no limit
+	next     time.Time     // When the next request may be made
+}
+
+// setRate sets the requests allowed per second, where 0 removes the limit.
+func (l *rateLimiter) setRate(perSecond float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.interval = 0
+	if perSecond > 0 {
+		l.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+}
+
+// wait blocks until it is the caller's turn to make a request, or ctx is cancelled.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+	if !at.After(now) {
+		return nil
+	}
+	return sleepContext(ctx, at.Sub(now))
+}
+
+// retryTransport is an http.RoundTripper that waits its turn with apiLimiter before each request and
+// retries the requests that are rate limited or fail on the server.
+type retryTransport struct {
+	base http.RoundTripper
+}
+
+// retrying returns a transport sending requests through base, http.DefaultTransport when nil, with
+// rate limiting and retries.
+func retrying(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &retryTransport{base: base}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A request whose body cannot be read again is sent once
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	delay := apiRetryDelay
+	for attempt := 0; ; attempt++ {
+		if err := apiLimiter.wait(req.Context()); err != nil {
+			return nil, err
+		}
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		resp, err := t.base.RoundTrip(req)
+		if err != nil || attempt == apiRetries || !replayable || !isRetryableResponse(resp) {
+			return resp, err
+		}
+
+		wait := retryAfter(resp, delay)
+		resp.Body.Close()
+		slog.Warn("Calendar API request failed, retrying", "status", resp.StatusCode, "delay", wait, "attempt", attempt+1, "retries", apiRetries)
+		if err := sleepContext(req.Context(), wait); err != nil {
+			return nil, err
+		}
+		delay = min(delay*2, apiMaxRetryDelay)
+	}
+}
+
+// isRetryableResponse reports whether a response is worth retrying: the request was rate limited or
+// ran out of quota, or the server failed in a way that usually passes.
+func isRetryableResponse(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusForbidden:
+		return isQuotaResponse(resp)
+	}
+	return false
+}
+
+// retryAfter returns how long to wait before retrying a response: what its Retry-After header asks
+// for, in seconds or as a date, or otherwise fallback with up to half as much again added at random,
+// so clients that failed together do not retry together.
+func retryAfter(resp *http.Response, fallback time.Duration) time.Duration {
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return min(time.Duration(seconds)*time.Second, apiMaxRetryDelay)
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return min(max(time.Until(date), 0), apiMaxRetryDelay)
+		}
+	}
+	return fallback + time.Duration(rand.Int63n(int64(fallback)/2+1))
+}
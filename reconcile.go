@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// desiredEvent is a tracker-managed event as it should exist on the target calendar.
+type desiredEvent struct {
+	Event  *calendar.Event
+	Amount float64
+	Period PeriodTotal // Pay period the event totals
+}
+
+// eventChange is a single calendar mutation needed to bring the target calendar in line with the desired state.
+type eventChange struct {
+	Action   string          `json:"action"`             // "insert", "patch", "update" or "delete"
+	Event    *calendar.Event `json:"event,omitempty"`    // Desired state, nil for deletes
+	Existing *calendar.Event `json:"existing,omitempty"` // Current state, nil for inserts
+}
+
+// managedEventKey identifies the slot an event occupies: its type and the day it is on.
+// Events written before types were recorded count as "Total Remaining" events.
+func managedEventKey(item *calendar.Event) string {
+	eventType := totalRemainingEventType
+	if item.ExtendedProperties != nil && item.ExtendedProperties.Private[managedEventProperty] != "" {
+		eventType = item.ExtendedProperties.Private[managedEventProperty]
+	}
+	return eventType + "/" + item.Start.Date
+}
+
+// planEventChanges diffs the desired events against the managed events currently on the calendar
+// and returns the minimal set of inserts, updates and deletes that reconciles the two, along with
+// the events edited by hand since they were written, which DRIFT_POLICY decides the fate of.
+func planEventChanges(desired []desiredEvent, actual []*calendar.Event, config Config) ([]eventChange, []eventDrift) {
+	bySlot := make(map[string][]*calendar.Event)
+	for _, item := range actual {
+		key := managedEventKey(item)
+		bySlot[key] = append(bySlot[key], item)
+	}
+
+	var changes []eventChange
+	var drift []eventDrift
+	for _, d := range desired {
+		if hasAmount(d.Event) {
+			d.Event.ExtendedProperties.Private[fingerprintProperty] = eventFingerprint(d.Event)
+		}
+		key := managedEventKey(d.Event)
+		candidates := bySlot[key]
+		delete(bySlot, key)
+
+		if len(candidates) == 0 {
+			changes = append(changes, eventChange{Action: "insert", Event: d.Event})
+			continue
+		}
+
+		// Keep an event that already matches if there is one, otherwise rewrite the first
+		keep := 0
+		for i, item := range candidates {
+			if eventUnchanged(item, d, config) {
+				keep = i
+				break
+			}
+		}
+		existing := candidates[keep]
+		edited := hasAmount(d.Event) && editedByHand(existing)
+		if edited {
+			drift = append(drift, newEventDrift(existing, d, config))
+		}
+		switch {
+		case edited && config.DriftPolicy != driftOverwrite:
+			// The edit is kept
+		case edited:
+			changes = append(changes, eventChange{Action: "update", Event: d.Event, Existing: existing})
+		case eventUnchanged(existing, d, config):
+			// Nothing to write
+		case hasAmount(d.Event) && sameStyle(existing, d.Event, config):
+			changes = append(changes, eventChange{Action: "patch", Event: amountPatch(d.Event), Existing: existing})
+		default:
+			changes = append(changes, eventChange{Action: "update", Event: d.Event, Existing: existing})
+		}
+		for i, item := range candidates {
+			if i != keep {
+				changes = append(changes, eventChange{Action: "delete", Existing: item})
+			}
+		}
+	}
+
+	// Whatever is left no longer corresponds to a desired event, including events from past periods and
+	// future ones beyond the forecast horizon, which would otherwise never be updated again
+	var stale []*calendar.Event
+	for _, items := range bySlot {
+		stale = append(stale, items...)
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].Start.Date < stale[j].Start.Date })
+	for _, item := range stale {
+		changes = append(changes, eventChange{Action: "delete", Existing: item})
+	}
+	return changes, drift
+}
+
+// eventUnchanged reports whether an existing event already shows the desired wording, markers,
+// colour and categories, with an amount within WriteThreshold of the desired one. Events without an
+// amount, such as period bands, must match exactly.
+func eventUnchanged(item *calendar.Event, d desiredEvent, config Config) bool {
+	if !hasAmount(d.Event) {
+		return item.Summary == d.Event.Summary && item.Description == d.Event.Description &&
+			item.ColorId == d.Event.ColorId && item.Transparency == d.Event.Transparency &&
+			item.End != nil && item.End.Date == d.Event.End.Date
+	}
+	if !sameStyle(item, d.Event, config) {
+		return false
+	}
+	// Amounts in other currencies are not part of the amount compared below
+	if strings.Join(config.Currency.foreignAmounts(fullSummary(item)), " ") != strings.Join(config.Currency.foreignAmounts(fullSummary(d.Event)), " ") {
+		return false
+	}
+	// A payment moving between categories changes the breakdown even when the total barely moves
+	if config.Currency.StripAmounts(item.Description) != config.Currency.StripAmounts(d.Event.Description) {
+		return false
+	}
+	amount, ok := eventAmount(item, config)
+	return ok && math.Abs(amount-roundAmount(d.Amount)) <= config.WriteThreshold
+}
+
+// roundAmount rounds an amount to the cent, as it is written to the amount property. Sums such as
+// 12.34 + 1234.56 otherwise differ from what was written by a rounding error.
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// hasAmount reports whether a managed event carries an amount, as opposed to only marking dates.
+func hasAmount(event *calendar.Event) bool {
+	if event.ExtendedProperties == nil {
+		return false
+	}
+	_, ok := event.ExtendedProperties.Private[amountProperty]
+	return ok
+}
+
+// sameStyle reports whether two events differ at most in their amount.
+func sameStyle(item, desired *calendar.Event, config Config) bool {
+	return item.ColorId == desired.ColorId &&
+		config.Currency.StripAmounts(fullSummary(item)) == config.Currency.StripAmounts(fullSummary(desired))
+}
+
+// eventAmount returns the amount a managed event was written with, from its private property when
+// present and from its summary for events written before the property was recorded.
+func eventAmount(item *calendar.Event, config Config) (float64, bool) {
+	if item.ExtendedProperties != nil {
+		if value, ok := item.ExtendedProperties.Private[amountProperty]; ok {
+			amount, err := strconv.ParseFloat(value, 64)
+			return amount, err == nil
+		}
+	}
+	return parseAmountFromSummary(strings.TrimPrefix(fullSummary(item), config.EventMarker), config.Currency)
+}
+
+// amountPatch returns the subset of a desired event that changes when only its amount does.
+func amountPatch(event *calendar.Event) *calendar.Event {
+	return &calendar.Event{
+		Summary:            event.Summary,
+		Description:        event.Description,
+		ExtendedProperties: event.ExtendedProperties,
+		NullFields:         nullIfEmpty("Description", event.Description),
+	}
+}
+
+// nullIfEmpty lists the field for explicit clearing in a patch when its new value is empty.
+func nullIfEmpty(field, value string) []string {
+	if value == "" {
+		return []string{field}
+	}
+	return nil
+}
+
+// applyEventChanges performs the planned mutations on the target calendar. In a dry run, while
+// writes are paused for maintenance, or when the calendar turns out to be read only, the changes are
+// only logged.
+func applyEventChanges(ctx context.Context, srv CalendarProvider, changes []eventChange, config Config) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	if config.DryRun {
+		for _, change := range changes {
+			slog.Info("Dry run, would apply change", "change", describeChange(change))
+		}
+		return nil
+	}
+	if writesPaused(config) {
+		for _, change := range changes {
+			slog.Info("Maintenance active, not applying change", "change", describeChange(change))
+		}
+		return nil
+	}
+	if !canWriteEvents(config, config.TargetCalendar) {
+		for _, change := range changes {
+			slog.Warn("Calendar is read only, not applying change", "calendar", config.TargetCalendar, "change", describeChange(change))
+		}
+		return nil
+	}
+
+	counts := make(map[string]int)
+	for i, change := range changes {
+		// Writes to existing events are conditional on their ETag, so an event edited since the
+		// plan was made is not overwritten
+		var err error
+		switch change.Action {
+		case "insert":
+			err = insertManagedEvent(ctx, srv, change.Event, config)
+		case "patch":
+			err = srv.PatchEvent(ctx, config.TargetCalendar, change.Existing.Id, change.Existing.Etag, change.Event)
+		case "update":
+			err = srv.UpdateEvent(ctx, config.TargetCalendar, change.Existing.Id, change.Existing.Etag, change.Event)
+		case "delete":
+			err = srv.DeleteEvent(ctx, config.TargetCalendar, change.Existing.Id, change.Existing.Etag)
+		default:
+			err = fmt.Errorf("unknown action")
+		}
+		if isAccessDenied(err) {
+			denyWrites(config, config.TargetCalendar)
+			slog.Warn("Calendar is read only, not applying the remaining changes", "calendar", config.TargetCalendar, "remaining", len(changes)-i, "first", describeChange(change))
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to apply %s: %v", describeChange(change), err)
+		}
+		counts[change.Action]++
+	}
+
+	slog.Info("Applied calendar changes", "changes", len(changes), "inserted", counts["insert"], "patched", counts["patch"], "updated", counts["update"], "deleted", counts["delete"])
+	return nil
+}
+
+// idempotencyKey derives a deterministic event ID from the profile, target calendar, event type and
+// date, so the same slot always maps to the same ID. Google Calendar IDs allow the characters a-v and
+// 0-9, which hex digits satisfy.
+func idempotencyKey(event *calendar.Event, config Config) string {
+	sum := sha256.Sum256([]byte(config.Profile + "\x00" + config.TargetCalendar + "\x00" + managedEventKey(event)))
+	return "pt" + hex.EncodeToString(sum[:16])
+}
+
+// insertManagedEvent inserts an event under its idempotency key. A conflict means the ID is taken:
+// either an earlier attempt that timed out did succeed, which is left as is, or a previously deleted
+// event still holds the ID, in which case it is restored with the desired content.
+func insertManagedEvent(ctx context.Context, srv CalendarProvider, event *calendar.Event, config Config) error {
+	event.Id = idempotencyKey(event, config)
+	_, err := srv.InsertEvent(ctx, config.TargetCalendar, event)
+	if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != http.StatusConflict {
+		return err
+	}
+
+	existing, err := srv.GetEvent(ctx, config.TargetCalendar, event.Id)
+	if err != nil {
+		return err
+	}
+	if existing.Status != "cancelled" {
+		slog.Info("Event was already inserted by an earlier attempt", "event", event.Id)
+		return nil
+	}
+	event.Status = "confirmed"
+	return srv.UpdateEvent(ctx, config.TargetCalendar, event.Id, "", event)
+}
+
+// describeChange renders a change for logs, e.g. `update of "Total Remaining £120.00" on 2024-08-01`.
+func describeChange(change eventChange) string {
+	item := change.Existing
+	if item == nil {
+		item = change.Event
+	}
+	summary := item.Summary
+	if change.Event != nil {
+		summary = change.Event.Summary
+	}
+	return fmt.Sprintf("%s of %q on %s", change.Action, summary, item.Start.Date)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// runReportCommand handles "report": it prints the remaining payments of the current pay period and
+// their total, with each payee's share of the payments split with them.
+func runReportCommand(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	fs.Parse(args)
+	ctx, cancel := context.WithTimeout(context.Background(), getRunTimeout())
+	defer cancel()
+
+	srv, config, err := prepareRun(ctx, nil)
+	if err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		return fmt.Errorf("failed to load time zone '%s': %v", config.TimeZone, err)
+	}
+	startDate, endDate := config.Periods.Period(time.Now().In(loc))
+
+	events, err := listUpcomingPaymentEvents(ctx, srv, startDate, endDate, config)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Pay period %s\n", formatPeriod(config.Periods, startDate, endDate))
+	var total PeriodTotal
+	for _, item := range events {
+		if isPaid(item, config) {
+			continue
+		}
+		if currency, amount, ok := config.Currency.ParseForeign(item.Summary); ok {
+			total.AddForeign(currency.Symbol, amount)
+			fmt.Printf("  %s  %12s  %s\n", eventStartDate(item, loc).Format("2006-01-02"), currency.Format(amount), item.Summary)
+			continue
+		}
+		amount, ok := parseAmountFromSummary(item.Summary, config.Currency)
+		if !ok {
+			continue
+		}
+		total.Add(paymentCategory(item), amount)
+		line := fmt.Sprintf("  %s  %12s  %s", eventStartDate(item, loc).Format("2006-01-02"), config.Currency.Format(amount), item.Summary)
+		if payees := paymentPayees(item); len(payees) > 0 {
+			line += " (shared with " + strings.Join(payees, ", ") + ")"
+		}
+		fmt.Println(line)
+	}
+	if gifts := plannedGiftSpend(config.Occasions, startDate, endDate); gifts > 0 {
+		total.Add(giftsCategory, gifts)
+		fmt.Printf("  %10s  %12s  %s\n", "", config.Currency.Format(gifts), "Planned gifts")
+	}
+	if config.IncomeSource != "" {
+		total.Start, total.End = startDate, endDate
+		if err := addIncome(ctx, srv, &total, config, time.Now().In(loc)); err != nil {
+			return err
+		}
+		fmt.Printf("Income %s, already paid %s\n", config.Currency.Format(total.Income), config.Currency.Format(total.Paid))
+	}
+	fmt.Printf("%s %s\n", config.EventLabel, formatRemaining(total, config))
+	if breakdown := categoryBreakdown(total, config.Currency, ""); breakdown != "" {
+		fmt.Println(breakdown)
+	}
+	for _, line := range payeeBreakdown(payeeShares(events, config.Currency), config.Currency) {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// runForecastCommand handles "forecast": it prints the totals of the pay periods following the current one.
+func runForecastCommand(args []string) error {
+	fs := flag.NewFlagSet("forecast", flag.ExitOnError)
+	periods := fs.Int("periods", 0, "number of future pay periods to print (default FORECAST_PERIODS)")
+	fs.Parse(args)
+	ctx, cancel := context.WithTimeout(context.Background(), getRunTimeout())
+	defer cancel()
+
+	srv, config, err := prepareRun(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if *periods <= 0 {
+		*periods = config.ForecastPeriods
+	}
+	loc, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		return fmt.Errorf("failed to load time zone '%s': %v", config.TimeZone, err)
+	}
+	_, endDate := config.Periods.Period(time.Now().In(loc))
+
+	for i := 0; i < *periods; i++ {
+		var startDate time.Time
+		startDate, endDate = nextPeriod(config.Periods, endDate)
+		total, err := futurePeriodTotal(ctx, srv, startDate, endDate, config)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s  %12s\n", formatPeriod(config.Periods, startDate, endDate), formatRemaining(total, config))
+	}
+	return nil
+}
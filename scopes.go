@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+)
+
+// requiredScopes returns the Google OAuth scopes the enabled features need. Reading the calendar list
+// and events needs only read access; writing events adds the events scope, which unlike the full
+// calendar scope cannot change calendars, their sharing or settings.
+func requiredScopes(config Config) []string {
+	scopes := []string{calendar.CalendarReadonlyScope}
+	if !config.DryRun {
+		scopes = append(scopes, calendar.CalendarEventsScope)
+	}
+	return scopes
+}
+
+// impliedScopes lists the narrower Google scopes each scope includes.
+var impliedScopes = map[string][]string{
+	calendar.CalendarScope:         {calendar.CalendarEventsScope, calendar.CalendarReadonlyScope, calendar.CalendarEventsReadonlyScope},
+	calendar.CalendarEventsScope:   {calendar.CalendarEventsReadonlyScope},
+	calendar.CalendarReadonlyScope: {calendar.CalendarEventsReadonlyScope},
+}
+
+// missingScopes returns the scopes among needed that the granted ones do not include.
+func missingScopes(granted, needed []string) []string {
+	have := make(map[string]bool)
+	for _, scope := range granted {
+		have[scope] = true
+		for _, implied := range impliedScopes[scope] {
+			have[implied] = true
+		}
+	}
+	var missing []string
+	for _, scope := range needed {
+		if !have[scope] {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// tokenScopes returns the scopes a token was granted: those the token response lists, which with
+// incremental consent include earlier grants, or otherwise the ones asked for.
+func tokenScopes(tok *oauth2.Token, requested []string) []string {
+	if scope, ok := tok.Extra("scope").(string); ok && scope != "" {
+		return strings.Fields(scope)
+	}
+	return requested
+}
+
+// grantedScopes returns the scopes recorded with the saved token. Tokens saved before scopes were
+// recorded report none, and are taken to cover everything: they were all granted the full calendar
+// scope.
+func grantedScopes(file string) ([]string, bool) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	var saved struct {
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(f).Decode(&saved); err != nil || len(saved.Scopes) == 0 {
+		return nil, false
+	}
+	return saved.Scopes, true
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// runSelfTestCommand handles "selftest": an end-to-end check of the OAuth setup and configuration
+// against a throwaway calendar. It creates the calendar, writes synthetic payments into the next pay
+// period, runs the full sync, checks the resulting "Total Remaining" event and deletes the calendar.
+func runSelfTestCommand(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	fs.Parse(args)
+	ctx, cancel := context.WithTimeout(context.Background(), getRunTimeout())
+	defer cancel()
+
+	config := getConfig()
+	// The test calendar is created and deleted, which needs full access to calendars
+	config.OAuthScopes = append(config.OAuthScopes, calendar.CalendarScope)
+	srv, err := initializeCalendarService(ctx, nil, config)
+	if err != nil {
+		return fmt.Errorf("error initializing calendar service: %v", err)
+	}
+	loc, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		return fmt.Errorf("failed to load time zone '%s': %v", config.TimeZone, err)
+	}
+
+	testCalendarID, err := srv.CreateCalendar(ctx, "paymentTracker selftest "+time.Now().Format("2006-01-02 15:04:05"), config.TimeZone)
+	if err != nil {
+		return fmt.Errorf("unable to create test calendar: %v", err)
+	}
+	fmt.Printf("Created test calendar %s\n", testCalendarID)
+	defer func() {
+		// The test calendar is removed even when the test ran out of time
+		if err := srv.DeleteCalendar(context.WithoutCancel(ctx), testCalendarID); err != nil {
+			fmt.Printf("Unable to delete test calendar %s, remove it by hand: %v\n", testCalendarID, err)
+			return
+		}
+		fmt.Println("Deleted test calendar")
+	}()
+
+	// Point the whole pipeline at the test calendar and leave out anything that reads or writes elsewhere
+	config.PaymentCalendars = []string{testCalendarID}
+	config.TargetCalendar = testCalendarID
+	config.CalendarTimeZones = nil
+	config.Occasions = nil
+	config.BillEstimates = nil
+	config.ExportPath = ""
+	config.ICSFeedPath = ""
+	config.ForecastPeriods = 1
+	config.IncomeSource = ""
+	config.DryRun = false
+	if writesPaused(config) {
+		return fmt.Errorf("calendar writes are paused for maintenance, run the self-test once they resume")
+	}
+
+	// Synthetic payments on the first two days of the next pay period
+	_, endDate := config.Periods.Period(time.Now().In(loc))
+	startDate, endDate := nextPeriod(config.Periods, endDate)
+	amounts := []float64{12.34, 1234.56}
+	var expected float64
+	for i, amount := range amounts {
+		day := startDate.AddDate(0, 0, i)
+		_, err := srv.InsertEvent(ctx, testCalendarID, &calendar.Event{
+			Summary: "Payment " + config.Currency.Format(amount),
+			Start:   &calendar.EventDateTime{Date: day.Format("2006-01-02")},
+			End:     &calendar.EventDateTime{Date: day.AddDate(0, 0, 1).Format("2006-01-02")},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to write test payment: %v", err)
+		}
+		expected += amount
+	}
+	fmt.Printf("Wrote %d test payments for the period %s\n", len(amounts), formatPeriod(config.Periods, startDate, endDate))
+
+	plan, err := planRun(ctx, srv, config, time.Now())
+	if err != nil {
+		return fmt.Errorf("sync failed: %v", err)
+	}
+	if err := applyEventChanges(ctx, srv, plan.Changes, config); err != nil {
+		return fmt.Errorf("sync failed: %v", err)
+	}
+
+	// The next period's event must carry the total of the test payments
+	events, err := loadTotalRemainingEvents(ctx, srv, config)
+	if err != nil {
+		return err
+	}
+	eventDate, err := getTotalRemainingEventDate(startDate, endDate, config)
+	if err != nil {
+		return err
+	}
+	var found *calendar.Event
+	for _, item := range events {
+		if item.Start.Date == eventDate.Format("2006-01-02") {
+			found = item
+		}
+	}
+	if found == nil {
+		return fmt.Errorf("FAIL: no 'Total Remaining' event was written on %s", eventDate.Format("2006-01-02"))
+	}
+	if amount, ok := eventAmount(found, config); !ok || math.Abs(amount-expected) > 0.005 {
+		return fmt.Errorf("FAIL: expected %s on %s, found %q", config.Currency.Format(expected), found.Start.Date, found.Summary)
+	}
+	fmt.Printf("PASS: %q written on %s\n", found.Summary, found.Start.Date)
+
+	// A second sync with nothing changed must not write anything
+	plan, err = planRun(ctx, srv, config, time.Now())
+	if err != nil {
+		return fmt.Errorf("second sync failed: %v", err)
+	}
+	if len(plan.Changes) > 0 {
+		return fmt.Errorf("FAIL: second sync planned %d changes, expected none: %s", len(plan.Changes), describeChange(plan.Changes[0]))
+	}
+	fmt.Println("PASS: second sync made no changes")
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownContext returns a context that is cancelled when the process receives SIGINT or SIGTERM.
+// The changes a sync is applying when it arrives are still written, so a deploy never leaves the
+// calendar half reconciled; a second signal exits straight away.
+func shutdownContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		slog.Info("Received signal, stopping once the current calendar changes are written, send it again to exit now", "signal", sig.String())
+		cancel()
+		sig = <-signals
+		slog.Warn("Received signal again, exiting without waiting", "signal", sig.String())
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+// runContexts returns the contexts of a sync's plan and apply phases. Both end at RUN_TIMEOUT; the plan
+// phase also ends when ctx is cancelled, while the apply phase carries on, so changes that have
+// started being written are finished.
+func runContexts(ctx context.Context) (plan, apply context.Context, cancel context.CancelFunc) {
+	apply, cancelApply := context.WithTimeout(context.WithoutCancel(ctx), getRunTimeout())
+	plan, cancelPlan := context.WithCancel(apply)
+	stop := context.AfterFunc(ctx, cancelPlan)
+	return plan, apply, func() {
+		stop()
+		cancelPlan()
+		cancelApply()
+	}
+}
+
+// sleepContext waits for d, returning early with the context's error when it is cancelled first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
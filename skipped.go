@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// skipLog logs why events matched by a payment query are left out of the totals. An event is
+// queried for several periods in a run, so each reason is logged once per run. Each run gets its
+// own, though one may be used from several goroutines.
+type skipLog struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func newSkipLog() *skipLog {
+	return &skipLog{seen: make(map[string]bool)}
+}
+
+// record logs that an event was skipped for the given reason. A nil skipLog records nothing.
+func (s *skipLog) record(item *calendar.Event, reason string) {
+	if s == nil {
+		return
+	}
+	key := item.Id + "/" + reason
+	s.mu.Lock()
+	seen := s.seen[key]
+	s.seen[key] = true
+	s.mu.Unlock()
+	if seen {
+		return
+	}
+	date := ""
+	if item.Start != nil {
+		date = item.Start.Date + item.Start.DateTime
+	}
+	slog.Info("Skipped payment", "summary", item.Summary, "date", date, "event", item.Id, "reason", reason)
+}
+
+// dropDuplicates removes events that appear in more than one payment calendar, such as a bill shared
+// into a second calendar, keeping the first copy.
+func dropDuplicates(items []*calendar.Event, config Config) []*calendar.Event {
+	seen := make(map[string]bool)
+	var unique []*calendar.Event
+	for _, item := range items {
+		key := duplicateKey(item)
+		if key != "" && seen[key] {
+			config.Skipped.record(item, "duplicate of the same event in another payment calendar")
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, item)
+	}
+	return unique
+}
+
+// duplicateKey identifies an occurrence of an event across calendars by its iCalendar UID and start.
+func duplicateKey(item *calendar.Event) string {
+	if item.ICalUID == "" || item.Start == nil {
+		return ""
+	}
+	return item.ICalUID + "/" + item.Start.Date + item.Start.DateTime
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Default statuses of payment events that count towards the totals. Declined invitations are left
+// out, as are cancelled events, which the Calendar API only returns when they are asked for.
+const (
+	defaultEventStatuses    = "confirmed,tentative"
+	defaultResponseStatuses = "accepted,tentative,needsAction"
+)
+
+var (
+	eventStatuses    = []string{"confirmed", "tentative", "cancelled"}
+	responseStatuses = []string{"accepted", "tentative", "needsAction", "declined"}
+)
+
+// parseStatuses parses a comma separated list of statuses for the named setting. Unknown statuses
+// are logged and skipped; an empty or entirely invalid list falls back to the default.
+func parseStatuses(name, value, fallback string, known []string) map[string]bool {
+	statuses := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		matched := false
+		for _, status := range known {
+			if strings.EqualFold(entry, status) {
+				statuses[status] = true
+				matched = true
+			}
+		}
+		if !matched {
+			slog.Warn("Invalid "+name+" status, expected one of "+strings.Join(known, ", "), "entry", entry)
+		}
+	}
+	if len(statuses) == 0 && value != fallback {
+		return parseStatuses(name, fallback, fallback, known)
+	}
+	return statuses
+}
+
+// statusSkipReason explains why a payment event's status or the calendar owner's response to it
+// keeps it out of the totals, or returns "" when both are among the configured ones. Events the
+// owner was not invited to have no response to check.
+func statusSkipReason(item *calendar.Event, config Config) string {
+	status := item.Status
+	if status == "" {
+		status = "confirmed"
+	}
+	if !config.EventStatuses[status] {
+		return "event is " + status + ", which EVENT_STATUSES leaves out"
+	}
+	for _, attendee := range item.Attendees {
+		if attendee.Self && !config.ResponseStatuses[attendee.ResponseStatus] {
+			return "response is " + attendee.ResponseStatus + ", which RESPONSE_STATUSES leaves out"
+		}
+	}
+	return ""
+}
+
+// filterPaymentEvents keeps the payment events whose status counts, logging the others.
+func filterPaymentEvents(items []*calendar.Event, config Config) []*calendar.Event {
+	var counted []*calendar.Event
+	for _, item := range items {
+		if reason := statusSkipReason(item, config); reason != "" {
+			config.Skipped.record(item, reason)
+			continue
+		}
+		counted = append(counted, item)
+	}
+	return counted
+}
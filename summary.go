@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// SummaryData is what SUMMARY_TEMPLATE and DESCRIPTION_TEMPLATE are rendered with.
+type SummaryData struct {
+	Label      string             // EVENT_LABEL, or the label for EVENT_LANGUAGE
+	Marker     string             // EVENT_MARKER
+	Total      float64            // The amount the event shows
+	Count      int                // Number of upcoming payments in the total
+	Start, End time.Time          // Bounds of the pay period
+	Income     float64            // Income for the period, when tracked
+	Paid       float64            // Payments already made in the period, when income is tracked
+	Categories map[string]float64 // Subtotals by hashtag category
+	Breakdown  string             // Subtotals rendered one per line
+	Foreign    map[string]float64 // Upcoming payments in other currencies, by symbol
+	Amounts    string             // Total with the other currencies, e.g. "£812.00 + €40.00"
+}
+
+// parseSummaryTemplate parses a template for the "Total Remaining" event from the named setting
+// and renders it once with sample data so mistakes show up at start-up. An empty or invalid
+// template returns nil, leaving the built-in wording in place.
+func parseSummaryTemplate(name, text string, currency Currency) *template.Template {
+	if text == "" {
+		return nil
+	}
+	tmpl, err := template.New(name).Funcs(template.FuncMap{"currency": currency.Format}).Parse(text)
+	if err == nil {
+		err = tmpl.Execute(&strings.Builder{}, SummaryData{Categories: map[string]float64{}})
+	}
+	if err != nil {
+		slog.Warn("Invalid "+name+", using the default wording", "error", err)
+		return nil
+	}
+	return tmpl
+}
+
+// renderSummary executes a summary template, falling back to the default text if it fails.
+func renderSummary(tmpl *template.Template, data SummaryData, fallback string) string {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		slog.Error("Error rendering template", "template", tmpl.Name(), "error", err)
+		return fallback
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// summaryData collects the figures of a pay period for the summary templates.
+func summaryData(total PeriodTotal, config Config) SummaryData {
+	return SummaryData{
+		Label:      config.EventLabel,
+		Marker:     config.EventMarker,
+		Total:      total.Remaining(config),
+		Count:      len(total.Payments),
+		Start:      total.Start,
+		End:        total.End,
+		Income:     total.Income,
+		Paid:       total.Paid,
+		Categories: total.Categories,
+		Breakdown:  categoryBreakdown(total, config.Currency, config.EventLanguage),
+		Foreign:    total.Foreign,
+		Amounts:    formatRemaining(total, config),
+	}
+}
+
+// formatRemaining renders the amount a period's event shows, followed by the payments in other
+// currencies, which are not converted: "£812.00 + €40.00 + $15.00", or with an income source, where
+// they still come out of what is left, "£500.00 − €40.00".
+func formatRemaining(total PeriodTotal, config Config) string {
+	separator := " + "
+	if config.IncomeSource != "" {
+		separator = " − "
+	}
+	return strings.Join(append([]string{config.Currency.Format(total.Remaining(config))}, foreignTotals(total, config.Currency)...), separator)
+}
+
+// foreignTotals renders the period's payments in other currencies, one amount per currency,
+// ordered by symbol.
+func foreignTotals(total PeriodTotal, currency Currency) []string {
+	symbols := make([]string, 0, len(total.Foreign))
+	for symbol := range total.Foreign {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	amounts := make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		amounts = append(amounts, currency.foreignCurrency(symbol).Format(total.Foreign[symbol]))
+	}
+	return amounts
+}
+
+// defaultSummary is the built-in title, e.g. "💰 Total Remaining £120.00".
+func defaultSummary(total PeriodTotal, config Config) string {
+	summary := fmt.Sprintf("%s %s", config.EventLabel, formatRemaining(total, config))
+	if config.EventMarker != "" {
+		summary = config.EventMarker + " " + summary
+	}
+	return summary
+}
+
+// defaultDescription is the built-in description: the income figures when tracked and the category breakdown.
+func defaultDescription(total PeriodTotal, config Config) string {
+	breakdown := categoryBreakdown(total, config.Currency, config.EventLanguage)
+	if config.IncomeSource != "" {
+		income := fmt.Sprintf(eventText(config.EventLanguage, "Income %s"), config.Currency.Format(total.Income))
+		payments := fmt.Sprintf(eventText(config.EventLanguage, "Payments %s"), config.Currency.Format(total.Paid+total.Amount))
+		breakdown = strings.TrimSpace(income + "\n" + payments + "\n" + breakdown)
+	}
+	return breakdown
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// calendarCache holds the payment events of one calendar and the sync token that fetches what has
+// changed since they were read.
+type calendarCache struct {
+	SyncToken string                     `json:"syncToken"`
+	Events    map[string]*calendar.Event `json:"events"`
+}
+
+// Events mentioning any of these words are kept in the cache, standing in for the Q searches used
+// when querying the API directly.
+var cachedKeywords = []string{"payment", "income", "salary"}
+
+// eventCache keeps a local copy of the payment calendars in SYNC_STATE_PATH, brought up to date with
+// Calendar API sync tokens so each run only fetches the events that changed since the last one.
+type eventCache struct {
+	Keywords  string                    `json:"keywords"`
+	Cancelled bool                      `json:"cancelled"` // Cancelled events are kept, for EVENT_STATUSES
+	Calendars map[string]*calendarCache `json:"calendars"`
+}
+
+// loadEventCache reads the sync state. A cache kept for a different set of keywords, or from before
+// cancelled events were kept, lacks events that are needed now, so it is discarded and the calendars
+// are read in full again.
+func loadEventCache(path string) (*eventCache, error) {
+	keywords := strings.Join(cachedKeywords, ",")
+	cache := &eventCache{Keywords: keywords, Cancelled: true, Calendars: make(map[string]*calendarCache)}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open sync state: %v", err)
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(cache); err != nil {
+		return nil, fmt.Errorf("unable to read sync state: %v", err)
+	}
+	if cache.Keywords != keywords || !cache.Cancelled {
+		return &eventCache{Keywords: keywords, Cancelled: true, Calendars: make(map[string]*calendarCache)}, nil
+	}
+	return cache, nil
+}
+
+// save writes the cache to a temporary file and renames it into place, so an interrupted write never
+// leaves a sync token without the events it belongs to.
+func (c *eventCache) save(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".sync-*.json")
+	if err != nil {
+		return fmt.Errorf("unable to create sync state: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := json.NewEncoder(tmp).Encode(c); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write sync state: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write sync state: %v", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// sync brings the cached events of a calendar up to date. Without a sync token, or when the API
+// answers 410 Gone because the token has expired, the calendar is read in full again.
+func (c *eventCache) sync(ctx context.Context, srv *googleProvider, calendarID string) error {
+	cached := c.Calendars[calendarID]
+	if cached != nil && cached.SyncToken != "" {
+		err := c.fetch(ctx, srv, calendarID, cached)
+		if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != http.StatusGone {
+			return err
+		}
+		slog.Info("Sync token expired, resyncing in full", "calendar", calendarID)
+	}
+	cached = &calendarCache{Events: make(map[string]*calendar.Event)}
+	if err := c.fetch(ctx, srv, calendarID, cached); err != nil {
+		return err
+	}
+	c.Calendars[calendarID] = cached
+	return nil
+}
+
+// fetch reads the events changed since cached.SyncToken, or every event when it is empty, into
+// cached. Only events mentioning a cached keyword are kept, cancelled ones included so cachedEvents
+// can apply EVENT_STATUSES to them; events that no longer mention one are dropped, as are deleted
+// events the API only returns the ID of.
+func (c *eventCache) fetch(ctx context.Context, srv *googleProvider, calendarID string, cached *calendarCache) error {
+	call := srv.service.Events.List(calendarID).SingleEvents(true).ShowDeleted(true)
+	if cached.SyncToken != "" {
+		call = call.SyncToken(cached.SyncToken)
+	}
+	if srv.pageSize > 0 {
+		call = call.MaxResults(srv.pageSize)
+	}
+
+	pageToken := ""
+	for {
+		events, err := call.PageToken(pageToken).Context(ctx).Do()
+		if err != nil {
+			return err
+		}
+		for _, item := range events.Items {
+			if !mentionsAny(item, cachedKeywords) {
+				delete(cached.Events, item.Id)
+			} else {
+				cached.Events[item.Id] = item
+			}
+		}
+		if events.NextPageToken == "" {
+			cached.SyncToken = events.NextSyncToken
+			return nil
+		}
+		pageToken = events.NextPageToken
+	}
+}
+
+// mentionsAny reports whether an event's summary, description or location contains any of the
+// words, standing in for a Q search on the API.
+func mentionsAny(item *calendar.Event, words []string) bool {
+	text := strings.ToLower(item.Summary + " " + item.Description + " " + item.Location)
+	for _, word := range words {
+		if strings.Contains(text, strings.ToLower(word)) {
+			return true
+		}
+	}
+	return false
+}
+
+// events returns the cached events of a calendar matching query and overlapping startDate to
+// endDate, ordered by start time.
+func (c *eventCache) events(calendarID, query string, startDate, endDate time.Time) []*calendar.Event {
+	cached := c.Calendars[calendarID]
+	if cached == nil {
+		return nil
+	}
+	loc := startDate.Location()
+	var items []*calendar.Event
+	for _, item := range cached.Events {
+		start, end := eventBounds(item, loc)
+		if mentionsAny(item, []string{query}) && end.After(startDate) && start.Before(endDate) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return eventStartDate(items[i], loc).Before(eventStartDate(items[j], loc))
+	})
+	return items
+}
+
+// eventBounds returns when an event starts and ends, all-day events running from midnight to midnight in loc.
+func eventBounds(item *calendar.Event, loc *time.Location) (start, end time.Time) {
+	start = eventStartDate(item, loc)
+	end = start
+	if item.End == nil {
+		return start, end
+	}
+	if item.End.DateTime != "" {
+		if t, err := time.Parse(time.RFC3339, item.End.DateTime); err == nil {
+			end = t.In(loc)
+		}
+	} else if t, err := time.ParseInLocation("2006-01-02", item.End.Date, loc); err == nil {
+		end = t
+	}
+	return start, end
+}
+
+// cachedEvents selects the events of a calendar matching query for a period from the cache, applying
+// the same status and time zone rules as listCalendarEvents applies to API results.
+func cachedEvents(cache *eventCache, calendarID, query string, startDate, endDate time.Time, config Config) []*calendar.Event {
+	zone, overridden := config.CalendarTimeZones[calendarID]
+	if !overridden {
+		return filterPaymentEvents(cache.events(calendarID, query, startDate, endDate), config)
+	}
+
+	calendarLoc, _ := time.LoadLocation(zone)
+	loc := startDate.Location()
+	firstDay := time.Date(startDate.Year(), startDate.Month(), startDate.Day(), 0, 0, 0, 0, loc)
+	var items []*calendar.Event
+	for _, item := range filterPaymentEvents(cache.events(calendarID, query, startDate.AddDate(0, 0, -1), endDate.AddDate(0, 0, 1)), config) {
+		if date := eventLocalDate(item, calendarLoc, loc); !date.Before(firstDay) && !date.After(endDate) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Google expires the refresh tokens of apps whose OAuth consent screen is in testing mode seven days
+// after they are granted, after which every sync fails until the tracker is authorized again.
+const testingTokenLifetime = 7 * 24 * time.Hour
+
+// tokenWarningInterval is how often the run loop repeats a warning that the token expires soon.
+const tokenWarningInterval = 24 * time.Hour
+
+// reauthHint tells the user how to authorize an account again, the main one for "". A running
+// tracker reads the token files on every sync, so it needs no restart.
+func reauthHint(account string) string {
+	command := "paymentTracker auth"
+	if account != "" {
+		command += " -account " + account
+	}
+	return fmt.Sprintf(`Run "%s" to authorize again, adding -device on a machine without a browser.`, command)
+}
+
+// tokenAuthorizedAt returns when the saved token was granted, which refreshes leave unchanged.
+// Tokens saved before this was recorded return the zero time.
+func tokenAuthorizedAt(file string) time.Time {
+	f, err := os.Open(file)
+	if err != nil {
+		return time.Time{}
+	}
+	defer f.Close()
+	var saved struct {
+		AuthorizedAt time.Time `json:"authorizedAt"`
+	}
+	if err := json.NewDecoder(f).Decode(&saved); err != nil {
+		return time.Time{}
+	}
+	return saved.AuthorizedAt
+}
+
+// tokenExpiry returns when the token saved in file stops being accepted, or false when there is no
+// known limit: the app is published, or the token's age is unknown.
+func tokenExpiry(config Config, file string) (time.Time, bool) {
+	if config.TokenLifetime == 0 {
+		return time.Time{}, false
+	}
+	authorizedAt := tokenAuthorizedAt(file)
+	if authorizedAt.IsZero() {
+		return time.Time{}, false
+	}
+	return authorizedAt.Add(config.TokenLifetime), true
+}
+
+// tokenExpiryWarning returns a warning for each account whose token expires within TOKEN_WARNING_DAYS
+// of now, or has already, one per line, and an empty string when there are none.
+func tokenExpiryWarning(config Config, now time.Time) string {
+	var warnings []string
+	for _, account := range accountNames(config) {
+		expiry, ok := tokenExpiry(config, accountTokenFile(config, account))
+		if !ok || expiry.Sub(now) > config.TokenWarning {
+			continue
+		}
+		token := "the saved calendar token"
+		if account != "" {
+			token = "the calendar token of account " + account
+		}
+		if !expiry.After(now) {
+			warnings = append(warnings, fmt.Sprintf("Google stopped accepting %s on %s, syncs fail until it is replaced. %s", token, expiry.Format("Mon 2 Jan 15:04"), reauthHint(account)))
+		} else {
+			warnings = append(warnings, fmt.Sprintf("Google stops accepting %s on %s, in %s. %s", token, expiry.Format("Mon 2 Jan 15:04"), formatTokenAge(expiry.Sub(now)), reauthHint(account)))
+		}
+	}
+	return strings.Join(warnings, "\n")
+}
+
+// tokenWatch repeats the expiry warning through the notifiers at most once per tokenWarningInterval,
+// so a run loop syncing every few minutes does not send one each run.
+type tokenWatch struct {
+	warnedAt time.Time
+}
+
+// check logs and sends the expiry warning when one is due.
+func (t *tokenWatch) check(config Config, now time.Time) {
+	warning := tokenExpiryWarning(config, now)
+	if warning == "" || now.Sub(t.warnedAt) < tokenWarningInterval {
+		return
+	}
+	t.warnedAt = now
+	slog.Warn(warning)
+	notify(config, notifyToken, "Calendar access expires soon", warning)
+}
+
+// isTokenRevoked reports whether a refresh failed because Google no longer accepts the refresh
+// token: it expired, was revoked, or the password of the account changed.
+func isTokenRevoked(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "invalid_grant")
+}
+
+// formatTokenAge renders a duration in whole days, or hours below a day, e.g. "3 days".
+func formatTokenAge(d time.Duration) string {
+	if d < 24*time.Hour {
+		hours := int(d.Hours())
+		if hours == 1 {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", hours)
+	}
+	days := int(d.Hours() / 24)
+	if days == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", days)
+}
+
+// printTokenStatus prints when the saved token of an account was granted, its scopes and, with
+// OAUTH_TESTING_MODE, when it expires.
+func printTokenStatus(config Config, account string, now time.Time) error {
+	file := accountTokenFile(config, account)
+	if _, err := tokenFromFile(file); err != nil {
+		return fmt.Errorf("no usable token in %s: %v", file, err)
+	}
+	fmt.Printf("Token file: %s\n", file)
+	if authorizedAt := tokenAuthorizedAt(file); authorizedAt.IsZero() {
+		fmt.Println("Authorized: unknown, the token was saved before this was recorded")
+	} else {
+		fmt.Printf("Authorized: %s, %s ago\n", authorizedAt.Format("2006-01-02 15:04"), formatTokenAge(now.Sub(authorizedAt)))
+	}
+	if scopes, ok := grantedScopes(file); ok {
+		fmt.Printf("Scopes: %s\n", strings.Join(scopes, " "))
+	}
+	switch expiry, ok := tokenExpiry(config, file); {
+	case config.TokenLifetime == 0:
+		fmt.Println("Expires: no fixed limit")
+	case !ok:
+		fmt.Println("Expires: unknown, authorize again to start tracking it")
+	case !expiry.After(now):
+		fmt.Printf("Expired: %s\n", expiry.Format("2006-01-02 15:04"))
+	default:
+		fmt.Printf("Expires: %s, in %s\n", expiry.Format("2006-01-02 15:04"), formatTokenAge(expiry.Sub(now)))
+	}
+	return nil
+}
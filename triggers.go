@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Trigger events, sent to TRIGGER_WEBHOOK_URL after a sync and listed by the polling endpoints, so
+// no-code services such as Zapier and IFTTT can act on them.
+const (
+	triggerNewPayment = "new_payment" // A payment appeared on a payment calendar
+	triggerTotalBelow = "total_below" // The current period's total fell below TRIGGER_TOTAL_BELOW
+)
+
+// paymentTrigger is the payload of a new_payment trigger. Zapier reads the flat fields and tells
+// triggers apart by ID; IFTTT only passes on Value1 to Value3.
+type paymentTrigger struct {
+	Event string `json:"event"`
+	apiPayment
+	Value1 string `json:"value1"` // Summary
+	Value2 string `json:"value2"` // Amount as its currency writes it
+	Value3 string `json:"value3"` // Date
+}
+
+// totalTrigger is the payload of a total_below trigger. Its ID combines the period and threshold, so
+// Zapier fires once per pay period rather than on every poll.
+type totalTrigger struct {
+	Event     string  `json:"event"`
+	ID        string  `json:"id"`
+	Start     string  `json:"start"`
+	End       string  `json:"end"`
+	Remaining float64 `json:"remaining"`
+	Formatted string  `json:"formatted"`
+	Threshold float64 `json:"threshold"`
+	Currency  string  `json:"currency"`
+	Value1    string  `json:"value1"` // Remaining as the "Total Remaining" event shows it
+	Value2    string  `json:"value2"` // Threshold
+	Value3    string  `json:"value3"` // Period, start to end
+}
+
+func newPaymentTrigger(payment apiPayment) paymentTrigger {
+	return paymentTrigger{
+		Event:      triggerNewPayment,
+		apiPayment: payment,
+		Value1:     payment.Summary,
+		Value2:     payment.Formatted,
+		Value3:     payment.Date,
+	}
+}
+
+func newTotalTrigger(start, end string, remaining float64, formatted string, threshold float64, currency Currency) totalTrigger {
+	return totalTrigger{
+		Event:     triggerTotalBelow,
+		ID:        start + ":" + strconv.FormatFloat(threshold, 'f', -1, 64),
+		Start:     start,
+		End:       end,
+		Remaining: roundAmount(remaining),
+		Formatted: formatted,
+		Threshold: threshold,
+		Currency:  currency.Symbol,
+		Value1:    formatted,
+		Value2:    currency.Format(threshold),
+		Value3:    start + " to " + end,
+	}
+}
+
+// plannedTriggers returns the triggers a sync sends once its changes are written: a new_payment for
+// each payment recordHistory saw for the first time, and a total_below when the current period's
+// total drops below the threshold, judged against the "Total Remaining" event on the calendar.
+func plannedTriggers(newPayments []*calendar.Event, current desiredEvent, actual []*calendar.Event, config Config, loc *time.Location) []interface{} {
+	if config.TriggerURL == "" {
+		return nil
+	}
+	var triggers []interface{}
+	for _, item := range newPayments {
+		if payment, ok := newAPIPayment(item, config, loc); ok {
+			triggers = append(triggers, newPaymentTrigger(payment))
+		}
+	}
+	if config.TriggerBelow > 0 && current.Amount < config.TriggerBelow {
+		wasAbove := true
+		for _, item := range actual {
+			if managedEventKey(item) == managedEventKey(current.Event) {
+				previous, ok := eventAmount(item, config)
+				wasAbove = !ok || previous >= config.TriggerBelow
+				break
+			}
+		}
+		if wasAbove {
+			start, end := current.Period.Start.Format("2006-01-02"), current.Period.End.Format("2006-01-02")
+			triggers = append(triggers, newTotalTrigger(start, end, current.Amount, formatRemaining(current.Period, config), config.TriggerBelow, config.Currency))
+		}
+	}
+	return triggers
+}
+
+// sendTriggers posts each trigger to TRIGGER_WEBHOOK_URL, with {event} in the URL replaced by the
+// trigger's event for services like IFTTT that take it there. Like notifications, nothing is sent for
+// dry runs, each trigger is only sent once while writes are paused, and failures are only logged.
+func sendTriggers(triggers []interface{}, config Config) {
+	if config.TriggerURL == "" || config.DryRun {
+		return
+	}
+	for _, trigger := range triggers {
+		var event, id string
+		switch t := trigger.(type) {
+		case paymentTrigger:
+			event, id = t.Event, t.ID
+		case totalTrigger:
+			event, id = t.Event, t.ID
+		}
+		if !firstAnnouncement(config, "trigger:"+event+":"+id) {
+			continue
+		}
+		body, err := json.Marshal(trigger)
+		if err == nil {
+			endpoint := strings.ReplaceAll(config.TriggerURL, "{event}", event)
+			err = postNotification(endpoint, "application/json", bytes.NewReader(body), nil)
+		}
+		if err != nil {
+			slog.Error("Error sending trigger", "event", event, "error", err)
+		}
+	}
+}
+
+// newPaymentTriggers answers GET /api/v1/triggers/new-payments, the polling trigger for new payments:
+// the payments of the current pay period, latest first, which Zapier tells apart by ID.
+func (api *apiServer) newPaymentTriggers(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), getRunTimeout())
+	defer cancel()
+	srv, config, err := api.conn.current()
+	if err != nil {
+		writeAPIError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	loc, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	from, to := config.Periods.Period(time.Now().In(loc))
+	events, err := listPaymentEvents(ctx, srv, from, to, config)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	triggers := []paymentTrigger{}
+	for _, item := range events {
+		if payment, ok := newAPIPayment(item, config, loc); ok {
+			triggers = append(triggers, newPaymentTrigger(payment))
+		}
+	}
+	sort.SliceStable(triggers, func(i, j int) bool { return triggers[i].Date > triggers[j].Date })
+	writeJSON(w, http.StatusOK, triggers)
+}
+
+// totalBelowTriggers answers GET /api/v1/triggers/total-below, the polling trigger for a low total:
+// the current period as a single trigger while its total is below the threshold, from the threshold
+// parameter or TRIGGER_TOTAL_BELOW, and an empty list otherwise.
+func (api *apiServer) totalBelowTriggers(w http.ResponseWriter, r *http.Request) {
+	threshold := api.threshold
+	if value := r.URL.Query().Get("threshold"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= 0 {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid threshold %q, expected a positive amount", value))
+			return
+		}
+		threshold = parsed
+	}
+	if threshold <= 0 {
+		writeAPIError(w, http.StatusBadRequest, "no threshold, pass one as threshold or set TRIGGER_TOTAL_BELOW")
+		return
+	}
+	period, err := api.cachedPeriod(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	triggers := []totalTrigger{}
+	if period.Remaining < threshold {
+		triggers = append(triggers, newTotalTrigger(period.Start, period.End, period.Remaining, period.Formatted, threshold, api.currency))
+	}
+	writeJSON(w, http.StatusOK, triggers)
+}
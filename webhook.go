@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Changes often arrive in bursts, e.g. while a recurring payment is edited, so a run waits this long
+// after the first notification to take in the rest.
+const webhookDebounce = 5 * time.Second
+
+// calendarWatcher keeps Calendar API watch channels open on the payment calendars and turns their
+// push notifications into run triggers, so totals follow edits without waiting for the next tick.
+type calendarWatcher struct {
+	address  string            // Public HTTPS URL notifications are delivered to
+	token    string            // Secret Google echoes back on every notification
+	service  *calendar.Service // Service the channels were opened with
+	channels []*calendar.Channel
+	expires  time.Time
+	trigger  chan struct{}
+}
+
+func newCalendarWatcher(address string) (*calendarWatcher, error) {
+	token, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	return &calendarWatcher{address: address, token: token, trigger: make(chan struct{}, 1)}, nil
+}
+
+// ServeHTTP receives push notifications. Anything without the channel token is rejected; the initial
+// "sync" message sent when a channel opens is acknowledged without triggering a run.
+func (w *calendarWatcher) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Goog-Channel-Token")), []byte(w.token)) != 1 {
+		http.Error(rw, "unknown channel", http.StatusForbidden)
+		return
+	}
+	if r.Header.Get("X-Goog-Resource-State") != "sync" {
+		select {
+		case w.trigger <- struct{}{}:
+		default: // A run is already pending
+		}
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+// renew opens fresh watch channels on the payment calendars once the current ones are within margin
+// of expiring, and stops the old ones. It uses the run loop's connection.
+func (w *calendarWatcher) renew(ctx context.Context, conn *connection, margin time.Duration) error {
+	if time.Until(w.expires) > margin {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, getRunTimeout())
+	defer cancel()
+	provider, config, err := conn.current()
+	if err != nil {
+		return err
+	}
+	google, ok := provider.(*googleProvider)
+	if !ok {
+		return fmt.Errorf("push notifications are only supported with PROVIDER=google")
+	}
+	srv := google.service
+
+	var channels []*calendar.Channel
+	expires := time.Time{}
+	for _, calendarID := range config.PaymentCalendars {
+		id, err := randomID()
+		if err != nil {
+			return err
+		}
+		channel, err := srv.Events.Watch(calendarID, &calendar.Channel{
+			Id:      id,
+			Type:    "web_hook",
+			Address: w.address,
+			Token:   w.token,
+		}).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("unable to watch calendar %s: %v", calendarID, err)
+		}
+		channels = append(channels, channel)
+		if channelExpires := time.UnixMilli(channel.Expiration); expires.IsZero() || channelExpires.Before(expires) {
+			expires = channelExpires
+		}
+	}
+
+	for _, channel := range w.channels {
+		if err := srv.Channels.Stop(channel).Context(ctx).Do(); err != nil {
+			slog.Warn("Unable to stop watch channel", "channel", channel.Id, "error", err)
+		}
+	}
+	w.service = srv
+	w.channels = channels
+	w.expires = expires
+	slog.Info("Watching payment calendars for changes", "calendars", len(channels), "until", expires.Format(time.RFC3339))
+	return nil
+}
+
+// stop closes the watch channels, so Google stops sending notifications once the tracker is gone.
+func (w *calendarWatcher) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, channel := range w.channels {
+		if err := w.service.Channels.Stop(channel).Context(ctx).Do(); err != nil {
+			slog.Warn("Unable to stop watch channel", "channel", channel.Id, "error", err)
+		}
+	}
+	w.channels = nil
+}
+
+// settle waits out the rest of a burst of notifications after the first one has come in, and
+// discards the trigger they left behind.
+func (w *calendarWatcher) settle() {
+	time.Sleep(webhookDebounce)
+	select {
+	case <-w.trigger:
+	default:
+	}
+}
+
+// startWebhookServer serves the push notification receiver on addr.
+func startWebhookServer(addr string, watcher *calendarWatcher) {
+	mux := http.NewServeMux()
+	mux.Handle("/", watcher)
+	go func() {
+		slog.Info("Receiving calendar notifications", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("Webhook server stopped", "error", err)
+		}
+	}()
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("unable to generate ID: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}